@@ -6,21 +6,28 @@ package cmd
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"bitbucket.org/clivern/beat/core/model"
 	"bitbucket.org/clivern/beat/core/module"
 	"bitbucket.org/clivern/beat/core/util"
 
-	"github.com/briandowns/spinner"
 	"github.com/logrusorgru/aurora/v3"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
-// DatasetFile var
-var DatasetFile string
+// DatasetFiles var
+var DatasetFiles []string
 
 // OutputFile var
 var OutputFile string
@@ -28,6 +35,24 @@ var OutputFile string
 // Config var
 var Config string
 
+// InputFormat var
+var InputFormat string
+
+// Compression var
+var Compression string
+
+// OutputFormat var
+var OutputFormat string
+
+// Resume var
+var Resume bool
+
+// ReportFile var
+var ReportFile string
+
+// MetricsAddr var
+var MetricsAddr string
+
 var calculateCmd = &cobra.Command{
 	Use:   "calculate",
 	Short: "Calculate fare for a big set of rides",
@@ -36,13 +61,16 @@ var calculateCmd = &cobra.Command{
 
 // CalculateHandler runs the calculate command handler
 func CalculateHandler(_ *cobra.Command, args []string) {
-	spin := spinner.New(spinner.CharSets[27], 100*time.Millisecond)
-	spin.Color("green")
-	spin.Start()
+	// Keep the standard output for the data if the output file is -
+	// the logs and the result go to the standard error
+	var console io.Writer = os.Stdout
 
-	result, err := calculateHandler(args...)
+	if OutputFile == module.StdStream {
+		console = os.Stderr
+		log.SetOutput(os.Stderr)
+	}
 
-	spin.Stop()
+	result, err := calculateHandler(args...)
 
 	// If command failed due to wrong command flags or non existent files,
 	// stop execution
@@ -50,20 +78,235 @@ func CalculateHandler(_ *cobra.Command, args []string) {
 		panic(err)
 	}
 
-	fmt.Println(aurora.Green(result))
+	fmt.Fprintln(console, aurora.Green(result))
 }
 
-func calculateHandler(_ ...string) (string, error) {
+func calculateHandler(args ...string) (string, error) {
 	if Verbose {
 		log.SetLevel(log.DebugLevel)
 	}
 
 	log.Debug("calculate command got called.")
 
-	content, err := util.ReadFile(Config)
+	if err := loadConfig(); err != nil {
+		return "", err
+	}
+
+	// Extra arguments are dataset files too like the files of a glob expanded by the shell
+	datasetFiles, format, err := openDatasetFiles(args)
+
+	if err != nil {
+		return "", err
+	}
+
+	loader, err := module.NewRideLoader(format)
+
+	if err != nil {
+		return "", err
+	}
+
+	var outputFiles []string
+
+	if viper.GetBool("output.per_input") {
+		outputFiles, err = module.GetOutputPaths(datasetFiles, OutputFile, viper.GetString("output.template"))
+
+		if err != nil {
+			return "", fmt.Errorf(
+				"Error while building the output files from output.template %s: %s",
+				viper.GetString("output.template"),
+				err.Error(),
+			)
+		}
+	}
+
+	var checkpoint *module.Checkpoint
+
+	// Resuming keeps writing checkpoints
+	if viper.GetBool("checkpoint.enabled") || Resume {
+		if outputFiles != nil {
+			return "", fmt.Errorf("Checkpoints can't be used with output.per_input")
+		}
+
+		// The review file and the fare outlier stats of the anomaly detector would start over
+		if Resume && viper.GetBool("anomaly.enabled") {
+			return "", fmt.Errorf("The --resume flag can't be used with anomaly.enabled")
+		}
+
+		checkpoint, err = module.NewCheckpoint(datasetFiles, OutputFile, OutputFormat, Compression)
+
+		if err != nil {
+			return "", fmt.Errorf(
+				"Error while creating the checkpoint of output file %s: %s",
+				OutputFile,
+				err.Error(),
+			)
+		}
+
+		if Resume {
+			found, err := checkpoint.Load()
+
+			if err != nil {
+				return "", fmt.Errorf(
+					"Error while resuming output file %s: %s",
+					OutputFile,
+					err.Error(),
+				)
+			}
+
+			if found {
+				log.WithFields(log.Fields{
+					"output_file": OutputFile,
+					"rows":        checkpoint.Rows,
+				}).Info("Resume output file from the last checkpoint")
+			} else {
+				log.WithField("output_file", OutputFile).Warn("No checkpoint found, start from the beginning")
+			}
+		}
+	}
+
+	processor, closeProcessor, err := newRideProcessor()
+
+	if err != nil {
+		return "", err
+	}
+
+	defer closeProcessor()
+
+	pipeline := &module.Pipeline{
+		DatasetFiles: datasetFiles,
+		OutputFile:   OutputFile,
+		OutputFiles:  outputFiles,
+		Format:       OutputFormat,
+		Compression:  Compression,
+		Loader:       loader,
+		Processor:    processor,
+		Checkpoint:   checkpoint,
+	}
+
+	if viper.GetBool("progress.enabled") {
+		pipeline.Progress = module.NewProgress(datasetFiles)
+	}
+
+	pipeline.Stats = module.NewRunStats()
+
+	if MetricsAddr != "" {
+		pipeline.Metrics = module.NewMetrics()
+
+		server, err := module.StartMetricsServer(MetricsAddr, pipeline.Metrics)
+
+		if err != nil {
+			return "", fmt.Errorf(
+				"Error while starting the metrics server on %s: %s",
+				MetricsAddr,
+				err.Error(),
+			)
+		}
+
+		log.WithField("addr", server.Addr()).Info("Serve metrics on /metrics and pprof on /debug/pprof/")
+
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			server.Shutdown(ctx)
+		}()
+	}
+
+	ctx, abort := context.WithCancel(context.Background())
+	defer abort()
+
+	stop := make(chan struct{})
+	interrupted := handleSignals(ctx, stop, abort, "Interrupted, storing the fares calculated so far. Interrupt again to abort")
+
+	stopReport := startProgressReport(pipeline.Progress)
+
+	err = pipeline.Run(ctx, stop)
+
+	stopReport()
 
 	if err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("Aborted, the calculated fares were discarded")
+		}
+
+		// The storing errors name the output file
 		return "", fmt.Errorf(
+			"Error while processing dataset files %s: %s",
+			strings.Join(datasetFiles, ", "),
+			err.Error(),
+		)
+	}
+
+	result := "Ride data processed successfully!"
+
+	if interrupted() && checkpoint != nil && !viper.GetBool("anomaly.enabled") {
+		result = "Interrupted, continue the run with --resume"
+	} else if interrupted() {
+		result = "Interrupted, the rides read before the interruption are processed"
+	}
+
+	if processor.Cache != nil {
+		hits, misses := processor.Cache.Stats()
+		result = fmt.Sprintf("%s Fare cache: %d hits, %d misses", result, hits, misses)
+	}
+
+	report := pipeline.Stats.Report()
+
+	if ReportFile != "" {
+		if err := module.WriteStatsReport(ReportFile, report); err != nil {
+			return "", fmt.Errorf(
+				"Error while writing report file %s: %s",
+				ReportFile,
+				err.Error(),
+			)
+		}
+	}
+
+	return fmt.Sprintf("%s\n%s", result, report), nil
+}
+
+// openDatasetFiles expands the dataset files of the flags and the arguments then
+// gets their format
+func openDatasetFiles(args []string) ([]string, string, error) {
+	datasetFiles, err := module.ExpandPaths(append(DatasetFiles, args...))
+
+	if err != nil {
+		return nil, "", fmt.Errorf(
+			"Error while reading dataset files %s: %s",
+			strings.Join(DatasetFiles, ", "),
+			err.Error(),
+		)
+	}
+
+	format := InputFormat
+
+	if format == "" || format == "auto" {
+		format, err = module.DetectFormat(datasetFiles[0], Compression)
+
+		if err != nil {
+			return nil, "", fmt.Errorf(
+				"Error while reading dataset file %s: %s",
+				datasetFiles[0],
+				err.Error(),
+			)
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"dataset_files": datasetFiles,
+		"format":        format,
+	}).Debug("Dataset format detected")
+
+	return datasetFiles, format, nil
+}
+
+// loadConfig loads the config file and configures the logger, the distance
+// model and the units from it
+func loadConfig() error {
+	content, err := util.ReadFile(Config)
+
+	if err != nil {
+		return fmt.Errorf(
 			"Error while loading config file %s: %s",
 			Config,
 			err.Error(),
@@ -74,38 +317,218 @@ func calculateHandler(_ ...string) (string, error) {
 	err = viper.ReadConfig(bytes.NewBuffer([]byte(content)))
 
 	if err != nil {
-		return "", fmt.Errorf(
+		return fmt.Errorf(
 			"Error while loading config file content %s: %s",
 			Config,
 			err.Error(),
 		)
 	}
 
-	log.Debug(fmt.Sprintf("Config file %s loaded successfully", Config))
-
-	channel, err := module.GenerateData(DatasetFile)
+	err = util.ConfigureLogger(viper.GetString("log.level"), viper.GetString("log.format"))
 
 	if err != nil {
-		return "", fmt.Errorf(
-			"Error while reading dataset file %s: %s",
-			DatasetFile,
+		return fmt.Errorf(
+			"Error while loading config file %s: %s",
+			Config,
 			err.Error(),
 		)
 	}
 
-	outChannel := module.ProcessData(channel)
+	// The verbose flag overrides the configured log level
+	if Verbose {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	util.SegmentLogSampler.SetRate(viper.GetInt("log.segment_sample_rate"))
 
-	err = module.StoreData(OutputFile, outChannel)
+	log.WithField("config_file", Config).Debug("Config file loaded")
+
+	calculator, err := model.NewDistanceCalculator(viper.GetString("distance.model"))
 
 	if err != nil {
-		return "", fmt.Errorf(
-			"Error while storing date to file %s: %s",
-			OutputFile,
+		return fmt.Errorf(
+			"Error while loading config file %s: %s",
+			Config,
+			err.Error(),
+		)
+	}
+
+	model.SetDistanceCalculator(calculator)
+
+	if err := model.ValidateUnits(); err != nil {
+		return fmt.Errorf(
+			"Error while loading config file %s: %s",
+			Config,
 			err.Error(),
 		)
 	}
 
-	return "Ride data processed successfully!", nil
+	return nil
+}
+
+// newRideProcessor creates the ride processor of the config with the optional
+// validator, map matcher, anomaly detector and fare cache. The returned function
+// closes the anomaly detector and the fare cache
+func newRideProcessor() (*module.RideProcessor, func(), error) {
+	validator, matcher, err := newPricingStages()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var detector *module.AnomalyDetector
+
+	if viper.GetBool("anomaly.enabled") {
+		detector, err = module.NewAnomalyDetector(viper.GetString("anomaly.review_file"))
+
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var cache *module.FareCache
+
+	if viper.GetBool("fare_cache.enabled") {
+		cache, err = module.NewFareCache(viper.GetString("app.cache_dir"))
+
+		if err != nil {
+			if detector != nil {
+				detector.Close()
+			}
+
+			return nil, nil, fmt.Errorf(
+				"Error while opening the fare cache in %s: %s",
+				viper.GetString("app.cache_dir"),
+				err.Error(),
+			)
+		}
+	}
+
+	closeProcessor := func() {
+		if detector != nil {
+			detector.Close()
+		}
+
+		// The fares priced before a failure are kept for the next run
+		if cache != nil {
+			if err := cache.Close(); err != nil {
+				log.WithFields(log.Fields{
+					"stage":  "fare_cache",
+					"reason": err.Error(),
+				}).Error("Error while writing the fare cache")
+			}
+		}
+	}
+
+	return module.NewRideProcessor(validator, matcher, detector, cache), closeProcessor, nil
+}
+
+// newPricingStages creates the optional validator and map matcher of the config,
+// the stages that change the fare of a ride
+func newPricingStages() (*model.CoordinateValidator, *module.MapMatcher, error) {
+	var err error
+	var matcher *module.MapMatcher
+
+	if viper.GetBool("map_matching.enabled") {
+		graph, err := module.LoadRoadGraph(
+			viper.GetString("map_matching.osm_file"),
+			viper.GetString("app.cache_dir"),
+		)
+
+		if err != nil {
+			return nil, nil, fmt.Errorf(
+				"Error while loading road network file %s: %s",
+				viper.GetString("map_matching.osm_file"),
+				err.Error(),
+			)
+		}
+
+		matcher = module.NewMapMatcher(graph)
+	}
+
+	var validator *model.CoordinateValidator
+
+	if viper.GetBool("validation.enabled") {
+		validator, err = model.NewCoordinateValidator()
+
+		if err != nil {
+			return nil, nil, fmt.Errorf(
+				"Error while loading config file %s: %s",
+				Config,
+				err.Error(),
+			)
+		}
+	}
+
+	return validator, matcher, nil
+}
+
+// startProgressReport reports the progress to the standard error till the returned
+// function is called. A terminal gets a live line, otherwise a line is written
+// every progress.interval seconds
+func startProgressReport(progress *module.Progress) func() {
+	if progress == nil {
+		return func() {}
+	}
+
+	terminal := module.IsTerminal(os.Stderr)
+	interval := 200 * time.Millisecond
+
+	if !terminal {
+		interval = time.Duration(viper.GetFloat64("progress.interval") * float64(time.Second))
+
+		if interval <= 0 {
+			interval = 10 * time.Second
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		module.ReportProgress(ctx, progress, os.Stderr, terminal, interval)
+		close(done)
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// handleSignals closes stop on the first SIGINT or SIGTERM so the pipeline stops reading
+// and stores the fares calculated so far, a second signal calls abort to discard them.
+// The message is logged on the first signal
+func handleSignals(ctx context.Context, stop chan<- struct{}, abort context.CancelFunc, message string) func() bool {
+	var interrupted int32
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		defer signal.Stop(signals)
+
+		select {
+		case <-signals:
+		case <-ctx.Done():
+			return
+		}
+
+		atomic.StoreInt32(&interrupted, 1)
+		log.Warn(message)
+		close(stop)
+
+		select {
+		case <-signals:
+			log.Warn("Aborted, discarding the calculated fares")
+			abort()
+		case <-ctx.Done():
+		}
+	}()
+
+	return func() bool {
+		return atomic.LoadInt32(&interrupted) == 1
+	}
 }
 
 func init() {
@@ -116,19 +539,61 @@ func init() {
 		"config.dist.yml",
 		"Absolute path to config file (required)",
 	)
-	calculateCmd.Flags().StringVarP(
-		&DatasetFile,
+	calculateCmd.Flags().StringSliceVarP(
+		&DatasetFiles,
 		"dataset_file",
 		"i",
-		"",
-		"Absolute path to dataset CSV file (required)",
+		[]string{},
+		"Absolute paths or glob patterns of dataset CSV or JSON Lines files processed as one dataset or - for the standard input (required)",
 	)
 	calculateCmd.Flags().StringVarP(
 		&OutputFile,
 		"output_file",
 		"o",
 		"",
-		"Absolute path to output CSV file (required)",
+		"Absolute path to output file or - for the standard output, it sets {dir} of output.template for one output file per dataset file (required)",
+	)
+	calculateCmd.Flags().StringVarP(
+		&InputFormat,
+		"input_format",
+		"",
+		"auto",
+		"Dataset file format csv, jsonl or auto to detect it from the file extension or content",
+	)
+	calculateCmd.Flags().StringVarP(
+		&Compression,
+		"compression",
+		"",
+		"auto",
+		"Dataset and output files compression none, gzip, zstd or auto to detect it from each file extension",
+	)
+	calculateCmd.Flags().StringVarP(
+		&OutputFormat,
+		"format",
+		"",
+		"auto",
+		"Output file format csv, json, jsonl, parquet, sqlite or auto to detect it from the output file extension",
+	)
+	calculateCmd.Flags().BoolVarP(
+		&Resume,
+		"resume",
+		"",
+		false,
+		"Continue the output file from the last checkpoint of an interrupted run",
+	)
+	calculateCmd.Flags().StringVarP(
+		&ReportFile,
+		"report",
+		"",
+		"",
+		"Absolute path to a JSON file for the run statistics (optional)",
+	)
+	calculateCmd.Flags().StringVarP(
+		&MetricsAddr,
+		"metrics_addr",
+		"",
+		"",
+		"Address like :9090 to serve Prometheus metrics on /metrics and pprof on /debug/pprof/ while running (optional)",
 	)
 	calculateCmd.MarkFlagRequired("dataset_file")
 	calculateCmd.MarkFlagRequired("output_file")
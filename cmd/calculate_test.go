@@ -5,14 +5,18 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"strings"
 	"testing"
 
+	"bitbucket.org/clivern/beat/core/module"
 	"bitbucket.org/clivern/beat/core/util"
 	"bitbucket.org/clivern/beat/pkg"
 
 	"github.com/franela/goblin"
+	"github.com/spf13/viper"
 )
 
 // TestCalculateCommand test cases
@@ -23,7 +27,7 @@ func TestCalculateCommand(t *testing.T) {
 	testDataDir := fmt.Sprintf("%s/%s", baseDir, "testdata")
 	pkg.LoadConfigs(fmt.Sprintf("%s/config.dist.yml", baseDir))
 
-	DatasetFile = fmt.Sprintf("%s/test_paths_02.csv", testDataDir)
+	DatasetFiles = []string{fmt.Sprintf("%s/test_paths_02.csv", testDataDir)}
 	OutputFile = fmt.Sprintf("%s/cache/calculate_command_test_01.csv", baseDir)
 	Config = fmt.Sprintf("%s/config.dist.yml", baseDir)
 
@@ -33,7 +37,7 @@ func TestCalculateCommand(t *testing.T) {
 			result, err := calculateHandler()
 
 			g.Assert(err).Equal(nil)
-			g.Assert(result).Equal("Ride data processed successfully!")
+			g.Assert(strings.SplitN(result, "\n", 2)[0]).Equal("Ride data processed successfully!")
 
 			// Validate command output
 			fileContent, err := util.ReadFile(OutputFile)
@@ -41,9 +45,130 @@ func TestCalculateCommand(t *testing.T) {
 			g.Assert(strings.Contains(fileContent, "2,58.30")).Equal(true)
 		})
 
+		g.It("It should detect and process JSON Lines dataset inside testdata/test_paths_03.jsonl file", func() {
+			DatasetFiles = []string{fmt.Sprintf("%s/test_paths_03.jsonl", testDataDir)}
+
+			// Run command
+			result, err := calculateHandler()
+
+			g.Assert(err).Equal(nil)
+			g.Assert(strings.SplitN(result, "\n", 2)[0]).Equal("Ride data processed successfully!")
+
+			// Validate command output
+			fileContent, err := util.ReadFile(OutputFile)
+			g.Assert(err).Equal(nil)
+			g.Assert(strings.Contains(fileContent, "2,58.30")).Equal(true)
+			g.Assert(strings.Contains(fileContent, "3,3.47")).Equal(true)
+		})
+
+		g.It("It should read and write compressed files", func() {
+			DatasetFiles = []string{fmt.Sprintf("%s/test_paths_02.csv.gz", testDataDir)}
+			OutputFile = fmt.Sprintf("%s/cache/calculate_command_test_02.csv.zst", baseDir)
+
+			// Run command
+			result, err := calculateHandler()
+
+			g.Assert(err).Equal(nil)
+			g.Assert(strings.SplitN(result, "\n", 2)[0]).Equal("Ride data processed successfully!")
+
+			// Validate command output
+			file, err := module.OpenCompressedFile(OutputFile, module.CompressionAuto)
+			g.Assert(err).Equal(nil)
+
+			fileContent, err := ioutil.ReadAll(file)
+			g.Assert(err).Equal(nil)
+			g.Assert(file.Close()).Equal(nil)
+			g.Assert(strings.Contains(string(fileContent), "2,58.30")).Equal(true)
+
+			OutputFile = fmt.Sprintf("%s/cache/calculate_command_test_01.csv", baseDir)
+		})
+
+		g.It("It should process many dataset files into one output file per dataset file", func() {
+			DatasetFiles = []string{fmt.Sprintf("%s/test_paths_05_*.csv", testDataDir)}
+
+			viper.Set("output.per_input", true)
+			viper.Set("output.template", "{dir}/calculate_command_{name}.csv")
+
+			// Run command
+			result, err := calculateHandler()
+
+			viper.Set("output.per_input", false)
+
+			g.Assert(err).Equal(nil)
+			g.Assert(strings.SplitN(result, "\n", 2)[0]).Equal("Ride data processed successfully!")
+
+			// Validate command output
+			fileContent, err := util.ReadFile(fmt.Sprintf("%s/cache/calculate_command_test_paths_05_01.csv", baseDir))
+			g.Assert(err).Equal(nil)
+			g.Assert(strings.Contains(fileContent, "3,3.47")).Equal(true)
+
+			fileContent, err = util.ReadFile(fmt.Sprintf("%s/cache/calculate_command_test_paths_05_02.csv", baseDir))
+			g.Assert(err).Equal(nil)
+			g.Assert(strings.Contains(fileContent, "10,3.47")).Equal(true)
+		})
+
+		g.It("It should write the output file format from the --format flag", func() {
+			DatasetFiles = []string{fmt.Sprintf("%s/test_paths_02.csv", testDataDir)}
+			OutputFormat = "jsonl"
+
+			// Run command
+			result, err := calculateHandler()
+
+			OutputFormat = "auto"
+
+			g.Assert(err).Equal(nil)
+			g.Assert(strings.SplitN(result, "\n", 2)[0]).Equal("Ride data processed successfully!")
+
+			// Validate command output
+			fileContent, err := util.ReadFile(OutputFile)
+			g.Assert(err).Equal(nil)
+			g.Assert(fileContent).Equal("{\"id\":2,\"fare\":58.3}\n")
+		})
+
+		g.It("It should summarize the run and write the report file", func() {
+			DatasetFiles = []string{fmt.Sprintf("%s/test_paths_01.csv", testDataDir)}
+			ReportFile = fmt.Sprintf("%s/cache/calculate_command_report.json", baseDir)
+
+			// Run command
+			result, err := calculateHandler()
+
+			ReportFile = ""
+
+			g.Assert(err).Equal(nil)
+			g.Assert(strings.Contains(result, "\nRides: 10\n")).Equal(true)
+			g.Assert(strings.Contains(result, "\nRides at the minimum fare: 10\n")).Equal(true)
+
+			fileContent, err := util.ReadFile(fmt.Sprintf("%s/cache/calculate_command_report.json", baseDir))
+			g.Assert(err).Equal(nil)
+
+			var report module.StatsReport
+
+			g.Assert(json.Unmarshal([]byte(fileContent), &report)).Equal(nil)
+			g.Assert(report.Rides).Equal(int64(10))
+			g.Assert(report.FarePercentiles["p50"]).Equal(3.47)
+			g.Assert(len(report.Stages)).Equal(3)
+		})
+
+		g.It("It should refuse to resume with the anomaly detector", func() {
+			defer func() {
+				Resume = false
+				viper.Set("anomaly.enabled", false)
+			}()
+
+			Resume = true
+			viper.Set("anomaly.enabled", true)
+
+			// Run command
+			result, err := calculateHandler()
+
+			g.Assert(err != nil).Equal(true)
+			g.Assert(strings.Contains(err.Error(), "anomaly.enabled")).Equal(true)
+			g.Assert(result).Equal("")
+		})
+
 		g.It("It should fail since dataset file doesn't exist", func() {
 			// Override with non existent dataset file
-			DatasetFile = fmt.Sprintf("%s/not_found_test_paths_02.csv", testDataDir)
+			DatasetFiles = []string{fmt.Sprintf("%s/not_found_test_paths_02.csv", testDataDir)}
 
 			// Run command
 			result, err := calculateHandler()
@@ -54,7 +179,7 @@ func TestCalculateCommand(t *testing.T) {
 
 		g.It("It should fail since config file doesn't exist", func() {
 			// Add existent dataset file
-			DatasetFile = fmt.Sprintf("%s/test_paths_02.csv", testDataDir)
+			DatasetFiles = []string{fmt.Sprintf("%s/test_paths_02.csv", testDataDir)}
 
 			// Override with non existent config file
 			Config = fmt.Sprintf("%s/not_found_config.dist.yml", baseDir)
@@ -74,7 +199,7 @@ func BenchmarkCalculateCommand(b *testing.B) {
 	testDataDir := fmt.Sprintf("%s/%s", baseDir, "testdata")
 	pkg.LoadConfigs(fmt.Sprintf("%s/config.dist.yml", baseDir))
 
-	DatasetFile = fmt.Sprintf("%s/test_paths_02.csv", testDataDir)
+	DatasetFiles = []string{fmt.Sprintf("%s/test_paths_02.csv", testDataDir)}
 	OutputFile = fmt.Sprintf("%s/cache/calculate_command_bench_01.csv", baseDir)
 	Config = fmt.Sprintf("%s/config.dist.yml", baseDir)
 
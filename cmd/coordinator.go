@@ -0,0 +1,263 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"bitbucket.org/clivern/beat/core/module"
+
+	"github.com/logrusorgru/aurora/v3"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// ListenAddr var
+var ListenAddr string
+
+var coordinatorCmd = &cobra.Command{
+	Use:   "coordinator",
+	Short: "Split the rides into tasks for the workers and merge their fares",
+	Run:   CoordinatorHandler,
+}
+
+// CoordinatorHandler runs the coordinator command handler
+func CoordinatorHandler(_ *cobra.Command, args []string) {
+	// Keep the standard output for the data if the output file is -
+	// the logs and the result go to the standard error
+	var console io.Writer = os.Stdout
+
+	if OutputFile == module.StdStream {
+		console = os.Stderr
+		log.SetOutput(os.Stderr)
+	}
+
+	result, err := coordinatorHandler(args...)
+
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Fprintln(console, aurora.Green(result))
+}
+
+func coordinatorHandler(args ...string) (string, error) {
+	if Verbose {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	log.Debug("coordinator command got called.")
+
+	if err := loadConfig(); err != nil {
+		return "", err
+	}
+
+	return runCoordinator(args...)
+}
+
+// runCoordinator serves the tasks of the dataset files with the loaded config
+// and merges their results
+func runCoordinator(args ...string) (string, error) {
+	datasetFiles, format, err := openDatasetFiles(args)
+
+	if err != nil {
+		return "", err
+	}
+
+	loader, err := module.NewRideLoader(format)
+
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(viper.GetString("app.cache_dir"), 0755); err != nil {
+		return "", err
+	}
+
+	coordinator, err := module.NewCoordinator(datasetFiles, format, Compression, loader, viper.GetString("app.cache_dir"))
+
+	if err != nil {
+		return "", fmt.Errorf(
+			"Error while splitting dataset files %s into tasks: %s",
+			strings.Join(datasetFiles, ", "),
+			err.Error(),
+		)
+	}
+
+	defer coordinator.Close()
+
+	// The rides spanning dataset files are priced on the coordinator once joined
+	validator, matcher, err := newPricingStages()
+
+	if err != nil {
+		return "", err
+	}
+
+	coordinator.Processor = module.NewRideProcessor(validator, matcher, nil, nil)
+
+	server, err := module.StartCoordinatorServer(ListenAddr, coordinator)
+
+	if err != nil {
+		return "", fmt.Errorf(
+			"Error while starting the coordinator server on %s: %s",
+			ListenAddr,
+			err.Error(),
+		)
+	}
+
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		server.Shutdown(ctx)
+	}()
+
+	log.WithFields(log.Fields{
+		"addr":  server.Addr(),
+		"tasks": len(coordinator.Tasks()),
+	}).Info("Wait for the workers")
+
+	ctx, abort := context.WithCancel(context.Background())
+	defer abort()
+
+	// The results of the workers are only merged once all tasks are done
+	stop := make(chan struct{})
+	handleSignals(ctx, stop, abort, "Interrupted, discarding the calculated fares")
+
+	go func() {
+		select {
+		case <-stop:
+			abort()
+		case <-ctx.Done():
+		}
+	}()
+
+	stats := module.NewRunStats()
+	stats.StartStage(module.StageProcess)
+
+	err = coordinator.Wait(ctx)
+
+	stats.EndStage(module.StageProcess)
+
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("Aborted, the calculated fares were discarded")
+		}
+
+		return "", fmt.Errorf(
+			"Error while processing dataset files %s: %s",
+			strings.Join(datasetFiles, ", "),
+			err.Error(),
+		)
+	}
+
+	stats.StartStage(module.StageStore)
+
+	err = coordinator.Merge(ctx, OutputFile, OutputFormat, Compression, stats)
+
+	stats.EndStage(module.StageStore)
+
+	if err != nil {
+		return "", fmt.Errorf(
+			"Error while merging the task results into %s: %s",
+			OutputFile,
+			err.Error(),
+		)
+	}
+
+	// Let the idle workers know that the run ended before the server stops
+	drainCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	coordinator.Drain(drainCtx)
+	cancel()
+
+	report := stats.Report()
+
+	if ReportFile != "" {
+		if err := module.WriteStatsReport(ReportFile, report); err != nil {
+			return "", fmt.Errorf(
+				"Error while writing report file %s: %s",
+				ReportFile,
+				err.Error(),
+			)
+		}
+	}
+
+	status := coordinator.Status()
+
+	return fmt.Sprintf(
+		"Ride data processed successfully! Tasks: %d, failed attempts: %d\n%s",
+		status.Tasks,
+		status.Failures,
+		report,
+	), nil
+}
+
+func init() {
+	coordinatorCmd.Flags().StringVarP(
+		&Config,
+		"config_file",
+		"c",
+		"config.dist.yml",
+		"Absolute path to config file (required)",
+	)
+	coordinatorCmd.Flags().StringSliceVarP(
+		&DatasetFiles,
+		"dataset_file",
+		"i",
+		[]string{},
+		"Absolute paths or glob patterns of dataset CSV or JSON Lines files (required)",
+	)
+	coordinatorCmd.Flags().StringVarP(
+		&OutputFile,
+		"output_file",
+		"o",
+		"",
+		"Absolute path to output file or - for the standard output (required)",
+	)
+	coordinatorCmd.Flags().StringVarP(
+		&InputFormat,
+		"input_format",
+		"",
+		"auto",
+		"Dataset file format csv, jsonl or auto to detect it from the file extension or content",
+	)
+	coordinatorCmd.Flags().StringVarP(
+		&Compression,
+		"compression",
+		"",
+		"auto",
+		"Dataset and output files compression none, gzip, zstd or auto to detect it from each file extension",
+	)
+	coordinatorCmd.Flags().StringVarP(
+		&OutputFormat,
+		"format",
+		"",
+		"auto",
+		"Output file format csv, json, jsonl, parquet, sqlite or auto to detect it from the output file extension",
+	)
+	coordinatorCmd.Flags().StringVarP(
+		&ReportFile,
+		"report",
+		"",
+		"",
+		"Absolute path to a JSON file for the run statistics (optional)",
+	)
+	coordinatorCmd.Flags().StringVarP(
+		&ListenAddr,
+		"listen",
+		"",
+		"127.0.0.1:8080",
+		"Address to serve the tasks to the workers on, the tasks are served without authentication so a non-local address like :8080 needs a trusted network",
+	)
+	coordinatorCmd.MarkFlagRequired("dataset_file")
+	coordinatorCmd.MarkFlagRequired("output_file")
+	rootCmd.AddCommand(coordinatorCmd)
+}
@@ -0,0 +1,81 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"bitbucket.org/clivern/beat/core/util"
+	"bitbucket.org/clivern/beat/pkg"
+
+	"github.com/franela/goblin"
+	"github.com/spf13/viper"
+)
+
+// TestCoordinatorCommand test cases
+func TestCoordinatorCommand(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	baseDir := pkg.GetBaseDir("cache")
+	testDataDir := fmt.Sprintf("%s/%s", baseDir, "testdata")
+	pkg.LoadConfigs(fmt.Sprintf("%s/config.dist.yml", baseDir))
+
+	DatasetFiles = []string{
+		fmt.Sprintf("%s/test_paths_01.csv", testDataDir),
+		fmt.Sprintf("%s/test_paths_02.csv.gz", testDataDir),
+	}
+	OutputFile = fmt.Sprintf("%s/cache/coordinator_command_test_01.csv", baseDir)
+	Config = fmt.Sprintf("%s/config.dist.yml", baseDir)
+	ListenAddr = "127.0.0.1:18091"
+	CoordinatorURL = "http://127.0.0.1:18091"
+
+	g.Describe("CoordinatorCommand", func() {
+		g.It("It should calculate the ride fares with a worker", func() {
+			defer func() {
+				viper.Set("app.cache_dir", "cache")
+				viper.Set("distributed.poll_interval", 1)
+			}()
+
+			viper.Set("app.cache_dir", fmt.Sprintf("%s/cache", baseDir))
+			viper.Set("distributed.poll_interval", 0.05)
+
+			// The config is global so it is loaded once for both commands
+			g.Assert(loadConfig()).Equal(nil)
+
+			// The worker retries till the coordinator listens
+			workerResult := make(chan string, 1)
+
+			go func() {
+				result, err := runWorker()
+
+				if err != nil {
+					result = err.Error()
+				}
+
+				workerResult <- result
+			}()
+
+			result, err := runCoordinator()
+
+			g.Assert(err).Equal(nil)
+			g.Assert(strings.SplitN(result, "\n", 2)[0]).Equal("Ride data processed successfully! Tasks: 2, failed attempts: 0")
+
+			select {
+			case result := <-workerResult:
+				g.Assert(strings.HasPrefix(result, "Tasks done: 2")).Equal(true)
+			case <-time.After(10 * time.Second):
+				g.Fail("The worker is still running")
+			}
+
+			// Validate command output
+			fileContent, err := util.ReadFile(OutputFile)
+			g.Assert(err).Equal(nil)
+			g.Assert(strings.Contains(fileContent, "2,58.30")).Equal(true)
+		})
+	})
+}
@@ -0,0 +1,113 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"bitbucket.org/clivern/beat/core/module"
+
+	"github.com/logrusorgru/aurora/v3"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// CoordinatorURL var
+var CoordinatorURL string
+
+var workerCmd = &cobra.Command{
+	Use:   "worker",
+	Short: "Calculate the fares of the tasks of a coordinator",
+	Run:   WorkerHandler,
+}
+
+// WorkerHandler runs the worker command handler
+func WorkerHandler(_ *cobra.Command, _ []string) {
+	result, err := workerHandler()
+
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println(aurora.Green(result))
+}
+
+func workerHandler() (string, error) {
+	if Verbose {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	log.Debug("worker command got called.")
+
+	if err := loadConfig(); err != nil {
+		return "", err
+	}
+
+	return runWorker()
+}
+
+// runWorker runs the tasks of the coordinator with the loaded config
+func runWorker() (string, error) {
+	processor, closeProcessor, err := newRideProcessor()
+
+	if err != nil {
+		return "", err
+	}
+
+	defer closeProcessor()
+
+	worker := module.NewTaskWorker(CoordinatorURL, processor)
+
+	ctx, abort := context.WithCancel(context.Background())
+	defer abort()
+
+	stop := make(chan struct{})
+	interrupted := handleSignals(ctx, stop, abort, "Interrupted, finishing the running task. Interrupt again to abort")
+
+	done, err := worker.Run(ctx, stop)
+
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("Aborted, the coordinator gives the running task to another worker")
+		}
+
+		return "", fmt.Errorf(
+			"Error while running the tasks of coordinator %s: %s",
+			CoordinatorURL,
+			err.Error(),
+		)
+	}
+
+	if interrupted() {
+		return fmt.Sprintf("Interrupted after %d tasks", done), nil
+	}
+
+	if processor.Cache != nil {
+		hits, misses := processor.Cache.Stats()
+		return fmt.Sprintf("Tasks done: %d Fare cache: %d hits, %d misses", done, hits, misses), nil
+	}
+
+	return fmt.Sprintf("Tasks done: %d", done), nil
+}
+
+func init() {
+	workerCmd.Flags().StringVarP(
+		&Config,
+		"config_file",
+		"c",
+		"config.dist.yml",
+		"Absolute path to config file (required)",
+	)
+	workerCmd.Flags().StringVarP(
+		&CoordinatorURL,
+		"coordinator",
+		"",
+		"",
+		"URL of the coordinator like http://127.0.0.1:8080 (required)",
+	)
+	workerCmd.MarkFlagRequired("coordinator")
+	rootCmd.AddCommand(workerCmd)
+}
@@ -5,16 +5,14 @@
 package model
 
 import (
-	"fmt"
 	"math"
 	"time"
-
-	"bitbucket.org/clivern/beat/core/util"
 )
 
 const (
-	earthRadiusMi = 3958 // radius of the earth in miles.
-	earthRaidusKm = 6371 // radius of the earth in kilometers.
+	kmPerMile     = 1.609344 // kilometers in a mile.
+	earthRadiusMi = 3958     // radius of the earth in miles.
+	earthRaidusKm = 6371     // radius of the earth in kilometers.
 )
 
 // Coordinate struct type
@@ -24,30 +22,21 @@ type Coordinate struct {
 	Timestamp time.Time `json:"timestamp"`
 }
 
-// GetDistance gets a distance from another new coordinate
-// Calculations based on Haversine Formula https://en.wikipedia.org/wiki/Haversine_formula
+// GetDistance gets a distance from another new coordinate in miles and kilometers
+// Calculations based on the configured distance calculator, haversine by default
 func (p *Coordinate) GetDistance(newCoordinate Coordinate) (float64, float64) {
-	lat1, lng1 := p.toRadians()
-	lat2, lng2 := newCoordinate.toRadians()
-
-	diffLat := lat2 - lat1
-	diffLon := lng2 - lng1
-
-	a := math.Pow(math.Sin(diffLat/2), 2) + math.Cos(lat1)*math.Cos(lat2)*math.Pow(math.Sin(diffLon/2), 2)
-
-	c := 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
-
-	inMile := c * earthRadiusMi
-	inKm := c * earthRaidusKm
+	inKm := distanceCalculator.Distance(*p, newCoordinate)
 
-	return inMile, inKm
+	// The miles keep the earth radius ratio of the previous releases
+	return inKm / earthRaidusKm * earthRadiusMi, inKm
 }
 
 // GetElapsedTime gets the elapsed time in hours to move to a new coordinate
 func (p *Coordinate) GetElapsedTime(newCoordinate Coordinate) (float64, error) {
 	diff := newCoordinate.Timestamp.Sub(p.Timestamp)
 
-	return util.StringToFloat64(fmt.Sprintf("%f", diff.Hours()))
+	// Rounded to 6 decimals like the fares of the previous releases
+	return math.Round(diff.Hours()*1e6) / 1e6, nil
 }
 
 // GetSpeed gets the movement speed in Km/hour to another coordinate
@@ -67,7 +56,7 @@ func (p *Coordinate) GetSpeed(newCoordinate Coordinate) (float64, error) {
 
 	result = inKm / timeElapsed
 
-	return util.StringToFloat64(fmt.Sprintf("%.2f", result))
+	return math.Round(result*100) / 100, nil
 }
 
 // toRadians converts latitude and longitude from degrees to radians.
@@ -0,0 +1,183 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	// RejectInvalidLatitude reason for latitudes beyond ±90
+	RejectInvalidLatitude = "invalid_latitude"
+	// RejectInvalidLongitude reason for longitudes beyond ±180
+	RejectInvalidLongitude = "invalid_longitude"
+	// RejectNullIsland reason for coordinates at (0, 0)
+	RejectNullIsland = "null_island"
+	// RejectOutsideServiceArea reason for coordinates outside all service areas
+	RejectOutsideServiceArea = "outside_service_area"
+	// RejectFutureTimestamp reason for timestamps in the future
+	RejectFutureTimestamp = "future_timestamp"
+	// RejectOldTimestamp reason for timestamps before the min timestamp
+	RejectOldTimestamp = "old_timestamp"
+)
+
+const nullIslandTolerance = 0.0001 // max distance in degrees from (0, 0) to be a null island.
+
+// ValidationError struct type
+type ValidationError struct {
+	Reason     string
+	Coordinate Coordinate
+}
+
+// Error returns the error message
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf(
+		"Coordinate (%f, %f, %s) rejected: %s",
+		e.Coordinate.Latitude,
+		e.Coordinate.Longitude,
+		e.Coordinate.Timestamp,
+		e.Reason,
+	)
+}
+
+// ServiceArea struct type
+// The bounding box is [min_lat, min_lng, max_lat, max_lng] and the polygon is a list of [lat, lng]
+type ServiceArea struct {
+	Market      string      `mapstructure:"market" json:"market"`
+	BoundingBox []float64   `mapstructure:"bounding_box" json:"bounding_box"`
+	Polygon     [][]float64 `mapstructure:"polygon" json:"polygon"`
+}
+
+// CoordinateValidator struct type
+type CoordinateValidator struct {
+	ServiceAreas []ServiceArea
+	MinTimestamp time.Time
+	Now          func() time.Time
+}
+
+// NewCoordinateValidator creates a new instance of CoordinateValidator from the config
+func NewCoordinateValidator() (*CoordinateValidator, error) {
+	validator := &CoordinateValidator{
+		ServiceAreas: make([]ServiceArea, 0),
+		Now:          time.Now,
+	}
+
+	if value := strings.TrimSpace(viper.GetString("validation.min_timestamp")); value != "" {
+		minTimestamp, err := time.Parse("2006-01-02", value)
+
+		if err != nil {
+			minTimestamp, err = time.Parse(time.RFC3339, value)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid config validation.min_timestamp %s", value)
+		}
+
+		validator.MinTimestamp = minTimestamp
+	}
+
+	if err := viper.UnmarshalKey("validation.service_areas", &validator.ServiceAreas); err != nil {
+		return nil, fmt.Errorf("Invalid config validation.service_areas: %s", err.Error())
+	}
+
+	for _, area := range validator.ServiceAreas {
+		if len(area.BoundingBox) != 4 && len(area.Polygon) < 3 {
+			return nil, fmt.Errorf(
+				"Invalid config validation.service_areas: market %s needs a bounding box of 4 values or a polygon of 3 points at least",
+				area.Market,
+			)
+		}
+
+		for _, point := range area.Polygon {
+			if len(point) != 2 {
+				return nil, fmt.Errorf(
+					"Invalid config validation.service_areas: market %s polygon points must be [lat, lng]",
+					area.Market,
+				)
+			}
+		}
+	}
+
+	return validator, nil
+}
+
+// Validate validates a coordinate and returns a ValidationError with the rejection reason
+func (v *CoordinateValidator) Validate(coordinate Coordinate) error {
+	reason := ""
+
+	switch {
+	case math.IsNaN(coordinate.Latitude) || coordinate.Latitude < -90 || coordinate.Latitude > 90:
+		reason = RejectInvalidLatitude
+	case math.IsNaN(coordinate.Longitude) || coordinate.Longitude < -180 || coordinate.Longitude > 180:
+		reason = RejectInvalidLongitude
+	case math.Abs(coordinate.Latitude) < nullIslandTolerance && math.Abs(coordinate.Longitude) < nullIslandTolerance:
+		reason = RejectNullIsland
+	case v.Now != nil && coordinate.Timestamp.After(v.Now()):
+		reason = RejectFutureTimestamp
+	case coordinate.Timestamp.Before(v.MinTimestamp):
+		reason = RejectOldTimestamp
+	case !v.inServiceArea(coordinate):
+		reason = RejectOutsideServiceArea
+	}
+
+	if reason == "" {
+		return nil
+	}
+
+	return &ValidationError{
+		Reason:     reason,
+		Coordinate: coordinate,
+	}
+}
+
+// inServiceArea reports whether the coordinate is inside any service area.
+// All coordinates are accepted if no service area is configured
+func (v *CoordinateValidator) inServiceArea(coordinate Coordinate) bool {
+	if len(v.ServiceAreas) == 0 {
+		return true
+	}
+
+	for _, area := range v.ServiceAreas {
+		if area.Contains(coordinate) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Contains reports whether the coordinate is inside the service area
+func (a ServiceArea) Contains(coordinate Coordinate) bool {
+	if len(a.BoundingBox) == 4 {
+		if coordinate.Latitude < a.BoundingBox[0] || coordinate.Longitude < a.BoundingBox[1] ||
+			coordinate.Latitude > a.BoundingBox[2] || coordinate.Longitude > a.BoundingBox[3] {
+			return false
+		}
+	}
+
+	if len(a.Polygon) < 3 {
+		return true
+	}
+
+	// Ray casting https://en.wikipedia.org/wiki/Point_in_polygon
+	inside := false
+
+	for i, j := 0, len(a.Polygon)-1; i < len(a.Polygon); j, i = i, i+1 {
+		latI, lngI := a.Polygon[i][0], a.Polygon[i][1]
+		latJ, lngJ := a.Polygon[j][0], a.Polygon[j][1]
+
+		if (latI > coordinate.Latitude) != (latJ > coordinate.Latitude) &&
+			coordinate.Longitude < (lngJ-lngI)*(coordinate.Latitude-latI)/(latJ-latI)+lngI {
+			inside = !inside
+		}
+	}
+
+	return inside
+}
@@ -0,0 +1,139 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"bitbucket.org/clivern/beat/pkg"
+
+	"github.com/franela/goblin"
+	"github.com/spf13/viper"
+)
+
+// TestCoordinateValidator test cases
+func TestCoordinateValidator(t *testing.T) {
+	baseDir := pkg.GetBaseDir("cache")
+	pkg.LoadConfigs(fmt.Sprintf("%s/config.dist.yml", baseDir))
+
+	g := goblin.Goblin(t)
+
+	g.Describe("CoordinateValidator", func() {
+		g.It("It should satisfy all provided test cases", func() {
+			validator, err := NewCoordinateValidator()
+			g.Assert(err).Equal(nil)
+
+			validator.Now = func() time.Time {
+				return time.Unix(1608034878, 0)
+			}
+
+			var tests = []struct {
+				latitude   float64
+				longitude  float64
+				timestamp  int64
+				wantReason string
+			}{
+				{37.966660, 23.728308, 1405594957, ""},
+				{90.000001, 23.728308, 1405594957, RejectInvalidLatitude},
+				{-91, 23.728308, 1405594957, RejectInvalidLatitude},
+				{math.NaN(), 23.728308, 1405594957, RejectInvalidLatitude},
+				{37.966660, 180.5, 1405594957, RejectInvalidLongitude},
+				{0, 0, 1405594957, RejectNullIsland},
+				{0.00001, -0.00002, 1405594957, RejectNullIsland},
+				{37.966660, 23.728308, 1608034879, RejectFutureTimestamp},
+				{37.966660, 23.728308, 946684799, RejectOldTimestamp},
+			}
+
+			for _, tt := range tests {
+				err := validator.Validate(Coordinate{
+					Latitude:  tt.latitude,
+					Longitude: tt.longitude,
+					Timestamp: time.Unix(tt.timestamp, 0),
+				})
+
+				if tt.wantReason == "" {
+					g.Assert(err).Equal(nil)
+				} else {
+					g.Assert(err.(*ValidationError).Reason).Equal(tt.wantReason)
+				}
+			}
+		})
+
+		g.It("It should reject coordinates outside the service areas", func() {
+			viper.Set("validation.service_areas", []map[string]interface{}{
+				{"market": "athens", "bounding_box": []float64{37.80, 23.50, 38.20, 24.10}},
+				{"market": "amsterdam", "polygon": [][]float64{{52.27, 4.72}, {52.27, 5.07}, {52.45, 5.07}, {52.45, 4.72}}},
+			})
+
+			validator, err := NewCoordinateValidator()
+			g.Assert(err).Equal(nil)
+			g.Assert(len(validator.ServiceAreas)).Equal(2)
+
+			var tests = []struct {
+				latitude   float64
+				longitude  float64
+				wantReason string
+			}{
+				{37.966660, 23.728308, ""},
+				{52.380746, 4.651924, RejectOutsideServiceArea},
+				{52.379046, 4.859400, ""},
+				{40.416775, -3.703790, RejectOutsideServiceArea},
+			}
+
+			for _, tt := range tests {
+				err := validator.Validate(Coordinate{
+					Latitude:  tt.latitude,
+					Longitude: tt.longitude,
+					Timestamp: time.Unix(1405594957, 0),
+				})
+
+				if tt.wantReason == "" {
+					g.Assert(err).Equal(nil)
+				} else {
+					g.Assert(err.(*ValidationError).Reason).Equal(tt.wantReason)
+				}
+			}
+
+			viper.Set("validation.service_areas", []map[string]interface{}{})
+		})
+
+		g.It("It should fail for invalid config", func() {
+			viper.Set("validation.min_timestamp", "yesterday")
+			_, err := NewCoordinateValidator()
+			g.Assert(err != nil).Equal(true)
+			viper.Set("validation.min_timestamp", "2000-01-01")
+
+			viper.Set("validation.service_areas", []map[string]interface{}{
+				{"market": "athens", "bounding_box": []float64{37.80, 23.50}},
+			})
+			_, err = NewCoordinateValidator()
+			g.Assert(err != nil).Equal(true)
+			viper.Set("validation.service_areas", []map[string]interface{}{})
+		})
+	})
+}
+
+// BenchmarkCoordinateValidator benchmark
+func BenchmarkCoordinateValidator(b *testing.B) {
+	validator := &CoordinateValidator{
+		ServiceAreas: []ServiceArea{
+			{Market: "amsterdam", Polygon: [][]float64{{52.27, 4.72}, {52.27, 5.07}, {52.45, 5.07}, {52.45, 4.72}}},
+		},
+		Now: time.Now,
+	}
+
+	coordinate := Coordinate{
+		Latitude:  52.379046,
+		Longitude: 4.859400,
+		Timestamp: time.Unix(1608035058, 0),
+	}
+
+	for n := 0; n < b.N; n++ {
+		validator.Validate(coordinate)
+	}
+}
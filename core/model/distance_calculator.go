@@ -0,0 +1,151 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+const (
+	wgs84SemiMajorAxis = 6378.137          // WGS-84 ellipsoid semi major axis in kilometers.
+	wgs84Flattening    = 1 / 298.257223563 // WGS-84 ellipsoid flattening.
+	vincentyIterations = 200               // max iterations before Vincenty formula gives up.
+)
+
+// distanceCalculator is the calculator used by the coordinates
+var distanceCalculator DistanceCalculator = HaversineCalculator{}
+
+// DistanceCalculator interface
+type DistanceCalculator interface {
+	// Distance gets the distance in Km between two coordinates
+	Distance(Coordinate, Coordinate) float64
+}
+
+// HaversineCalculator calculates distances on a sphere
+// Calculations based on Haversine Formula https://en.wikipedia.org/wiki/Haversine_formula
+type HaversineCalculator struct {
+}
+
+// VincentyCalculator calculates distances on the WGS-84 ellipsoid
+// Calculations based on Vincenty's Formulae https://en.wikipedia.org/wiki/Vincenty%27s_formulae
+type VincentyCalculator struct {
+}
+
+// EquirectangularCalculator calculates distances with the equirectangular approximation.
+// It is fast and accurate enough for the short distances between ride coordinates
+type EquirectangularCalculator struct {
+}
+
+// NewDistanceCalculator creates a distance calculator by name (haversine, vincenty or equirectangular)
+func NewDistanceCalculator(name string) (DistanceCalculator, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "haversine":
+		return HaversineCalculator{}, nil
+	case "vincenty":
+		return VincentyCalculator{}, nil
+	case "equirectangular":
+		return EquirectangularCalculator{}, nil
+	}
+
+	return nil, fmt.Errorf("Invalid distance model %s", name)
+}
+
+// SetDistanceCalculator sets the calculator used by all coordinates
+func SetDistanceCalculator(calculator DistanceCalculator) {
+	distanceCalculator = calculator
+}
+
+// GetDistanceCalculator gets the calculator used by all coordinates
+func GetDistanceCalculator() DistanceCalculator {
+	return distanceCalculator
+}
+
+// Distance gets the distance in Km between two coordinates
+func (c HaversineCalculator) Distance(from, to Coordinate) float64 {
+	lat1, lng1 := from.toRadians()
+	lat2, lng2 := to.toRadians()
+
+	diffLat := lat2 - lat1
+	diffLon := lng2 - lng1
+
+	a := math.Pow(math.Sin(diffLat/2), 2) + math.Cos(lat1)*math.Cos(lat2)*math.Pow(math.Sin(diffLon/2), 2)
+
+	return 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a)) * earthRaidusKm
+}
+
+// Distance gets the distance in Km between two coordinates. It falls back to
+// haversine for nearly antipodal coordinates where the formula doesn't converge
+func (c VincentyCalculator) Distance(from, to Coordinate) float64 {
+	lat1, lng1 := from.toRadians()
+	lat2, lng2 := to.toRadians()
+
+	b := wgs84SemiMajorAxis * (1 - wgs84Flattening)
+	l := lng2 - lng1
+
+	u1 := math.Atan((1 - wgs84Flattening) * math.Tan(lat1))
+	u2 := math.Atan((1 - wgs84Flattening) * math.Tan(lat2))
+
+	sinU1, cosU1 := math.Sincos(u1)
+	sinU2, cosU2 := math.Sincos(u2)
+
+	lambda := l
+
+	for i := 0; i < vincentyIterations; i++ {
+		sinLambda, cosLambda := math.Sincos(lambda)
+
+		sinSigma := math.Sqrt(math.Pow(cosU2*sinLambda, 2) + math.Pow(cosU1*sinU2-sinU1*cosU2*cosLambda, 2))
+
+		// Coincident coordinates
+		if sinSigma == 0 {
+			return 0
+		}
+
+		cosSigma := sinU1*sinU2 + cosU1*cosU2*cosLambda
+		sigma := math.Atan2(sinSigma, cosSigma)
+
+		sinAlpha := cosU1 * cosU2 * sinLambda / sinSigma
+		cosSqAlpha := 1 - sinAlpha*sinAlpha
+
+		// Both coordinates on the equator
+		var cos2SigmaM float64
+
+		if cosSqAlpha != 0 {
+			cos2SigmaM = cosSigma - 2*sinU1*sinU2/cosSqAlpha
+		}
+
+		cc := wgs84Flattening / 16 * cosSqAlpha * (4 + wgs84Flattening*(4-3*cosSqAlpha))
+		previous := lambda
+		lambda = l + (1-cc)*wgs84Flattening*sinAlpha*(sigma+cc*sinSigma*(cos2SigmaM+cc*cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)))
+
+		if math.Abs(lambda-previous) > 1e-12 {
+			continue
+		}
+
+		uSq := cosSqAlpha * (wgs84SemiMajorAxis*wgs84SemiMajorAxis - b*b) / (b * b)
+		aa := 1 + uSq/16384*(4096+uSq*(-768+uSq*(320-175*uSq)))
+		bb := uSq / 1024 * (256 + uSq*(-128+uSq*(74-47*uSq)))
+
+		deltaSigma := bb * sinSigma * (cos2SigmaM + bb/4*(cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)-
+			bb/6*cos2SigmaM*(-3+4*sinSigma*sinSigma)*(-3+4*cos2SigmaM*cos2SigmaM)))
+
+		return b * aa * (sigma - deltaSigma)
+	}
+
+	return HaversineCalculator{}.Distance(from, to)
+}
+
+// Distance gets the distance in Km between two coordinates
+func (c EquirectangularCalculator) Distance(from, to Coordinate) float64 {
+	lat1, lng1 := from.toRadians()
+	lat2, lng2 := to.toRadians()
+
+	// The longitude difference is wrapped into [-π, π] for the coordinates across the antimeridian
+	x := math.Remainder(lng2-lng1, 2*math.Pi) * math.Cos((lat1+lat2)/2)
+	y := lat2 - lat1
+
+	return math.Sqrt(x*x+y*y) * earthRaidusKm
+}
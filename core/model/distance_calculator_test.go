@@ -0,0 +1,104 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"math"
+	"testing"
+
+	"github.com/franela/goblin"
+)
+
+// TestDistanceCalculators test cases
+func TestDistanceCalculators(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("DistanceCalculator", func() {
+		g.It("It should create the calculators by name", func() {
+			var tests = []struct {
+				name      string
+				want      DistanceCalculator
+				wantError bool
+			}{
+				{"", HaversineCalculator{}, false},
+				{"haversine", HaversineCalculator{}, false},
+				{"Vincenty", VincentyCalculator{}, false},
+				{"equirectangular", EquirectangularCalculator{}, false},
+				{"manhattan", nil, true},
+			}
+
+			for _, tt := range tests {
+				calculator, err := NewDistanceCalculator(tt.name)
+
+				g.Assert(calculator).Equal(tt.want)
+				g.Assert(err != nil).Equal(tt.wantError)
+			}
+		})
+
+		g.It("It should satisfy all provided test cases", func() {
+			var tests = []struct {
+				calculator DistanceCalculator
+				from       Coordinate
+				to         Coordinate
+				wantKm     float64
+			}{
+				// Flinders Peak to Buninyong, the Vincenty reference example
+				{VincentyCalculator{}, Coordinate{Latitude: -37.95103341666667, Longitude: 144.42486788888889}, Coordinate{Latitude: -37.65282113888889, Longitude: 143.92649552777777}, 54.972},
+				{HaversineCalculator{}, Coordinate{Latitude: -37.95103341666667, Longitude: 144.42486788888889}, Coordinate{Latitude: -37.65282113888889, Longitude: 143.92649552777777}, 54.925},
+				{EquirectangularCalculator{}, Coordinate{Latitude: -37.95103341666667, Longitude: 144.42486788888889}, Coordinate{Latitude: -37.65282113888889, Longitude: 143.92649552777777}, 54.926},
+
+				// Short ride segment
+				{VincentyCalculator{}, Coordinate{Latitude: 37.966660, Longitude: 23.728308}, Coordinate{Latitude: 37.965377, Longitude: 23.727717}, 0.152},
+				{HaversineCalculator{}, Coordinate{Latitude: 37.966660, Longitude: 23.728308}, Coordinate{Latitude: 37.965377, Longitude: 23.727717}, 0.152},
+				{EquirectangularCalculator{}, Coordinate{Latitude: 37.966660, Longitude: 23.728308}, Coordinate{Latitude: 37.965377, Longitude: 23.727717}, 0.152},
+
+				// Across the antimeridian
+				{VincentyCalculator{}, Coordinate{Latitude: -16.5, Longitude: 179.9}, Coordinate{Latitude: -16.5, Longitude: -179.9}, 21.353},
+				{HaversineCalculator{}, Coordinate{Latitude: -16.5, Longitude: 179.9}, Coordinate{Latitude: -16.5, Longitude: -179.9}, 21.323},
+				{EquirectangularCalculator{}, Coordinate{Latitude: -16.5, Longitude: 179.9}, Coordinate{Latitude: -16.5, Longitude: -179.9}, 21.323},
+
+				// Same coordinate
+				{VincentyCalculator{}, Coordinate{Latitude: 37.966660, Longitude: 23.728308}, Coordinate{Latitude: 37.966660, Longitude: 23.728308}, 0},
+			}
+
+			for _, tt := range tests {
+				g.Assert(math.Round(tt.calculator.Distance(tt.from, tt.to)*1000) / 1000).Equal(tt.wantKm)
+			}
+		})
+
+		g.It("Coordinates should use the configured calculator", func() {
+			from := Coordinate{Latitude: -37.95103341666667, Longitude: 144.42486788888889}
+			to := Coordinate{Latitude: -37.65282113888889, Longitude: 143.92649552777777}
+
+			SetDistanceCalculator(VincentyCalculator{})
+			inMile, inKm := from.GetDistance(to)
+			SetDistanceCalculator(HaversineCalculator{})
+
+			g.Assert(inKm).Equal(VincentyCalculator{}.Distance(from, to))
+			g.Assert(math.Round(inMile*1000) / 1000).Equal(34.152)
+			g.Assert(GetDistanceCalculator()).Equal(HaversineCalculator{})
+		})
+	})
+}
+
+// BenchmarkVincentyDistance benchmark
+func BenchmarkVincentyDistance(b *testing.B) {
+	from := Coordinate{Latitude: 37.966660, Longitude: 23.728308}
+	to := Coordinate{Latitude: 38.966189, Longitude: 25.728613}
+
+	for n := 0; n < b.N; n++ {
+		VincentyCalculator{}.Distance(from, to)
+	}
+}
+
+// BenchmarkEquirectangularDistance benchmark
+func BenchmarkEquirectangularDistance(b *testing.B) {
+	from := Coordinate{Latitude: 37.966660, Longitude: 23.728308}
+	to := Coordinate{Latitude: 38.966189, Longitude: 25.728613}
+
+	for n := 0; n < b.N; n++ {
+		EquirectangularCalculator{}.Distance(from, to)
+	}
+}
@@ -5,7 +5,7 @@
 package model
 
 import (
-	"fmt"
+	"bitbucket.org/clivern/beat/core/util"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
@@ -16,6 +16,43 @@ type Ride struct {
 	ID          int          `json:"id"`
 	Coordinates []Coordinate `json:"coordinates"`
 	Fare        float64      `json:"fare"`
+
+	// RoadDistances holds the matched road distance in Km of each segment
+	RoadDistances []float64 `json:"road_distances,omitempty"`
+
+	// Metrics holds the ride metrics calculated with the fare
+	Metrics RideMetrics `json:"metrics"`
+
+	// Segments holds the priced segments of the ride
+	Segments []RideSegment `json:"segments,omitempty"`
+
+	// Rejected holds the number of coordinates rejected by the validator
+	Rejected int `json:"rejected"`
+
+	// RejectedReasons holds the number of rejected coordinates per rejection reason
+	RejectedReasons map[string]int `json:"rejected_reasons,omitempty"`
+}
+
+// RideSegment struct type
+// The distance is in Km and the duration is in hours
+type RideSegment struct {
+	Index    int        `json:"index"`
+	Start    Coordinate `json:"start"`
+	End      Coordinate `json:"end"`
+	Distance float64    `json:"distance"`
+	Duration float64    `json:"duration"`
+	Fare     float64    `json:"fare"`
+	Idle     bool       `json:"idle"`
+	Night    bool       `json:"night"`
+}
+
+// RideMetrics struct type
+// Distances are in Km and times are in hours
+type RideMetrics struct {
+	Distance      float64 `json:"distance"`
+	NightDistance float64 `json:"night_distance"`
+	MovingTime    float64 `json:"moving_time"`
+	IdleTime      float64 `json:"idle_time"`
 }
 
 // NewRide creates a new instance of Ride
@@ -32,6 +69,11 @@ func (r *Ride) AppendCoordinate(coordinate Coordinate) {
 	r.Coordinates = append(r.Coordinates, coordinate)
 }
 
+// SetCoordinates sets ride coordinates
+func (r *Ride) SetCoordinates(coordinates []Coordinate) {
+	r.Coordinates = coordinates
+}
+
 // SetID sets ride id
 func (r *Ride) SetID(id int) {
 	r.ID = id
@@ -52,21 +94,105 @@ func (r *Ride) GetID() int {
 	return r.ID
 }
 
+// SetRoadDistances sets the matched road distance of each segment
+func (r *Ride) SetRoadDistances(distances []float64) {
+	r.RoadDistances = distances
+}
+
+// GetRoadDistances gets the matched road distance of each segment
+func (r *Ride) GetRoadDistances() []float64 {
+	return r.RoadDistances
+}
+
+// SetMetrics sets ride metrics
+func (r *Ride) SetMetrics(metrics RideMetrics) {
+	r.Metrics = metrics
+}
+
+// GetMetrics gets ride metrics
+func (r *Ride) GetMetrics() RideMetrics {
+	return r.Metrics
+}
+
+// SetSegments sets ride segments
+func (r *Ride) SetSegments(segments []RideSegment) {
+	r.Segments = segments
+}
+
+// GetSegments gets ride segments
+func (r *Ride) GetSegments() []RideSegment {
+	return r.Segments
+}
+
+// GetRejected gets the number of coordinates rejected by the validator
+func (r *Ride) GetRejected() int {
+	return r.Rejected
+}
+
+// SetRejectedReasons sets the number of rejected coordinates per rejection reason
+func (r *Ride) SetRejectedReasons(reasons map[string]int) {
+	r.RejectedReasons = reasons
+}
+
+// GetRejectedReasons gets the number of rejected coordinates per rejection reason
+func (r *Ride) GetRejectedReasons() map[string]int {
+	return r.RejectedReasons
+}
+
 // GetCoordinates gets ride coordinates
 func (r *Ride) GetCoordinates() []Coordinate {
 	return r.Coordinates
 }
 
+// ValidateCoordinates removes the coordinates rejected by the validator and
+// returns the count of the rejected coordinates per reason
+func (r *Ride) ValidateCoordinates(validator *CoordinateValidator) map[string]int {
+	rejected := make(map[string]int)
+	validCoordinates := make([]Coordinate, 0, len(r.Coordinates))
+
+	for _, coordinate := range r.Coordinates {
+		err := validator.Validate(coordinate)
+
+		if err == nil {
+			validCoordinates = append(validCoordinates, coordinate)
+			continue
+		}
+
+		reason := "invalid"
+
+		if validationError, ok := err.(*ValidationError); ok {
+			reason = validationError.Reason
+		}
+
+		rejected[reason]++
+
+		log.WithFields(log.Fields{
+			"ride_id": r.ID,
+			"stage":   "validation",
+			"reason":  reason,
+		}).Debug(err.Error())
+	}
+
+	r.Rejected += len(r.Coordinates) - len(validCoordinates)
+	r.Coordinates = validCoordinates
+
+	return rejected
+}
+
 // NormalizeCoordinates removes invalid coordinate and return the count.
 // a coordinate is considered invalid if the speed used to reach that
-// coordinate from the previous one is more than 100 Km/h
+// coordinate from the previous one is more than the max speed threshold
 func (r *Ride) NormalizeCoordinates() int {
 	normalizedCoordinates := make([]Coordinate, 0)
 
-	log.Debug(fmt.Sprintf(
-		"Normalize coordinates for ride with ID %d",
-		r.ID,
-	))
+	// The threshold is in the speed unit, speeds are in Km/h
+	speedUnit := GetSpeedUnit()
+	maxSpeed := speedUnit.ToKm(viper.GetFloat64("segment.max_speed_threshold"))
+
+	log.WithFields(log.Fields{
+		"ride_id": r.ID,
+		"stage":   "normalization",
+	}).Debug("Normalize coordinates")
 
 	for index, coordinate := range r.Coordinates {
 		if index == len(r.Coordinates)-1 {
@@ -80,38 +206,43 @@ func (r *Ride) NormalizeCoordinates() int {
 		// Get the speed from the last normalized coordinate
 		speed, err := normalizedCoordinates[len(normalizedCoordinates)-1].GetSpeed(r.Coordinates[index+1])
 
-		log.Debug(fmt.Sprintf(
-			"Speed for coodinate (%f, %f, %s) and coodinate (%f, %f, %s) is %f km/hour",
-			normalizedCoordinates[len(normalizedCoordinates)-1].Latitude,
-			normalizedCoordinates[len(normalizedCoordinates)-1].Longitude,
-			normalizedCoordinates[len(normalizedCoordinates)-1].Timestamp,
-			r.Coordinates[index+1].Latitude,
-			r.Coordinates[index+1].Longitude,
-			r.Coordinates[index+1].Timestamp,
-			speed,
-		))
-
-		if err == nil && speed <= viper.GetFloat64("segment.max_speed_threshold") {
+		// The per-segment logs are sampled
+		if log.IsLevelEnabled(log.DebugLevel) && util.SegmentLogSampler.Sample() {
+			log.WithFields(log.Fields{
+				"ride_id":       r.ID,
+				"segment_index": index,
+				"stage":         "normalization",
+				"speed":         speedUnit.FromKm(speed),
+				"speed_unit":    speedUnit.SpeedLabel(),
+				"sample_rate":   util.SegmentLogSampler.Rate(),
+			}).Debug("Segment speed calculated")
+		}
+
+		if err == nil && speed <= maxSpeed {
 			normalizedCoordinates = append(normalizedCoordinates, r.Coordinates[index+1])
 		} else {
-			log.Debug(fmt.Sprintf(
-				"Remove invalid coodinate (%f, %f, %s) because speed is %f km/hour more than %f km/hour",
-				r.Coordinates[index+1].Latitude,
-				r.Coordinates[index+1].Longitude,
-				r.Coordinates[index+1].Timestamp,
-				speed,
-				viper.GetFloat64("segment.max_speed_threshold"),
-			))
+			log.WithFields(log.Fields{
+				"ride_id":       r.ID,
+				"segment_index": index,
+				"stage":         "normalization",
+				"reason":        "max_speed",
+				"latitude":      r.Coordinates[index+1].Latitude,
+				"longitude":     r.Coordinates[index+1].Longitude,
+				"timestamp":     r.Coordinates[index+1].Timestamp,
+				"speed":         speedUnit.FromKm(speed),
+				"max_speed":     viper.GetFloat64("segment.max_speed_threshold"),
+				"speed_unit":    speedUnit.SpeedLabel(),
+			}).Debug("Remove coordinate above the max speed")
 		}
 	}
 
 	invalidCoordinatesCount := len(r.Coordinates) - len(normalizedCoordinates)
 
-	log.Debug(fmt.Sprintf(
-		"Total invalid coordinates for ride with ID %d is %d",
-		r.ID,
-		invalidCoordinatesCount,
-	))
+	log.WithFields(log.Fields{
+		"ride_id": r.ID,
+		"stage":   "normalization",
+		"removed": invalidCoordinatesCount,
+	}).Debug("Coordinates normalized")
 
 	r.Coordinates = normalizedCoordinates
 
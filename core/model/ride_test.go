@@ -210,6 +210,55 @@ func TestNormalizeCoordinatesMethod(t *testing.T) {
 	})
 }
 
+// TestValidateCoordinatesMethod test cases
+func TestValidateCoordinatesMethod(t *testing.T) {
+	// Load Configs
+	baseDir := pkg.GetBaseDir("cache")
+	pkg.LoadConfigs(fmt.Sprintf("%s/config.dist.yml", baseDir))
+
+	g := goblin.Goblin(t)
+
+	g.Describe("ValidateCoordinates", func() {
+		g.It("Ride object should remove the rejected coordinates and count them per reason", func() {
+			validator, _ := NewCoordinateValidator()
+
+			ride := NewRide()
+			ride.SetID(1)
+
+			var coordinates = []struct {
+				latitude  float64
+				longitude float64
+				timestamp int64
+			}{
+				{52.380746, 4.651924, 1608034878},
+				{0, 0, 1608034923},
+				{52.371108, 4.647479, 946684000},
+				{52.379980, 4.654710, 1608034968},
+				{0, 0, 1608034973},
+				{152.379486, 4.656284, 1608035013},
+			}
+
+			for _, coordinate := range coordinates {
+				ride.AppendCoordinate(Coordinate{
+					Latitude:  coordinate.latitude,
+					Longitude: coordinate.longitude,
+					Timestamp: time.Unix(coordinate.timestamp, 0),
+				})
+			}
+
+			rejected := ride.ValidateCoordinates(validator)
+
+			g.Assert(len(ride.GetCoordinates())).Equal(2)
+			g.Assert(ride.GetRejected()).Equal(4)
+			g.Assert(rejected).Equal(map[string]int{
+				RejectNullIsland:      2,
+				RejectOldTimestamp:    1,
+				RejectInvalidLatitude: 1,
+			})
+		})
+	})
+}
+
 // BenchmarkNormalizeCoordinates benchmark
 func BenchmarkNormalizeCoordinates(b *testing.B) {
 	ride := NewRide()
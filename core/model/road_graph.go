@@ -0,0 +1,211 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"container/heap"
+	"math"
+)
+
+const (
+	roadGridCellSize = 0.005   // size of the road index grid cell in degrees.
+	kmPerDegree      = 111.195 // length of one degree of latitude in kilometers.
+)
+
+// RoadNode struct type
+type RoadNode struct {
+	ID        int64   `json:"id"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// RoadEdge struct type
+// From and To are indexes of the road nodes and the length is in Km
+type RoadEdge struct {
+	From   int     `json:"from"`
+	To     int     `json:"to"`
+	Length float64 `json:"length"`
+}
+
+// RoadGraph struct type
+type RoadGraph struct {
+	Nodes     []RoadNode `json:"nodes"`
+	Edges     []RoadEdge `json:"edges"`
+	Adjacency [][]int    `json:"adjacency"`
+
+	index map[[2]int][]int
+}
+
+// NewRoadGraph creates a new instance of RoadGraph
+func NewRoadGraph() *RoadGraph {
+	return &RoadGraph{
+		Nodes:     make([]RoadNode, 0),
+		Edges:     make([]RoadEdge, 0),
+		Adjacency: make([][]int, 0),
+	}
+}
+
+// AddNode adds a new road node and returns its index
+func (g *RoadGraph) AddNode(node RoadNode) int {
+	g.Nodes = append(g.Nodes, node)
+	g.Adjacency = append(g.Adjacency, make([]int, 0))
+
+	return len(g.Nodes) - 1
+}
+
+// AddEdge adds a directed road edge between two node indexes and returns its index
+func (g *RoadGraph) AddEdge(from, to int) int {
+	start := g.GetNodeCoordinate(from)
+	_, length := start.GetDistance(g.GetNodeCoordinate(to))
+
+	g.Edges = append(g.Edges, RoadEdge{
+		From:   from,
+		To:     to,
+		Length: length,
+	})
+
+	g.Adjacency[from] = append(g.Adjacency[from], len(g.Edges)-1)
+
+	return len(g.Edges) - 1
+}
+
+// GetNodeCoordinate gets the coordinate of a road node
+func (g *RoadGraph) GetNodeCoordinate(node int) Coordinate {
+	return Coordinate{
+		Latitude:  g.Nodes[node].Latitude,
+		Longitude: g.Nodes[node].Longitude,
+	}
+}
+
+// BuildIndex builds the grid index used to find the edges near a coordinate.
+// It must be called after all edges are added and after decoding a cached graph
+func (g *RoadGraph) BuildIndex() {
+	g.index = make(map[[2]int][]int)
+
+	for index, edge := range g.Edges {
+		from := g.Nodes[edge.From]
+		to := g.Nodes[edge.To]
+
+		minLat, maxLat := gridCell(math.Min(from.Latitude, to.Latitude)), gridCell(math.Max(from.Latitude, to.Latitude))
+		minLng, maxLng := gridCell(math.Min(from.Longitude, to.Longitude)), gridCell(math.Max(from.Longitude, to.Longitude))
+
+		for lat := minLat; lat <= maxLat; lat++ {
+			for lng := minLng; lng <= maxLng; lng++ {
+				g.index[[2]int{lat, lng}] = append(g.index[[2]int{lat, lng}], index)
+			}
+		}
+	}
+}
+
+// GetNearbyEdges gets the indexes of the edges which may be within radius (in Km) of a coordinate
+func (g *RoadGraph) GetNearbyEdges(coordinate Coordinate, radius float64) []int {
+	result := make([]int, 0)
+	seen := make(map[int]bool)
+
+	latDelta := radius / kmPerDegree
+	lngDelta := radius / (kmPerDegree * math.Max(math.Cos(coordinate.Latitude*math.Pi/180), 0.01))
+
+	for lat := gridCell(coordinate.Latitude - latDelta); lat <= gridCell(coordinate.Latitude+latDelta); lat++ {
+		for lng := gridCell(coordinate.Longitude - lngDelta); lng <= gridCell(coordinate.Longitude+lngDelta); lng++ {
+			for _, edge := range g.index[[2]int{lat, lng}] {
+				if !seen[edge] {
+					seen[edge] = true
+					result = append(result, edge)
+				}
+			}
+		}
+	}
+
+	return result
+}
+
+// ProjectOnEdge projects a coordinate on an edge. It returns the distance in Km between
+// the coordinate and the projected point and the fraction of the edge before that point
+func (g *RoadGraph) ProjectOnEdge(edge int, coordinate Coordinate) (float64, float64) {
+	from := g.Nodes[g.Edges[edge].From]
+	to := g.Nodes[g.Edges[edge].To]
+
+	// Use a local flat projection around the coordinate, enough for short road edges
+	scale := math.Cos(coordinate.Latitude * math.Pi / 180)
+
+	ax := (from.Longitude - coordinate.Longitude) * scale * kmPerDegree
+	ay := (from.Latitude - coordinate.Latitude) * kmPerDegree
+	bx := (to.Longitude - coordinate.Longitude) * scale * kmPerDegree
+	by := (to.Latitude - coordinate.Latitude) * kmPerDegree
+
+	dx := bx - ax
+	dy := by - ay
+
+	var fraction float64
+
+	if length := dx*dx + dy*dy; length > 0 {
+		fraction = math.Max(0, math.Min(1, -(ax*dx+ay*dy)/length))
+	}
+
+	px := ax + fraction*dx
+	py := ay + fraction*dy
+
+	return math.Sqrt(px*px + py*py), fraction
+}
+
+// GetShortestDistances gets the shortest road distance in Km from a node to every node
+// reachable within the limit (in Km) using Dijkstra's algorithm
+func (g *RoadGraph) GetShortestDistances(source int, limit float64) map[int]float64 {
+	distances := map[int]float64{source: 0}
+	queue := &roadQueue{{node: source, distance: 0}}
+
+	for queue.Len() > 0 {
+		item := heap.Pop(queue).(roadQueueItem)
+
+		if item.distance > distances[item.node] {
+			continue
+		}
+
+		for _, index := range g.Adjacency[item.node] {
+			edge := g.Edges[index]
+			distance := item.distance + edge.Length
+
+			if distance > limit {
+				continue
+			}
+
+			if current, ok := distances[edge.To]; ok && current <= distance {
+				continue
+			}
+
+			distances[edge.To] = distance
+			heap.Push(queue, roadQueueItem{node: edge.To, distance: distance})
+		}
+	}
+
+	return distances
+}
+
+// gridCell gets the grid cell of a latitude or a longitude
+func gridCell(value float64) int {
+	return int(math.Floor(value / roadGridCellSize))
+}
+
+// roadQueueItem struct type
+type roadQueueItem struct {
+	node     int
+	distance float64
+}
+
+// roadQueue is a min heap of nodes ordered by distance
+type roadQueue []roadQueueItem
+
+func (q roadQueue) Len() int            { return len(q) }
+func (q roadQueue) Less(i, j int) bool  { return q[i].distance < q[j].distance }
+func (q roadQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *roadQueue) Push(x interface{}) { *q = append(*q, x.(roadQueueItem)) }
+
+func (q *roadQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+
+	return item
+}
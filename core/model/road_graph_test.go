@@ -0,0 +1,95 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"math"
+	"testing"
+
+	"github.com/franela/goblin"
+)
+
+// newTestRoadGraph creates a road graph with two roads forming an L shape
+func newTestRoadGraph() *RoadGraph {
+	graph := NewRoadGraph()
+
+	a := graph.AddNode(RoadNode{ID: 1, Latitude: 52.3700, Longitude: 4.8900})
+	b := graph.AddNode(RoadNode{ID: 2, Latitude: 52.3700, Longitude: 4.9000})
+	c := graph.AddNode(RoadNode{ID: 3, Latitude: 52.3760, Longitude: 4.9000})
+
+	graph.AddEdge(a, b)
+	graph.AddEdge(b, a)
+	graph.AddEdge(b, c)
+
+	graph.BuildIndex()
+
+	return graph
+}
+
+// TestRoadGraphType test cases
+func TestRoadGraphType(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("RoadGraph", func() {
+		g.It("It should add nodes and edges with their lengths", func() {
+			graph := newTestRoadGraph()
+
+			g.Assert(len(graph.Nodes)).Equal(3)
+			g.Assert(len(graph.Edges)).Equal(3)
+			g.Assert(graph.Adjacency[0]).Equal([]int{0})
+			g.Assert(graph.Adjacency[1]).Equal([]int{1, 2})
+			g.Assert(len(graph.Adjacency[2])).Equal(0)
+
+			g.Assert(math.Round(graph.Edges[0].Length*1000) / 1000).Equal(0.679)
+			g.Assert(math.Round(graph.Edges[2].Length*1000) / 1000).Equal(0.667)
+		})
+
+		g.It("It should find the edges near a coordinate", func() {
+			graph := newTestRoadGraph()
+
+			g.Assert(len(graph.GetNearbyEdges(Coordinate{Latitude: 52.3701, Longitude: 4.8950}, 0.05)) > 0).Equal(true)
+			g.Assert(len(graph.GetNearbyEdges(Coordinate{Latitude: 52.4500, Longitude: 4.9500}, 0.05))).Equal(0)
+		})
+
+		g.It("It should project a coordinate on an edge", func() {
+			graph := newTestRoadGraph()
+
+			distance, fraction := graph.ProjectOnEdge(0, Coordinate{Latitude: 52.3701, Longitude: 4.8950})
+
+			g.Assert(math.Round(distance*1000) / 1000).Equal(0.011)
+			g.Assert(math.Round(fraction*100) / 100).Equal(0.5)
+
+			// Coordinates before the edge start project on the start node
+			_, fraction = graph.ProjectOnEdge(0, Coordinate{Latitude: 52.3700, Longitude: 4.8800})
+
+			g.Assert(fraction).Equal(0.0)
+		})
+
+		g.It("It should get the shortest distances respecting the edges direction", func() {
+			graph := newTestRoadGraph()
+
+			distances := graph.GetShortestDistances(0, 10)
+
+			g.Assert(distances[0]).Equal(0.0)
+			g.Assert(distances[2]).Equal(graph.Edges[0].Length + graph.Edges[2].Length)
+
+			// There is no edge leaving the last node
+			g.Assert(len(graph.GetShortestDistances(2, 10))).Equal(1)
+
+			// The limit stops the search
+			_, ok := graph.GetShortestDistances(0, 0.7)[2]
+			g.Assert(ok).Equal(false)
+		})
+	})
+}
+
+// BenchmarkGetShortestDistances benchmark
+func BenchmarkGetShortestDistances(b *testing.B) {
+	graph := newTestRoadGraph()
+
+	for n := 0; n < b.N; n++ {
+		graph.GetShortestDistances(0, 10)
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Unit type
+type Unit string
+
+const (
+	// Kilometer unit, speeds in this unit are in km/h
+	Kilometer Unit = "km"
+	// Mile unit, speeds in this unit are in mph
+	Mile Unit = "mi"
+)
+
+// NewUnit creates a unit by name (km or mi)
+func NewUnit(name string) (Unit, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "km", "kilometer", "kilometers":
+		return Kilometer, nil
+	case "mi", "mile", "miles":
+		return Mile, nil
+	}
+
+	return Kilometer, fmt.Errorf("Invalid unit %s", name)
+}
+
+// ToKm converts a distance (or a speed) in this unit to Km (or Km/h)
+func (u Unit) ToKm(value float64) float64 {
+	if u == Mile {
+		return value * kmPerMile
+	}
+
+	return value
+}
+
+// FromKm converts a distance (or a speed) in Km (or Km/h) to this unit
+func (u Unit) FromKm(value float64) float64 {
+	if u == Mile {
+		return value / kmPerMile
+	}
+
+	return value
+}
+
+// RatePerKm converts a price per this unit to a price per Km
+func (u Unit) RatePerKm(rate float64) float64 {
+	if u == Mile {
+		return rate / kmPerMile
+	}
+
+	return rate
+}
+
+// SpeedLabel gets the speed unit label
+func (u Unit) SpeedLabel() string {
+	if u == Mile {
+		return "mph"
+	}
+
+	return "km/h"
+}
+
+// ValidateUnits validates the units in the config
+func ValidateUnits() error {
+	for _, key := range []string{"units.distance", "units.speed", "units.rate"} {
+		if _, err := NewUnit(viper.GetString(key)); err != nil {
+			return fmt.Errorf("Invalid config %s: %s", key, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// GetDistanceUnit gets the unit of the reported distances
+func GetDistanceUnit() Unit {
+	unit, _ := NewUnit(viper.GetString("units.distance"))
+	return unit
+}
+
+// GetSpeedUnit gets the unit of the speed thresholds
+func GetSpeedUnit() Unit {
+	unit, _ := NewUnit(viper.GetString("units.speed"))
+	return unit
+}
+
+// GetRateUnit gets the unit of the moving rates
+func GetRateUnit() Unit {
+	unit, _ := NewUnit(viper.GetString("units.rate"))
+	return unit
+}
@@ -0,0 +1,74 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"bitbucket.org/clivern/beat/pkg"
+
+	"github.com/franela/goblin"
+	"github.com/spf13/viper"
+)
+
+// TestUnitType test cases
+func TestUnitType(t *testing.T) {
+	baseDir := pkg.GetBaseDir("cache")
+	pkg.LoadConfigs(fmt.Sprintf("%s/config.dist.yml", baseDir))
+
+	g := goblin.Goblin(t)
+
+	g.Describe("Unit", func() {
+		g.It("It should create the units by name", func() {
+			var tests = []struct {
+				name      string
+				want      Unit
+				wantError bool
+			}{
+				{"", Kilometer, false},
+				{"km", Kilometer, false},
+				{"Miles", Mile, false},
+				{"mi", Mile, false},
+				{"yard", Kilometer, true},
+			}
+
+			for _, tt := range tests {
+				unit, err := NewUnit(tt.name)
+
+				g.Assert(unit).Equal(tt.want)
+				g.Assert(err != nil).Equal(tt.wantError)
+			}
+		})
+
+		g.It("It should convert distances, speeds and rates", func() {
+			g.Assert(Kilometer.ToKm(10)).Equal(10.0)
+			g.Assert(Kilometer.FromKm(10)).Equal(10.0)
+			g.Assert(Kilometer.RatePerKm(1.30)).Equal(1.30)
+			g.Assert(Kilometer.SpeedLabel()).Equal("km/h")
+
+			g.Assert(Mile.ToKm(10)).Equal(16.09344)
+			g.Assert(Mile.FromKm(16.09344)).Equal(10.0)
+			g.Assert(math.Round(Mile.RatePerKm(1.609344)*1000) / 1000).Equal(1.0)
+			g.Assert(Mile.SpeedLabel()).Equal("mph")
+		})
+
+		g.It("It should read and validate the units in the config", func() {
+			g.Assert(ValidateUnits()).Equal(nil)
+			g.Assert(GetDistanceUnit()).Equal(Kilometer)
+
+			viper.Set("units.rate", "mi")
+			g.Assert(GetRateUnit()).Equal(Mile)
+
+			viper.Set("units.speed", "yard")
+			g.Assert(ValidateUnits() != nil).Equal(true)
+			g.Assert(GetSpeedUnit()).Equal(Kilometer)
+
+			viper.Set("units.rate", "km")
+			viper.Set("units.speed", "km")
+		})
+	})
+}
@@ -0,0 +1,280 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package module
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"sync"
+
+	"bitbucket.org/clivern/beat/core/model"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const (
+	// SignalImpossibleSpeed for rides with an impossible average speed
+	SignalImpossibleSpeed = "impossible_speed"
+	// SignalIdleRatio for rides idle most of the time
+	SignalIdleRatio = "idle_ratio"
+	// SignalLoop for rides traveling far more than the distance between the start and the end
+	SignalLoop = "loop"
+	// SignalRoundTrip for rides starting and ending at the same point with a high fare
+	SignalRoundTrip = "round_trip"
+	// SignalFareOutlier for fares far from the fares of rides with a similar distance
+	SignalFareOutlier = "fare_outlier"
+)
+
+// AnomalyReport struct type
+type AnomalyReport struct {
+	RideID  int      `json:"ride_id"`
+	Fare    float64  `json:"fare"`
+	Score   float64  `json:"score"`
+	Signals []string `json:"signals"`
+	Flagged bool     `json:"flagged"`
+}
+
+// AnomalyDetector struct type
+// It scores rides with the enabled anomaly signals and writes the flagged rides to a review file
+type AnomalyDetector struct {
+	mutex     sync.Mutex
+	file      *os.File
+	fareStats map[int]*runningStats
+}
+
+// runningStats keeps the mean and the variance of a stream of values
+// using Welford's algorithm https://en.wikipedia.org/wiki/Algorithms_for_calculating_variance
+type runningStats struct {
+	count int
+	mean  float64
+	m2    float64
+}
+
+// NewAnomalyDetector creates a new instance of AnomalyDetector and the review file
+func NewAnomalyDetector(reviewFile string) (*AnomalyDetector, error) {
+	file, err := os.Create(reviewFile)
+
+	if err != nil {
+		return nil, fmt.Errorf(
+			"Error! Unable to write to file %s: %s",
+			reviewFile,
+			err.Error(),
+		)
+	}
+
+	return &AnomalyDetector{
+		file:      file,
+		fareStats: make(map[int]*runningStats),
+	}, nil
+}
+
+// Analyze scores the ride and writes it to the review file if flagged.
+// It must be called after the ride fare is calculated
+func (d *AnomalyDetector) Analyze(ride *model.Ride) (*AnomalyReport, error) {
+	report := &AnomalyReport{
+		RideID:  ride.GetID(),
+		Fare:    ride.GetFare(),
+		Signals: make([]string, 0),
+	}
+
+	for _, signal := range []struct {
+		name  string
+		check func(*model.Ride) bool
+	}{
+		{SignalImpossibleSpeed, d.hasImpossibleSpeed},
+		{SignalIdleRatio, d.hasHighIdleRatio},
+		{SignalLoop, d.hasLoop},
+		{SignalRoundTrip, d.isRoundTrip},
+		{SignalFareOutlier, d.isFareOutlier},
+	} {
+		key := fmt.Sprintf("anomaly.signals.%s", signal.name)
+
+		if !viper.GetBool(key+".enabled") || !signal.check(ride) {
+			continue
+		}
+
+		report.Signals = append(report.Signals, signal.name)
+		report.Score += viper.GetFloat64(key + ".weight")
+	}
+
+	report.Flagged = len(report.Signals) > 0 && report.Score >= viper.GetFloat64("anomaly.review_threshold")
+
+	if !report.Flagged {
+		return report, nil
+	}
+
+	log.WithFields(log.Fields{
+		"ride_id": report.RideID,
+		"stage":   "anomaly",
+		"reason":  strings.Join(report.Signals, ","),
+		"score":   report.Score,
+	}).Debug("Ride flagged for review")
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	_, err := d.file.WriteString(fmt.Sprintf(
+		"%d,%.2f,%.2f,%s\n",
+		report.RideID,
+		report.Fare,
+		report.Score,
+		strings.Join(report.Signals, "|"),
+	))
+
+	return report, err
+}
+
+// analyzeResults scores the rides of the results in the order they are received and
+// passes the results through without their rides
+func (d *AnomalyDetector) analyzeResults(ctx context.Context, inputChannel <-chan RideResult, outChannel chan<- RideResult) {
+	for result := range inputChannel {
+		if result.ride != nil {
+			if _, err := d.Analyze(result.ride); err != nil {
+				log.WithFields(log.Fields{
+					"ride_id": result.RideID,
+					"stage":   "anomaly",
+					"reason":  err.Error(),
+				}).Error("Error while writing the ride to the review file")
+			}
+
+			result.ride = nil
+		}
+
+		select {
+		case outChannel <- result:
+		case <-ctx.Done():
+		}
+	}
+}
+
+// Close closes the review file
+func (d *AnomalyDetector) Close() error {
+	return d.file.Close()
+}
+
+// hasImpossibleSpeed reports whether the ride average speed is above the max speed
+func (d *AnomalyDetector) hasImpossibleSpeed(ride *model.Ride) bool {
+	metrics := ride.GetMetrics()
+	duration := metrics.MovingTime + metrics.IdleTime
+
+	if metrics.Distance == 0 {
+		return false
+	}
+
+	// Some distance in no time
+	if duration == 0 {
+		return true
+	}
+
+	maxSpeed := model.GetSpeedUnit().ToKm(viper.GetFloat64("anomaly.signals.impossible_speed.max_speed"))
+
+	return metrics.Distance/duration > maxSpeed
+}
+
+// hasHighIdleRatio reports whether the ride was idle most of the time
+func (d *AnomalyDetector) hasHighIdleRatio(ride *model.Ride) bool {
+	metrics := ride.GetMetrics()
+	duration := metrics.MovingTime + metrics.IdleTime
+
+	if duration == 0 || duration*60 < viper.GetFloat64("anomaly.signals.idle_ratio.min_duration") {
+		return false
+	}
+
+	return metrics.IdleTime/duration > viper.GetFloat64("anomaly.signals.idle_ratio.max_ratio")
+}
+
+// hasLoop reports whether the ride distance is far more than the distance between its start and end
+func (d *AnomalyDetector) hasLoop(ride *model.Ride) bool {
+	metrics := ride.GetMetrics()
+
+	if metrics.Distance < model.GetDistanceUnit().ToKm(viper.GetFloat64("anomaly.signals.loop.min_distance")) {
+		return false
+	}
+
+	displacement := getDisplacement(ride)
+
+	if displacement == 0 {
+		return true
+	}
+
+	return metrics.Distance/displacement > viper.GetFloat64("anomaly.signals.loop.max_ratio")
+}
+
+// isRoundTrip reports whether the ride starts and ends at the same point with a high fare
+func (d *AnomalyDetector) isRoundTrip(ride *model.Ride) bool {
+	if len(ride.GetCoordinates()) < 2 {
+		return false
+	}
+
+	return getDisplacement(ride)*1000 <= viper.GetFloat64("anomaly.signals.round_trip.radius") &&
+		ride.GetFare() > viper.GetFloat64("anomaly.signals.round_trip.max_fare")
+}
+
+// isFareOutlier reports whether the ride fare is an outlier versus the rides analyzed before
+// with a similar distance. Rides are grouped in distance bands doubling in size (0-1 Km,
+// 1-2 Km, 2-4 Km ...). The result depends on the order of the rides
+func (d *AnomalyDetector) isFareOutlier(ride *model.Ride) bool {
+	band := 0
+
+	if distance := ride.GetMetrics().Distance; distance >= 1 {
+		band = int(math.Log2(distance)) + 1
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	stats, ok := d.fareStats[band]
+
+	if !ok {
+		stats = &runningStats{}
+		d.fareStats[band] = stats
+	}
+
+	outlier := false
+
+	if stats.count >= viper.GetInt("anomaly.signals.fare_outlier.min_samples") {
+		if deviation := stats.deviation(); deviation > 0 {
+			outlier = math.Abs(ride.GetFare()-stats.mean)/deviation > viper.GetFloat64("anomaly.signals.fare_outlier.max_zscore")
+		}
+	}
+
+	stats.add(ride.GetFare())
+
+	return outlier
+}
+
+// add adds a new value to the stats
+func (s *runningStats) add(value float64) {
+	s.count++
+	delta := value - s.mean
+	s.mean += delta / float64(s.count)
+	s.m2 += delta * (value - s.mean)
+}
+
+// deviation gets the standard deviation of the values
+func (s *runningStats) deviation() float64 {
+	if s.count < 2 {
+		return 0
+	}
+
+	return math.Sqrt(s.m2 / float64(s.count-1))
+}
+
+// getDisplacement gets the distance in Km between the ride start and end
+func getDisplacement(ride *model.Ride) float64 {
+	coordinates := ride.GetCoordinates()
+
+	if len(coordinates) < 2 {
+		return 0
+	}
+
+	_, distance := coordinates[0].GetDistance(coordinates[len(coordinates)-1])
+
+	return distance
+}
@@ -0,0 +1,192 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package module
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"bitbucket.org/clivern/beat/core/model"
+	"bitbucket.org/clivern/beat/core/util"
+	"bitbucket.org/clivern/beat/pkg"
+
+	"github.com/franela/goblin"
+	"github.com/spf13/viper"
+)
+
+// newAnomalyTestRide creates a priced ride from a list of (lat, lng, timestamp)
+func newAnomalyTestRide(id int, points [][3]float64) *model.Ride {
+	ride := model.NewRide()
+	ride.SetID(id)
+
+	for _, point := range points {
+		ride.AppendCoordinate(model.Coordinate{
+			Latitude:  point[0],
+			Longitude: point[1],
+			Timestamp: time.Unix(int64(point[2]), 0),
+		})
+	}
+
+	fare, _ := CalculateRideFare(ride)
+	ride.SetFare(fare)
+
+	return ride
+}
+
+// TestAnomalyDetector test cases
+func TestAnomalyDetector(t *testing.T) {
+	// Load Configs
+	baseDir := pkg.GetBaseDir("cache")
+	cacheDir := fmt.Sprintf("%s/%s", baseDir, "cache")
+	pkg.LoadConfigs(fmt.Sprintf("%s/config.dist.yml", baseDir))
+
+	reviewFile := fmt.Sprintf("%s/anomaly_detector_test01.csv", cacheDir)
+
+	g := goblin.Goblin(t)
+
+	g.Describe("AnomalyDetector", func() {
+		g.It("It should satisfy all provided test cases", func() {
+			detector, err := NewAnomalyDetector(reviewFile)
+			g.Assert(err).Equal(nil)
+
+			var tests = []struct {
+				points      [][3]float64
+				wantSignals []string
+				wantFlagged bool
+			}{
+				// A normal ride (11.46 km in 22 minutes)
+				{[][3]float64{{52.316275, 4.678871, 1608056422}, {52.370210, 4.535538, 1608057742}}, []string{}, false},
+
+				// 11.46 km in 3 minutes
+				{[][3]float64{{52.316275, 4.678871, 1608056422}, {52.370210, 4.535538, 1608056602}}, []string{SignalImpossibleSpeed}, true},
+
+				// Idle for one hour after a short move
+				{[][3]float64{{52.380746, 4.651924, 1608034878}, {52.380337, 4.653338, 1608034923}, {52.380337, 4.653338, 1608038523}}, []string{SignalIdleRatio}, false},
+
+				// Going 11.46 km and coming back to the start point @1:00am
+				{[][3]float64{{52.316275, 4.678871, 1607994000}, {52.370210, 4.535538, 1607995320}, {52.316275, 4.678871, 1607996640}}, []string{SignalLoop, SignalRoundTrip}, true},
+			}
+
+			for index, tt := range tests {
+				report, err := detector.Analyze(newAnomalyTestRide(index+1, tt.points))
+
+				g.Assert(err).Equal(nil)
+				g.Assert(report.Signals).Equal(tt.wantSignals)
+				g.Assert(report.Flagged).Equal(tt.wantFlagged)
+			}
+
+			g.Assert(detector.Close()).Equal(nil)
+
+			content, err := util.ReadFile(reviewFile)
+			g.Assert(err).Equal(nil)
+
+			lines := strings.Split(strings.TrimSpace(content), "\n")
+
+			g.Assert(len(lines)).Equal(2)
+			g.Assert(strings.HasPrefix(lines[0], "2,")).Equal(true)
+			g.Assert(strings.HasSuffix(lines[1], ",1.50,loop|round_trip")).Equal(true)
+		})
+
+		g.It("It should flag fares far from rides with a similar distance", func() {
+			viper.Set("anomaly.signals.fare_outlier.min_samples", 5)
+
+			detector, err := NewAnomalyDetector(reviewFile)
+			g.Assert(err).Equal(nil)
+
+			for index := 0; index < 5; index++ {
+				ride := newAnomalyTestRide(index+1, [][3]float64{{52.316275, 4.678871, 1608056422}, {52.370210, 4.535538, 1608057742}})
+				ride.SetFare(ride.GetFare() + float64(index%2)*0.5)
+
+				report, _ := detector.Analyze(ride)
+				g.Assert(report.Flagged).Equal(false)
+			}
+
+			ride := newAnomalyTestRide(6, [][3]float64{{52.316275, 4.678871, 1608056422}, {52.370210, 4.535538, 1608057742}})
+			ride.SetFare(ride.GetFare() * 4)
+
+			report, err := detector.Analyze(ride)
+
+			g.Assert(err).Equal(nil)
+			g.Assert(report.Signals).Equal([]string{SignalFareOutlier})
+			g.Assert(report.Flagged).Equal(true)
+			g.Assert(detector.Close()).Equal(nil)
+
+			viper.Set("anomaly.signals.fare_outlier.min_samples", 30)
+		})
+
+		g.It("It should score the rides of a pipeline in the dataset order", func() {
+			defer func() {
+				viper.Set("anomaly.signals.fare_outlier.min_samples", 30)
+				viper.Set("anomaly.signals.fare_outlier.max_zscore", 3)
+				viper.Set("workers.size", 0)
+			}()
+
+			// Flag many fares so the flagged rides depend on the order of the rides
+			viper.Set("anomaly.signals.fare_outlier.min_samples", 2)
+			viper.Set("anomaly.signals.fare_outlier.max_zscore", 0.5)
+			viper.Set("workers.size", 4)
+
+			// Rides of 2 to 4 Km with different fares
+			var dataset strings.Builder
+
+			for id := 1; id <= 30; id++ {
+				dataset.WriteString(fmt.Sprintf("%d,37.900000,23.700000,1405594957\n", id))
+				dataset.WriteString(fmt.Sprintf("%d,%.6f,23.700000,1405595557\n", id, 37.92+float64(id*7%15)*0.001))
+			}
+
+			datasetFile := fmt.Sprintf("%s/anomaly_detector_test03.csv", cacheDir)
+			g.Assert(ioutil.WriteFile(datasetFile, []byte(dataset.String()), 0644)).Equal(nil)
+
+			// The rides analyzed one by one in the dataset order
+			detector, err := NewAnomalyDetector(reviewFile)
+			g.Assert(err).Equal(nil)
+
+			channel, err := GenerateData(context.Background(), datasetFile, CompressionAuto, CSVLoader{})
+			g.Assert(err).Equal(nil)
+
+			for batch := range channel {
+				ride := model.NewRide()
+				ride.SetID(batch.RideID)
+				ride.SetCoordinates(batch.Coordinates)
+
+				fare, _ := (&RideProcessor{}).Process(ride)
+				ride.SetFare(fare)
+
+				_, err := detector.Analyze(ride)
+				g.Assert(err).Equal(nil)
+			}
+
+			g.Assert(detector.Close()).Equal(nil)
+
+			expected, err := util.ReadFile(reviewFile)
+			g.Assert(err).Equal(nil)
+			g.Assert(expected != "").Equal(true)
+
+			for i := 0; i < 3; i++ {
+				detector, err := NewAnomalyDetector(reviewFile)
+				g.Assert(err).Equal(nil)
+
+				err = (&Pipeline{
+					DatasetFiles: []string{datasetFile},
+					OutputFile:   fmt.Sprintf("%s/anomaly_detector_test02.csv", cacheDir),
+					Format:       OutputCSV,
+					Compression:  CompressionAuto,
+					Loader:       CSVLoader{},
+					Processor:    &RideProcessor{Detector: detector},
+				}).Run(context.Background(), make(chan struct{}))
+				g.Assert(err).Equal(nil)
+				g.Assert(detector.Close()).Equal(nil)
+
+				content, err := util.ReadFile(reviewFile)
+				g.Assert(err).Equal(nil)
+				g.Assert(content).Equal(expected)
+			}
+		})
+	})
+}
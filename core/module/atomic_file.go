@@ -0,0 +1,157 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package module
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Aborter interface is implemented by the writers that can discard the written data
+type Aborter interface {
+	Abort() error
+}
+
+// Manifest struct type
+// The sidecar of an output file used to check it is complete
+type Manifest struct {
+	File      string `json:"file"`
+	Rows      int    `json:"rows"`
+	Size      int64  `json:"size"`
+	SHA256    string `json:"sha256"`
+	CreatedAt string `json:"created_at"`
+}
+
+// atomicFile struct type
+// The data is written to a temporary file in the same directory which
+// is renamed over the target file on Close, so the target file is either
+// the previous one or the complete new one
+type atomicFile struct {
+	*os.File
+	target string
+}
+
+// createAtomicFile creates a temporary file for the target file
+func createAtomicFile(target string) (*atomicFile, error) {
+	file, err := ioutil.TempFile(
+		filepath.Dir(target),
+		fmt.Sprintf(".%s.*.tmp", filepath.Base(target)),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := file.Chmod(0644); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+
+	return &atomicFile{File: file, target: target}, nil
+}
+
+// Close flushes the temporary file to disk and renames it over the target file
+func (f *atomicFile) Close() error {
+	if err := f.File.Sync(); err != nil {
+		f.Abort()
+		return err
+	}
+
+	if err := f.File.Close(); err != nil {
+		os.Remove(f.File.Name())
+		return err
+	}
+
+	if err := os.Rename(f.File.Name(), f.target); err != nil {
+		os.Remove(f.File.Name())
+		return err
+	}
+
+	syncDir(filepath.Dir(f.target))
+
+	return nil
+}
+
+// Abort discards the temporary file and keeps the target file as is
+func (f *atomicFile) Abort() error {
+	f.File.Close()
+
+	return os.Remove(f.File.Name())
+}
+
+// AbortWriter discards the written data if the writer supports it, otherwise it closes the writer
+func AbortWriter(writer io.WriteCloser) error {
+	if aborter, ok := writer.(Aborter); ok {
+		return aborter.Abort()
+	}
+
+	return writer.Close()
+}
+
+// WriteManifest writes the manifest of an output file to <file>.manifest.json
+// with the rows count, the size and the SHA-256 checksum of the file
+func WriteManifest(filePath string, rows int) error {
+	file, err := os.Open(filePath)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	hash := sha256.New()
+
+	size, err := io.Copy(hash, file)
+
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(Manifest{
+		File:      filepath.Base(filePath),
+		Rows:      rows,
+		Size:      size,
+		SHA256:    hex.EncodeToString(hash.Sum(nil)),
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+	}, "", "    ")
+
+	if err != nil {
+		return err
+	}
+
+	manifest, err := createAtomicFile(fmt.Sprintf("%s.manifest.json", filePath))
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := manifest.Write(append(data, '\n')); err != nil {
+		manifest.Abort()
+		return err
+	}
+
+	return manifest.Close()
+}
+
+// syncDir flushes a directory entries to disk so a rename survives a crash
+// Errors are ignored since some platforms don't support it
+func syncDir(dir string) {
+	file, err := os.Open(dir)
+
+	if err != nil {
+		return
+	}
+
+	file.Sync()
+	file.Close()
+}
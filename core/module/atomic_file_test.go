@@ -0,0 +1,123 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package module
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"bitbucket.org/clivern/beat/pkg"
+
+	"github.com/franela/goblin"
+	"github.com/spf13/viper"
+)
+
+// TestAtomicFile test cases
+func TestAtomicFile(t *testing.T) {
+	baseDir := pkg.GetBaseDir("cache")
+	testDataDir := fmt.Sprintf("%s/%s", baseDir, "testdata")
+	cacheDir := fmt.Sprintf("%s/%s", baseDir, "cache")
+	pkg.LoadConfigs(fmt.Sprintf("%s/config.dist.yml", baseDir))
+
+	g := goblin.Goblin(t)
+
+	g.Describe("AtomicFile", func() {
+		g.It("It should replace the target file on close only", func() {
+			filePath := fmt.Sprintf("%s/atomic_file_test01.csv", cacheDir)
+
+			g.Assert(ioutil.WriteFile(filePath, []byte("1,3.47\n"), 0644)).Equal(nil)
+
+			file, err := createAtomicFile(filePath)
+			g.Assert(err).Equal(nil)
+
+			_, err = file.Write([]byte("2,58.30\n"))
+			g.Assert(err).Equal(nil)
+			g.Assert(file.Abort()).Equal(nil)
+
+			content, err := ioutil.ReadFile(filePath)
+			g.Assert(err).Equal(nil)
+			g.Assert(string(content)).Equal("1,3.47\n")
+
+			file, err = createAtomicFile(filePath)
+			g.Assert(err).Equal(nil)
+
+			_, err = file.Write([]byte("2,58.30\n"))
+			g.Assert(err).Equal(nil)
+			g.Assert(file.Close()).Equal(nil)
+
+			content, err = ioutil.ReadFile(filePath)
+			g.Assert(err).Equal(nil)
+			g.Assert(string(content)).Equal("2,58.30\n")
+
+			// No temporary file is left behind
+			matches, err := filepath.Glob(fmt.Sprintf("%s/.atomic_file_test01.csv.*.tmp", cacheDir))
+			g.Assert(err).Equal(nil)
+			g.Assert(len(matches)).Equal(0)
+		})
+
+		g.It("It should keep the previous file if a compressed write is aborted", func() {
+			filePath := fmt.Sprintf("%s/atomic_file_test02.csv.gz", cacheDir)
+
+			g.Assert(ioutil.WriteFile(filePath, []byte("previous"), 0644)).Equal(nil)
+
+			writer, err := CreateCompressedFile(filePath, CompressionAuto)
+			g.Assert(err).Equal(nil)
+
+			_, err = writer.Write([]byte("1,3.47\n"))
+			g.Assert(err).Equal(nil)
+			g.Assert(AbortWriter(writer)).Equal(nil)
+
+			content, err := ioutil.ReadFile(filePath)
+			g.Assert(err).Equal(nil)
+			g.Assert(string(content)).Equal("previous")
+		})
+
+		g.It("It should write the output manifest", func() {
+			filePath := fmt.Sprintf("%s/atomic_file_test03.csv", cacheDir)
+
+			viper.Set("output.manifest", true)
+
+			channel, err := GenerateData(context.Background(), fmt.Sprintf("%s/test_paths_01.csv", testDataDir), CompressionAuto, CSVLoader{})
+			g.Assert(err).Equal(nil)
+
+			err = StoreData(context.Background(), filePath, OutputCSV, CompressionAuto, ProcessData(context.Background(), channel, &RideProcessor{}))
+			g.Assert(err).Equal(nil)
+
+			viper.Set("output.manifest", false)
+
+			data, err := ioutil.ReadFile(fmt.Sprintf("%s.manifest.json", filePath))
+			g.Assert(err).Equal(nil)
+
+			manifest := Manifest{}
+			g.Assert(json.Unmarshal(data, &manifest)).Equal(nil)
+
+			content, err := ioutil.ReadFile(filePath)
+			g.Assert(err).Equal(nil)
+
+			g.Assert(manifest.File).Equal("atomic_file_test03.csv")
+			g.Assert(manifest.Rows).Equal(10)
+			g.Assert(manifest.Size).Equal(int64(len(content)))
+			g.Assert(len(manifest.SHA256)).Equal(64)
+		})
+
+		g.It("It should checksum the file content", func() {
+			filePath := fmt.Sprintf("%s/atomic_file_test04.csv", cacheDir)
+
+			g.Assert(ioutil.WriteFile(filePath, []byte("1,3.47\n"), 0644)).Equal(nil)
+			g.Assert(WriteManifest(filePath, 1)).Equal(nil)
+
+			data, err := ioutil.ReadFile(fmt.Sprintf("%s.manifest.json", filePath))
+			g.Assert(err).Equal(nil)
+
+			manifest := Manifest{}
+			g.Assert(json.Unmarshal(data, &manifest)).Equal(nil)
+			g.Assert(manifest.SHA256).Equal("e5aeb01a84576f70b2fbf31dfd225a3b6485ce07d802605f0747652784b6f694")
+		})
+	})
+}
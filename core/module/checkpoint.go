@@ -0,0 +1,350 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package module
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"bitbucket.org/clivern/beat/core/util"
+
+	"github.com/spf13/viper"
+)
+
+// checkpointVersion changes whenever the checkpoint format changes
+const checkpointVersion = 1
+
+// Checkpoint struct type
+// The progress of a run, the rides up to Position are stored in the first
+// OutputOffset bytes of the partial output file
+type Checkpoint struct {
+	Version      int               `json:"version"`
+	DatasetFiles []DatasetFileInfo `json:"dataset_files"`
+	OutputFile   string            `json:"output_file"`
+	Format       string            `json:"format"`
+	Position     Position          `json:"position"`
+	OutputOffset int64             `json:"output_offset"`
+	Rows         int               `json:"rows"`
+	UpdatedAt    string            `json:"updated_at"`
+	path         string
+}
+
+// DatasetFileInfo struct type
+// The size and modification time of a dataset file used to detect changes before resuming
+type DatasetFileInfo struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mod_time"`
+}
+
+// partialFile struct type
+// The output file written in place so an interrupted run can continue it. It is
+// renamed over the target file on Close and kept as is on Abort
+type partialFile struct {
+	*atomicFile
+}
+
+// NewCheckpoint creates a checkpoint at the start of the dataset files. Checkpoints
+// are stored inside app.cache_dir, created if missing, and need ordered results written to an uncompressed
+// CSV or JSON Lines output file so the output can be cut at a ride boundary
+func NewCheckpoint(filePaths []string, outputFile, format, compression string) (*Checkpoint, error) {
+	if !viper.GetBool("output.ordered") {
+		return nil, fmt.Errorf("Checkpoints need output.ordered to be enabled")
+	}
+
+	if outputFile == StdStream {
+		return nil, fmt.Errorf("Checkpoints can't be used with the standard output")
+	}
+
+	format, err := ResolveOutputFormat(outputFile, format)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if format != OutputCSV && format != OutputJSONL {
+		return nil, fmt.Errorf("Checkpoints need a csv or jsonl output file not %s", format)
+	}
+
+	if compression, err = ResolveOutputCompression(outputFile, compression); err != nil || compression != CompressionNone {
+		return nil, fmt.Errorf("Checkpoints can't be used with a compressed output file")
+	}
+
+	outputFile, err = filepath.Abs(outputFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	checkpoint := &Checkpoint{
+		Version:      checkpointVersion,
+		DatasetFiles: make([]DatasetFileInfo, len(filePaths)),
+		OutputFile:   outputFile,
+		Format:       format,
+	}
+
+	for i, filePath := range filePaths {
+		if filePath == StdStream {
+			return nil, fmt.Errorf("Checkpoints can't be used with the standard input")
+		}
+
+		info, err := os.Stat(filePath)
+
+		if err != nil {
+			return nil, fmt.Errorf("File %s not found", filePath)
+		}
+
+		absPath, err := filepath.Abs(filePath)
+
+		if err != nil {
+			return nil, err
+		}
+
+		checkpoint.DatasetFiles[i] = DatasetFileInfo{
+			Path:    absPath,
+			Size:    info.Size(),
+			ModTime: info.ModTime().UnixNano(),
+		}
+	}
+
+	// The cache dir is created before any ride is processed
+	if err := os.MkdirAll(viper.GetString("app.cache_dir"), 0755); err != nil {
+		return nil, fmt.Errorf("Unable to create cache dir %s: %s", viper.GetString("app.cache_dir"), err.Error())
+	}
+
+	// The checkpoint file is keyed by the output file
+	checkpoint.path = filepath.Join(viper.GetString("app.cache_dir"), fmt.Sprintf(
+		"checkpoint_%x.json",
+		sha256.Sum256([]byte(outputFile)),
+	))
+
+	return checkpoint, nil
+}
+
+// Load loads the stored checkpoint of the same run if any, found is false if
+// there is none. It fails if the dataset files or the output format changed
+func (c *Checkpoint) Load() (bool, error) {
+	if !util.FileExists(c.path) {
+		return false, nil
+	}
+
+	data, err := ioutil.ReadFile(c.path)
+
+	if err != nil {
+		return false, err
+	}
+
+	stored := &Checkpoint{}
+
+	if err := json.Unmarshal(data, stored); err != nil {
+		return false, fmt.Errorf("Invalid checkpoint file %s: %s", c.path, err.Error())
+	}
+
+	if stored.Version != c.Version || stored.Format != c.Format || len(stored.DatasetFiles) != len(c.DatasetFiles) {
+		return false, fmt.Errorf("Checkpoint file %s belongs to a different run", c.path)
+	}
+
+	for i, info := range stored.DatasetFiles {
+		if info != c.DatasetFiles[i] {
+			return false, fmt.Errorf("Dataset file %s changed since the checkpoint", c.DatasetFiles[i].Path)
+		}
+	}
+
+	info, err := os.Stat(c.PartialFile())
+
+	if err != nil || info.Size() < stored.OutputOffset {
+		return false, fmt.Errorf("Partial output file %s is missing or truncated", c.PartialFile())
+	}
+
+	c.Position = stored.Position
+	c.OutputOffset = stored.OutputOffset
+	c.Rows = stored.Rows
+
+	return true, nil
+}
+
+// Save writes the checkpoint file
+func (c *Checkpoint) Save() error {
+	c.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+
+	data, err := json.MarshalIndent(c, "", "    ")
+
+	if err != nil {
+		return err
+	}
+
+	file, err := createAtomicFile(c.path)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Abort()
+		return err
+	}
+
+	return file.Close()
+}
+
+// Remove deletes the checkpoint file
+func (c *Checkpoint) Remove() error {
+	if !util.FileExists(c.path) {
+		return nil
+	}
+
+	return util.DeleteFile(c.path)
+}
+
+// PartialFile gets the path of the partial output file
+func (c *Checkpoint) PartialFile() string {
+	return fmt.Sprintf("%s.partial", c.OutputFile)
+}
+
+// Abort flushes the partial file to disk and keeps it for a later run
+func (f *partialFile) Abort() error {
+	f.File.Sync()
+
+	return f.File.Close()
+}
+
+// StoreDataWithCheckpoints store ride id and fare into the partial output file of the checkpoint
+// after the checkpoint output offset and saves the checkpoint every checkpoint.interval seconds.
+// Once the channel is closed, the partial file replaces the output file and the checkpoint is
+// removed unless stopped reports that reading stopped early, then a last checkpoint is saved.
+// The partial file and the last saved checkpoint are kept on failure
+func StoreDataWithCheckpoints(ctx context.Context, checkpoint *Checkpoint, channel <-chan RideResult, stopped func() bool) error {
+	file, err := openPartialFile(checkpoint)
+
+	if err != nil {
+		return fmt.Errorf(
+			"Error! Unable to write to file %s: %s",
+			checkpoint.PartialFile(),
+			err.Error(),
+		)
+	}
+
+	var writer ResultWriter = &JSONLWriter{writer: file}
+
+	if checkpoint.Format == OutputCSV {
+		// The header row is written once at the file start
+		writer, err = NewCSVWriter(file, viper.GetBool("output.csv_header") && checkpoint.OutputOffset == 0)
+
+		if err != nil {
+			file.Abort()
+			return err
+		}
+	}
+
+	interval := time.Duration(viper.GetFloat64("checkpoint.interval") * float64(time.Second))
+	saved := time.Now()
+
+	for {
+		result, ok, err := receiveResult(ctx, channel)
+
+		if err != nil {
+			file.Abort()
+			return err
+		}
+
+		if !ok {
+			break
+		}
+
+		if err := writer.Write(result); err != nil {
+			file.Abort()
+
+			return fmt.Errorf(
+				"Error! Unable to write to file %s: %s",
+				checkpoint.PartialFile(),
+				err.Error(),
+			)
+		}
+
+		checkpoint.Position = result.End
+		checkpoint.Rows++
+
+		if time.Since(saved) >= interval {
+			if err := saveCheckpoint(checkpoint, file); err != nil {
+				file.Abort()
+				return err
+			}
+
+			saved = time.Now()
+		}
+	}
+
+	if stopped() {
+		err := saveCheckpoint(checkpoint, file)
+		file.Abort()
+
+		return err
+	}
+
+	// Flush the data and replace the file
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf(
+			"Error! Unable to write to file %s: %s",
+			checkpoint.OutputFile,
+			err.Error(),
+		)
+	}
+
+	if err := checkpoint.Remove(); err != nil {
+		return err
+	}
+
+	return storeManifest(checkpoint.OutputFile, checkpoint.Rows)
+}
+
+// openPartialFile opens the partial output file and cuts it at the checkpoint output offset
+func openPartialFile(checkpoint *Checkpoint) (*partialFile, error) {
+	file, err := os.OpenFile(checkpoint.PartialFile(), os.O_RDWR|os.O_CREATE, 0644)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := file.Truncate(checkpoint.OutputOffset); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if _, err := file.Seek(checkpoint.OutputOffset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &partialFile{atomicFile: &atomicFile{File: file, target: checkpoint.OutputFile}}, nil
+}
+
+// saveCheckpoint flushes the partial file to disk before saving the checkpoint
+// so the checkpoint never points after the data on disk
+func saveCheckpoint(checkpoint *Checkpoint, file *partialFile) error {
+	if err := file.Sync(); err != nil {
+		return err
+	}
+
+	offset, err := file.Seek(0, io.SeekCurrent)
+
+	if err != nil {
+		return err
+	}
+
+	checkpoint.OutputOffset = offset
+
+	if err := checkpoint.Save(); err != nil {
+		return fmt.Errorf("Error! Unable to write checkpoint file %s: %s", checkpoint.path, err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,192 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package module
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"bitbucket.org/clivern/beat/core/util"
+	"bitbucket.org/clivern/beat/pkg"
+
+	"github.com/franela/goblin"
+	"github.com/spf13/viper"
+)
+
+// TestCheckpoint test cases
+func TestCheckpoint(t *testing.T) {
+	baseDir := pkg.GetBaseDir("cache")
+	testDataDir := fmt.Sprintf("%s/%s", baseDir, "testdata")
+	cacheDir := fmt.Sprintf("%s/%s", baseDir, "cache")
+	pkg.LoadConfigs(fmt.Sprintf("%s/config.dist.yml", baseDir))
+
+	datasetFiles := []string{
+		fmt.Sprintf("%s/test_paths_05_01.csv", testDataDir),
+		fmt.Sprintf("%s/test_paths_05_02.csv", testDataDir),
+	}
+
+	g := goblin.Goblin(t)
+
+	g.Describe("Checkpoint", func() {
+		g.It("It should fail for unordered, compressed or unsupported outputs", func() {
+			defer viper.Set("output.ordered", false)
+
+			viper.Set("output.ordered", false)
+			_, err := NewCheckpoint(datasetFiles, fmt.Sprintf("%s/checkpoint_test.csv", cacheDir), "auto", CompressionAuto)
+			g.Assert(err != nil).Equal(true)
+
+			viper.Set("output.ordered", true)
+
+			for _, outputFile := range []string{"checkpoint_test.csv.gz", "checkpoint_test.parquet", "checkpoint_test.json"} {
+				_, err := NewCheckpoint(datasetFiles, fmt.Sprintf("%s/%s", cacheDir, outputFile), "auto", CompressionAuto)
+				g.Assert(err != nil).Equal(true)
+			}
+
+			_, err = NewCheckpoint([]string{StdStream}, fmt.Sprintf("%s/checkpoint_test.csv", cacheDir), "auto", CompressionAuto)
+			g.Assert(err != nil).Equal(true)
+		})
+
+		g.It("It should create a missing cache dir before processing", func() {
+			checkpointDir := fmt.Sprintf("%s/checkpoint_test/cache", cacheDir)
+
+			defer func() {
+				viper.Set("output.ordered", false)
+				viper.Set("app.cache_dir", "cache")
+				os.RemoveAll(fmt.Sprintf("%s/checkpoint_test", cacheDir))
+			}()
+
+			os.RemoveAll(fmt.Sprintf("%s/checkpoint_test", cacheDir))
+
+			viper.Set("output.ordered", true)
+			viper.Set("app.cache_dir", checkpointDir)
+
+			checkpoint, err := NewCheckpoint(datasetFiles, fmt.Sprintf("%s/checkpoint_test.csv", cacheDir), "auto", CompressionAuto)
+			g.Assert(err).Equal(nil)
+
+			_, err = os.Stat(checkpointDir)
+			g.Assert(err).Equal(nil)
+			g.Assert(checkpoint.Save()).Equal(nil)
+			g.Assert(checkpoint.Remove()).Equal(nil)
+		})
+
+		g.It("It should resume an interrupted run without duplicated or lost rides", func() {
+			defer func() {
+				viper.Set("output.ordered", false)
+				viper.Set("checkpoint.interval", 30)
+				viper.Set("app.cache_dir", "cache")
+			}()
+
+			viper.Set("output.ordered", true)
+			viper.Set("checkpoint.interval", 0)
+			viper.Set("app.cache_dir", cacheDir)
+
+			// The output of a run without interruption
+			expectedFile := fmt.Sprintf("%s/checkpoint_test01_expected.csv", cacheDir)
+
+			err := (&Pipeline{
+				DatasetFiles: datasetFiles,
+				OutputFile:   expectedFile,
+				Format:       "auto",
+				Compression:  CompressionAuto,
+				Loader:       CSVLoader{},
+				Processor:    &RideProcessor{},
+			}).Run(context.Background(), make(chan struct{}))
+			g.Assert(err).Equal(nil)
+
+			outputFile := fmt.Sprintf("%s/checkpoint_test01.csv", cacheDir)
+			os.Remove(outputFile)
+
+			checkpoint, err := NewCheckpoint(datasetFiles, outputFile, "auto", CompressionAuto)
+			g.Assert(err).Equal(nil)
+			g.Assert(checkpoint.Remove()).Equal(nil)
+
+			// Store the first four rides then stop, the fourth ride is in the second file
+			channel, err := GenerateDatasets(context.Background(), datasetFiles, CompressionAuto, CSVLoader{})
+			g.Assert(err).Equal(nil)
+
+			var results []RideResult
+
+			for result := range ProcessData(context.Background(), channel, &RideProcessor{}) {
+				if len(results) < 4 {
+					results = append(results, result)
+				}
+			}
+
+			err = StoreDataWithCheckpoints(context.Background(), checkpoint, sendResults(results), func() bool {
+				return true
+			})
+			g.Assert(err).Equal(nil)
+			g.Assert(util.FileExists(outputFile)).Equal(false)
+
+			// Rows written after the last checkpoint are cut on resume
+			partial, err := os.OpenFile(checkpoint.PartialFile(), os.O_APPEND|os.O_WRONLY, 0644)
+			g.Assert(err).Equal(nil)
+			_, err = partial.WriteString("5,1")
+			g.Assert(err).Equal(nil)
+			g.Assert(partial.Close()).Equal(nil)
+
+			checkpoint, err = NewCheckpoint(datasetFiles, outputFile, "auto", CompressionAuto)
+			g.Assert(err).Equal(nil)
+
+			found, err := checkpoint.Load()
+			g.Assert(found).Equal(true)
+			g.Assert(err).Equal(nil)
+			g.Assert(checkpoint.Rows).Equal(4)
+			g.Assert(checkpoint.Position.File).Equal(1)
+
+			err = (&Pipeline{
+				DatasetFiles: datasetFiles,
+				OutputFile:   outputFile,
+				Format:       "auto",
+				Compression:  CompressionAuto,
+				Loader:       CSVLoader{},
+				Processor:    &RideProcessor{},
+				Checkpoint:   checkpoint,
+			}).Run(context.Background(), make(chan struct{}))
+			g.Assert(err).Equal(nil)
+
+			expected, err := util.ReadFile(expectedFile)
+			g.Assert(err).Equal(nil)
+
+			content, err := util.ReadFile(outputFile)
+			g.Assert(err).Equal(nil)
+			g.Assert(content).Equal(expected)
+
+			g.Assert(util.FileExists(checkpoint.PartialFile())).Equal(false)
+			g.Assert(util.FileExists(checkpoint.path)).Equal(false)
+		})
+
+		g.It("It should not resume once a dataset file changed", func() {
+			defer func() {
+				viper.Set("output.ordered", false)
+				viper.Set("app.cache_dir", "cache")
+			}()
+
+			viper.Set("output.ordered", true)
+			viper.Set("app.cache_dir", cacheDir)
+
+			outputFile := fmt.Sprintf("%s/checkpoint_test02.csv", cacheDir)
+
+			checkpoint, err := NewCheckpoint(datasetFiles, outputFile, "auto", CompressionAuto)
+			g.Assert(err).Equal(nil)
+
+			found, err := checkpoint.Load()
+			g.Assert(found).Equal(false)
+			g.Assert(err).Equal(nil)
+
+			checkpoint.DatasetFiles[1].Size++
+			g.Assert(checkpoint.Save()).Equal(nil)
+
+			checkpoint, err = NewCheckpoint(datasetFiles, outputFile, "auto", CompressionAuto)
+			g.Assert(err).Equal(nil)
+
+			_, err = checkpoint.Load()
+			g.Assert(err != nil).Equal(true)
+			g.Assert(checkpoint.Remove()).Equal(nil)
+		})
+	})
+}
@@ -0,0 +1,268 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package module
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// StdStream is the file path of the standard input or output
+const StdStream = "-"
+
+const (
+	// CompressionAuto detects the compression from the file extension
+	CompressionAuto = "auto"
+	// CompressionNone for plain files
+	CompressionNone = "none"
+	// CompressionGzip for gzip files
+	CompressionGzip = "gzip"
+	// CompressionZstd for zstandard files
+	CompressionZstd = "zstd"
+)
+
+// stdin is shared by the format detection and the data reader so the
+// peeked data is not lost
+var stdin = bufio.NewReader(os.Stdin)
+
+// stdout is where the data written to - goes
+var stdout io.Writer = os.Stdout
+
+// compressedReader struct type
+// It closes the decompressor and the underlying file together
+type compressedReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+// compressedWriter struct type
+// It flushes the compressor before closing the underlying file
+type compressedWriter struct {
+	io.Writer
+	compressor io.Closer
+	writer     io.WriteCloser
+}
+
+// nopWriteCloser struct type
+type nopWriteCloser struct {
+	io.Writer
+}
+
+// DetectCompression detects the file compression from its extension
+func DetectCompression(filePath string) string {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".gz", ".gzip":
+		return CompressionGzip
+	case ".zst", ".zstd":
+		return CompressionZstd
+	}
+
+	return CompressionNone
+}
+
+// ResolveCompression gets the compression of a file, the compression is
+// detected from the file extension or the standard input data if it is empty or auto
+func ResolveCompression(filePath, compression string) (string, error) {
+	switch compression {
+	case "", CompressionAuto:
+		if filePath == StdStream {
+			return detectStdinCompression(), nil
+		}
+
+		return DetectCompression(filePath), nil
+	case CompressionNone, CompressionGzip, CompressionZstd:
+		return compression, nil
+	}
+
+	return "", fmt.Errorf("Invalid compression %s", compression)
+}
+
+// ResolveOutputCompression gets the compression of an output file, the compression is
+// detected from the file extension if it is empty or auto. The standard output has no
+// extension so it is not compressed unless the compression is set
+func ResolveOutputCompression(filePath, compression string) (string, error) {
+	if filePath == StdStream && (compression == "" || compression == CompressionAuto) {
+		return CompressionNone, nil
+	}
+
+	return ResolveCompression(filePath, compression)
+}
+
+// detectStdinCompression detects the standard input compression from the magic number
+// https://tools.ietf.org/html/rfc1952 and https://tools.ietf.org/html/rfc8878
+func detectStdinCompression() string {
+	magic, _ := stdin.Peek(4)
+
+	if bytes.HasPrefix(magic, []byte{0x1f, 0x8b}) {
+		return CompressionGzip
+	}
+
+	if bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}) {
+		return CompressionZstd
+	}
+
+	return CompressionNone
+}
+
+// TrimCompressionExt removes the compression extension from a file path
+// so rides.csv.gz becomes rides.csv
+func TrimCompressionExt(filePath string) string {
+	if DetectCompression(filePath) == CompressionNone {
+		return filePath
+	}
+
+	return strings.TrimSuffix(filePath, filepath.Ext(filePath))
+}
+
+// NewCompressedReader creates a reader that decompresses the data as it is read.
+// Closing the reader closes the underlying reader too
+func NewCompressedReader(reader io.ReadCloser, compression string) (io.ReadCloser, error) {
+	switch compression {
+	case CompressionGzip:
+		decompressor, err := gzip.NewReader(reader)
+
+		if err != nil {
+			reader.Close()
+			return nil, err
+		}
+
+		return &compressedReader{Reader: decompressor, closers: []io.Closer{decompressor, reader}}, nil
+	case CompressionZstd:
+		decompressor, err := zstd.NewReader(reader, zstd.WithDecoderConcurrency(1))
+
+		if err != nil {
+			reader.Close()
+			return nil, err
+		}
+
+		return &compressedReader{Reader: decompressor, closers: []io.Closer{decompressor.IOReadCloser(), reader}}, nil
+	case CompressionNone:
+		return reader, nil
+	}
+
+	reader.Close()
+
+	return nil, fmt.Errorf("Invalid compression %s", compression)
+}
+
+// NewCompressedWriter creates a writer that compresses the data as it is written.
+// Closing the writer flushes the compressed data and closes the underlying writer
+func NewCompressedWriter(writer io.WriteCloser, compression string) (io.WriteCloser, error) {
+	switch compression {
+	case CompressionGzip:
+		compressor := gzip.NewWriter(writer)
+
+		return &compressedWriter{Writer: compressor, compressor: compressor, writer: writer}, nil
+	case CompressionZstd:
+		compressor, err := zstd.NewWriter(writer, zstd.WithEncoderConcurrency(1))
+
+		if err != nil {
+			AbortWriter(writer)
+			return nil, err
+		}
+
+		return &compressedWriter{Writer: compressor, compressor: compressor, writer: writer}, nil
+	case CompressionNone:
+		return writer, nil
+	}
+
+	AbortWriter(writer)
+
+	return nil, fmt.Errorf("Invalid compression %s", compression)
+}
+
+// Close closes the decompressor and the underlying reader
+func (r *compressedReader) Close() error {
+	return closeAll(r.closers)
+}
+
+// Close flushes the compressor and closes the underlying writer
+func (w *compressedWriter) Close() error {
+	if err := w.compressor.Close(); err != nil {
+		AbortWriter(w.writer)
+		return err
+	}
+
+	return w.writer.Close()
+}
+
+// Abort discards the compressed data if the underlying writer supports it
+func (w *compressedWriter) Abort() error {
+	w.compressor.Close()
+
+	return AbortWriter(w.writer)
+}
+
+// closeAll closes all closers in order and returns the first error
+func closeAll(closers []io.Closer) error {
+	var result error
+
+	for _, closer := range closers {
+		if err := closer.Close(); err != nil && result == nil {
+			result = err
+		}
+	}
+
+	return result
+}
+
+// OpenCompressedFile opens a file for reading and decompresses its content
+// The file path - reads from the standard input
+func OpenCompressedFile(filePath, compression string) (io.ReadCloser, error) {
+	compression, err := ResolveCompression(filePath, compression)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if filePath == StdStream {
+		return NewCompressedReader(ioutil.NopCloser(stdin), compression)
+	}
+
+	file, err := os.Open(filePath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewCompressedReader(file, compression)
+}
+
+// CreateCompressedFile creates a file and compresses the data written to it. The data
+// is written to a temporary file renamed over the file on Close, so a failed run keeps
+// the previous file. The file path - writes to the standard output
+func CreateCompressedFile(filePath, compression string) (io.WriteCloser, error) {
+	compression, err := ResolveOutputCompression(filePath, compression)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if filePath == StdStream {
+		return NewCompressedWriter(nopWriteCloser{stdout}, compression)
+	}
+
+	file, err := createAtomicFile(filePath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewCompressedWriter(file, compression)
+}
+
+// Close does nothing, the standard output stays open
+func (w nopWriteCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,163 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package module
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"bitbucket.org/clivern/beat/pkg"
+
+	"github.com/franela/goblin"
+)
+
+// TestCompression test cases
+func TestCompression(t *testing.T) {
+	baseDir := pkg.GetBaseDir("cache")
+	testDataDir := fmt.Sprintf("%s/%s", baseDir, "testdata")
+	cacheDir := fmt.Sprintf("%s/%s", baseDir, "cache")
+	pkg.LoadConfigs(fmt.Sprintf("%s/config.dist.yml", baseDir))
+
+	g := goblin.Goblin(t)
+
+	g.Describe("Compression", func() {
+		g.It("It should detect the compression from the file extension", func() {
+			var tests = []struct {
+				filePath        string
+				wantCompression string
+				wantFilePath    string
+			}{
+				{"rides.csv", CompressionNone, "rides.csv"},
+				{"rides.csv.gz", CompressionGzip, "rides.csv"},
+				{"rides.jsonl.GZ", CompressionGzip, "rides.jsonl"},
+				{"rides.csv.zst", CompressionZstd, "rides.csv"},
+				{"rides.zstd", CompressionZstd, "rides"},
+			}
+
+			for _, tt := range tests {
+				g.Assert(DetectCompression(tt.filePath)).Equal(tt.wantCompression)
+				g.Assert(TrimCompressionExt(tt.filePath)).Equal(tt.wantFilePath)
+			}
+
+			compression, err := ResolveCompression("rides.csv.gz", CompressionZstd)
+			g.Assert(compression).Equal(CompressionZstd)
+			g.Assert(err).Equal(nil)
+
+			_, err = ResolveCompression("rides.csv", "bzip2")
+			g.Assert(err != nil).Equal(true)
+		})
+
+		g.It("It should read a gzip dataset", func() {
+			filePath := fmt.Sprintf("%s/test_paths_02.csv.gz", testDataDir)
+
+			format, err := DetectFormat(filePath, CompressionAuto)
+			g.Assert(format).Equal(FormatCSV)
+			g.Assert(err).Equal(nil)
+
+			channel, err := GenerateData(context.Background(), filePath, CompressionAuto, CSVLoader{})
+			g.Assert(err).Equal(nil)
+
+			count := 0
+
+			for range channel {
+				count++
+			}
+
+			g.Assert(count).Equal(1)
+		})
+
+		g.It("It should write and read back compressed files", func() {
+			for _, compression := range []string{CompressionGzip, CompressionZstd} {
+				filePath := fmt.Sprintf("%s/compression_test01.csv.%s", cacheDir, compression)
+
+				err := StoreData(context.Background(), filePath, OutputCSV, compression, sendResults([]RideResult{
+					{RideID: 1, Fare: 3.47},
+					{RideID: 2, Fare: 58.3},
+				}))
+				g.Assert(err).Equal(nil)
+
+				file, err := OpenCompressedFile(filePath, compression)
+				g.Assert(err).Equal(nil)
+
+				content, err := ioutil.ReadAll(file)
+				g.Assert(err).Equal(nil)
+				g.Assert(file.Close()).Equal(nil)
+				g.Assert(string(content)).Equal("1,3.47\n2,58.30\n")
+			}
+		})
+
+		g.It("It should read the standard input and write to the standard output", func() {
+			defer func() {
+				stdin = bufio.NewReader(os.Stdin)
+				stdout = os.Stdout
+			}()
+
+			data, err := ioutil.ReadFile(fmt.Sprintf("%s/test_paths_03.jsonl", testDataDir))
+			g.Assert(err).Equal(nil)
+
+			var compressed bytes.Buffer
+
+			writer, err := NewCompressedWriter(nopWriteCloser{&compressed}, CompressionZstd)
+			g.Assert(err).Equal(nil)
+
+			_, err = writer.Write(data)
+			g.Assert(err).Equal(nil)
+			g.Assert(writer.Close()).Equal(nil)
+
+			stdin = bufio.NewReader(&compressed)
+
+			// The peeked data is still there for the data reader
+			format, err := DetectFormat(StdStream, CompressionAuto)
+			g.Assert(format).Equal(FormatJSONL)
+			g.Assert(err).Equal(nil)
+
+			channel, err := GenerateData(context.Background(), StdStream, CompressionAuto, NewJSONLoader())
+			g.Assert(err).Equal(nil)
+
+			var output bytes.Buffer
+			stdout = &output
+
+			err = StoreData(context.Background(), StdStream, OutputCSV, CompressionNone, ProcessData(context.Background(), channel, &RideProcessor{}))
+			g.Assert(err).Equal(nil)
+			g.Assert(strings.Contains(output.String(), "2,58.30\n")).Equal(true)
+			g.Assert(strings.Contains(output.String(), "3,3.47\n")).Equal(true)
+		})
+
+		g.It("It should not read the standard input for the standard output compression", func() {
+			reader, writer := io.Pipe()
+			defer func() {
+				writer.Close()
+				stdin = bufio.NewReader(os.Stdin)
+			}()
+
+			// Peeking the standard input would block till it is closed
+			stdin = bufio.NewReader(reader)
+
+			compression, err := ResolveOutputCompression(StdStream, CompressionAuto)
+			g.Assert(compression).Equal(CompressionNone)
+			g.Assert(err).Equal(nil)
+
+			compression, err = ResolveOutputCompression(StdStream, CompressionGzip)
+			g.Assert(compression).Equal(CompressionGzip)
+			g.Assert(err).Equal(nil)
+
+			compression, err = ResolveOutputCompression("rides.csv.zst", CompressionAuto)
+			g.Assert(compression).Equal(CompressionZstd)
+			g.Assert(err).Equal(nil)
+		})
+
+		g.It("It should fail for plain data read as gzip", func() {
+			_, err := GenerateData(context.Background(), fmt.Sprintf("%s/test_paths_01.csv", testDataDir), CompressionGzip, CSVLoader{})
+			g.Assert(err != nil).Equal(true)
+		})
+	})
+}
@@ -0,0 +1,706 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package module
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bitbucket.org/clivern/beat/core/util"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// TaskPending for the tasks waiting for a worker
+	TaskPending = "pending"
+	// TaskRunning for the tasks claimed by a worker
+	TaskRunning = "running"
+	// TaskDone for the tasks with uploaded results
+	TaskDone = "done"
+)
+
+// Task struct type
+// A part of the dataset calculated by a worker, the byte range of a dataset file from
+// Start to End. Uncompressed files are split at ride boundaries and the other tasks of
+// a file get its header first. The attempt increases on every claim so the results of
+// a worker that lost the task are rejected
+type Task struct {
+	ID          int    `json:"id"`
+	File        int    `json:"file"`
+	Start       int64  `json:"start"`
+	End         int64  `json:"end"`
+	Format      string `json:"format"`
+	Compression string `json:"compression"`
+	Ordered     bool   `json:"ordered"`
+	Attempt     int    `json:"attempt"`
+}
+
+// TaskStatus struct type
+// The tasks count by status and the failed attempts
+type TaskStatus struct {
+	Tasks    int `json:"tasks"`
+	Pending  int `json:"pending"`
+	Running  int `json:"running"`
+	Done     int `json:"done"`
+	Failures int `json:"failures"`
+}
+
+// taskState struct type
+// The status of a task, the worker running it and its uploaded results file
+type taskState struct {
+	task     Task
+	status   string
+	worker   string
+	claimed  time.Time
+	failures int
+	results  string
+}
+
+// Coordinator struct type
+// It splits the dataset files into tasks, hands them to the workers over HTTP and keeps
+// the uploaded results till all tasks are done. A task goes back to the pending tasks if
+// its worker fails or doesn't upload the results within TaskTimeout, the run fails once a
+// task failed MaxAttempts times. The rides spanning dataset files are priced again with
+// the Processor once joined
+type Coordinator struct {
+	DatasetFiles []string
+	TaskTimeout  time.Duration
+	MaxAttempts  int
+	Processor    *RideProcessor
+
+	loader    RideLoader
+	workDir   string
+	headers   []int64
+	states    []*taskState
+	remaining int
+	workers   map[string]bool
+	mutex     sync.Mutex
+	done      chan struct{}
+	err       error
+}
+
+// CoordinatorServer struct type
+// The HTTP server of a coordinator
+type CoordinatorServer struct {
+	server   *http.Server
+	listener net.Listener
+}
+
+// NewCoordinator creates a new instance of Coordinator. Uncompressed dataset files are split
+// into tasks of about distributed.task_size MB that start at a ride boundary, the other files
+// are a task each. The uploaded results are kept in a new directory inside workDir
+func NewCoordinator(filePaths []string, format, compression string, loader RideLoader, workDir string) (*Coordinator, error) {
+	if len(filePaths) == 0 {
+		return nil, fmt.Errorf("No dataset file provided")
+	}
+
+	coordinator := &Coordinator{
+		DatasetFiles: filePaths,
+		TaskTimeout:  time.Duration(viper.GetFloat64("distributed.task_timeout") * float64(time.Second)),
+		MaxAttempts:  viper.GetInt("distributed.max_attempts"),
+		loader:       loader,
+		headers:      make([]int64, len(filePaths)),
+		workers:      make(map[string]bool),
+		done:         make(chan struct{}),
+	}
+
+	if coordinator.MaxAttempts <= 0 {
+		coordinator.MaxAttempts = 1
+	}
+
+	for index, filePath := range filePaths {
+		if filePath == StdStream {
+			return nil, fmt.Errorf("The standard input can't be split into tasks")
+		}
+
+		if !util.FileExists(filePath) {
+			return nil, fmt.Errorf("File %s not found", filePath)
+		}
+
+		resolved, err := ResolveCompression(filePath, compression)
+
+		if err != nil {
+			return nil, err
+		}
+
+		info, err := os.Stat(filePath)
+
+		if err != nil {
+			return nil, err
+		}
+
+		shards := []datasetShard{{End: info.Size()}}
+
+		if resolved == CompressionNone {
+			// The header is read to get the offset of the first ride
+			dataset, err := openDataset(filePaths, index, resolved, loader, nil)
+
+			if err != nil {
+				return nil, err
+			}
+
+			dataset.file.Close()
+			coordinator.headers[index] = dataset.position.Offset
+
+			shards, err = planShards(filePath, loader, dataset.position.Offset, taskCount(info.Size()-dataset.position.Offset))
+
+			if err != nil {
+				return nil, fmt.Errorf("Unable to read file %s: %s", filePath, err.Error())
+			}
+
+			// The first task reads the header with its rides
+			shards[0].Start = 0
+		}
+
+		for _, shard := range shards {
+			coordinator.states = append(coordinator.states, &taskState{
+				task: Task{
+					ID:          len(coordinator.states),
+					File:        index,
+					Start:       shard.Start,
+					End:         shard.End,
+					Format:      format,
+					Compression: resolved,
+					Ordered:     viper.GetBool("output.ordered"),
+				},
+				status: TaskPending,
+			})
+		}
+	}
+
+	coordinator.remaining = len(coordinator.states)
+
+	dir, err := ioutil.TempDir(workDir, "coordinator_")
+
+	if err != nil {
+		return nil, err
+	}
+
+	coordinator.workDir = dir
+
+	return coordinator, nil
+}
+
+// taskCount gets the number of tasks of size bytes from distributed.task_size
+func taskCount(size int64) int {
+	taskSize := int64(viper.GetFloat64("distributed.task_size") * (1 << 20))
+
+	if taskSize <= 0 || size <= taskSize {
+		return 1
+	}
+
+	return int((size + taskSize - 1) / taskSize)
+}
+
+// Tasks gets the tasks
+func (c *Coordinator) Tasks() []Task {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	tasks := make([]Task, 0, len(c.states))
+
+	for _, state := range c.states {
+		tasks = append(tasks, state.task)
+	}
+
+	return tasks
+}
+
+// Status gets the tasks count by status
+func (c *Coordinator) Status() TaskStatus {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	status := TaskStatus{Tasks: len(c.states)}
+
+	for _, state := range c.states {
+		switch state.status {
+		case TaskPending:
+			status.Pending++
+		case TaskRunning:
+			status.Running++
+		case TaskDone:
+			status.Done++
+		}
+
+		status.Failures += state.failures
+	}
+
+	return status
+}
+
+// Handler gets the HTTP handler of the workers API
+//
+//	POST /tasks/claim?worker=<id>                  claim a task, 204 if none is pending and 410 once the run ended
+//	GET  /tasks/<id>/input?attempt=<attempt>       the dataset bytes of the task
+//	PUT  /tasks/<id>/results?attempt=<attempt>     upload the gzip compressed JSON Lines results
+//	POST /tasks/<id>/fail?attempt=<attempt>        report a failed task with the reason as body
+//	GET  /status                                   the tasks count by status
+func (c *Coordinator) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/tasks/claim", c.handleClaim)
+	mux.HandleFunc("/tasks/", c.handleTask)
+	mux.HandleFunc("/status", c.handleStatus)
+
+	return mux
+}
+
+// Wait waits till all tasks are done. It fails once a task failed MaxAttempts times or ctx is done
+func (c *Coordinator) Wait(ctx context.Context) error {
+	select {
+	case <-c.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.err
+}
+
+// Merge stores the results of the tasks in the tasks order into the output file. A ride
+// spanning dataset files is stored once in place of its trailing part. The stats are optional
+func (c *Coordinator) Merge(ctx context.Context, outputFile, format, compression string, stats *RunStats) error {
+	joins, err := c.joinRides(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	group, ctx := errgroup.WithContext(ctx)
+	results := make(chan RideResult, viper.GetInt("workers.buffers.results"))
+
+	group.Go(func() error {
+		defer close(results)
+
+		for _, state := range c.states {
+			state := state
+			taskResults := make(chan RideResult)
+
+			group.Go(func() error {
+				defer close(taskResults)
+
+				if err := readTaskResults(ctx, state.results, taskResults); err != nil {
+					return fmt.Errorf("Invalid results of task %d: %s", state.task.ID, err.Error())
+				}
+
+				return nil
+			})
+
+			for result := range taskResults {
+				if joined, ok := joins[taskResultKey{task: state.task.ID, offset: result.End.Offset}]; ok {
+					if joined == nil {
+						continue
+					}
+
+					result = *joined
+				}
+
+				select {
+				case results <- result:
+				case <-ctx.Done():
+				}
+			}
+
+			if ctx.Err() != nil {
+				return nil
+			}
+		}
+
+		return nil
+	})
+
+	group.Go(func() error {
+		return StoreData(ctx, outputFile, format, compression, stats.track(ctx, results))
+	})
+
+	return group.Wait()
+}
+
+// Drain waits till every worker that claimed a task knows that the run ended
+// so the workers exit before the coordinator stops serving
+func (c *Coordinator) Drain(ctx context.Context) {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		c.mutex.Lock()
+		informed := true
+
+		for _, told := range c.workers {
+			informed = informed && told
+		}
+
+		c.mutex.Unlock()
+
+		if informed {
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Close removes the uploaded results
+func (c *Coordinator) Close() error {
+	return os.RemoveAll(c.workDir)
+}
+
+// StartCoordinatorServer serves the workers API of the coordinator on addr like :8080
+func StartCoordinatorServer(addr string, coordinator *Coordinator) (*CoordinatorServer, error) {
+	listener, err := net.Listen("tcp", addr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	server := &CoordinatorServer{
+		server:   &http.Server{Handler: coordinator.Handler()},
+		listener: listener,
+	}
+
+	go server.server.Serve(listener)
+
+	return server, nil
+}
+
+// Addr gets the address the server listens on
+func (s *CoordinatorServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Shutdown stops the server once the running requests end
+func (s *CoordinatorServer) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// handleClaim gives the next pending task to a worker. The tasks of the workers
+// past the task timeout go back to the pending tasks first
+func (c *Coordinator) handleClaim(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	worker := r.URL.Query().Get("worker")
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.ended() {
+		c.workers[worker] = true
+		w.WriteHeader(http.StatusGone)
+		return
+	}
+
+	c.workers[worker] = false
+
+	for _, state := range c.states {
+		if state.status == TaskRunning && c.TaskTimeout > 0 && time.Since(state.claimed) > c.TaskTimeout {
+			c.failTask(state, fmt.Sprintf("No results from worker %s after %s", state.worker, c.TaskTimeout))
+		}
+	}
+
+	if c.ended() {
+		c.workers[worker] = true
+		w.WriteHeader(http.StatusGone)
+		return
+	}
+
+	for _, state := range c.states {
+		if state.status != TaskPending {
+			continue
+		}
+
+		state.status = TaskRunning
+		state.worker = worker
+		state.claimed = time.Now()
+		state.task.Attempt++
+
+		log.WithFields(log.Fields{
+			"task_id": state.task.ID,
+			"attempt": state.task.Attempt,
+			"worker":  worker,
+		}).Info("Task claimed")
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(state.task)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleTask serves the input, the results and the failures of a task
+func (c *Coordinator) handleTask(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/tasks/"), "/"), "/")
+
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+
+	id, err := strconv.Atoi(parts[0])
+
+	if err != nil || id < 0 || id >= len(c.states) {
+		http.NotFound(w, r)
+		return
+	}
+
+	attempt, _ := strconv.Atoi(r.URL.Query().Get("attempt"))
+	state := c.states[id]
+
+	switch {
+	case parts[1] == "input" && r.Method == http.MethodGet:
+		c.handleInput(w, state, attempt)
+	case parts[1] == "results" && r.Method == http.MethodPut:
+		c.handleResults(w, r, state, attempt)
+	case parts[1] == "fail" && r.Method == http.MethodPost:
+		reason, _ := ioutil.ReadAll(io.LimitReader(r.Body, 1<<16))
+
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+
+		if !c.isRunning(state, attempt) {
+			http.Error(w, "The task attempt is over", http.StatusConflict)
+			return
+		}
+
+		c.failTask(state, string(reason))
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleInput sends the dataset bytes of a task
+func (c *Coordinator) handleInput(w http.ResponseWriter, state *taskState, attempt int) {
+	c.mutex.Lock()
+	running := c.isRunning(state, attempt)
+	c.mutex.Unlock()
+
+	if !running {
+		http.Error(w, "The task attempt is over", http.StatusConflict)
+		return
+	}
+
+	task := state.task
+	file, err := os.Open(c.DatasetFiles[task.File])
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	defer file.Close()
+
+	var header int64
+
+	if task.Start > 0 {
+		header = c.headers[task.File]
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(header+task.End-task.Start, 10))
+
+	if _, err := io.CopyN(w, file, header); err != nil {
+		return
+	}
+
+	if _, err := file.Seek(task.Start, io.SeekStart); err != nil {
+		return
+	}
+
+	io.CopyN(w, file, task.End-task.Start)
+}
+
+// handleResults stores the uploaded results of a task once they are decoded
+func (c *Coordinator) handleResults(w http.ResponseWriter, r *http.Request, state *taskState, attempt int) {
+	c.mutex.Lock()
+	running := c.isRunning(state, attempt)
+	c.mutex.Unlock()
+
+	if !running {
+		http.Error(w, "The task attempt is over", http.StatusConflict)
+		return
+	}
+
+	filePath := filepath.Join(c.workDir, fmt.Sprintf("task_%d_%d.jsonl.gz", state.task.ID, attempt))
+	rides, err := storeTaskResults(filePath, r.Body)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if err != nil {
+		if c.isRunning(state, attempt) {
+			c.failTask(state, fmt.Sprintf("Invalid results: %s", err.Error()))
+		}
+
+		http.Error(w, fmt.Sprintf("Invalid results: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	if !c.isRunning(state, attempt) {
+		os.Remove(filePath)
+		http.Error(w, "The task attempt is over", http.StatusConflict)
+		return
+	}
+
+	state.status = TaskDone
+	state.results = filePath
+	c.remaining--
+
+	log.WithFields(log.Fields{
+		"task_id": state.task.ID,
+		"attempt": attempt,
+		"worker":  state.worker,
+		"rides":   rides,
+	}).Info("Task done")
+
+	if c.remaining == 0 && c.err == nil {
+		close(c.done)
+	}
+}
+
+// handleStatus sends the tasks count by status
+func (c *Coordinator) handleStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.Status())
+}
+
+// isRunning checks if a task attempt is still running
+func (c *Coordinator) isRunning(state *taskState, attempt int) bool {
+	return state.status == TaskRunning && state.task.Attempt == attempt
+}
+
+// ended checks if all tasks are done or the run failed
+func (c *Coordinator) ended() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// failTask puts a failed task back to the pending tasks or fails
+// the run once the task failed MaxAttempts times
+func (c *Coordinator) failTask(state *taskState, reason string) {
+	state.failures++
+	state.status = TaskPending
+
+	log.WithFields(log.Fields{
+		"task_id": state.task.ID,
+		"attempt": state.task.Attempt,
+		"worker":  state.worker,
+		"reason":  reason,
+	}).Warn("Task failed")
+
+	if state.failures >= c.MaxAttempts && c.err == nil {
+		c.err = fmt.Errorf("Task %d failed %d times: %s", state.task.ID, state.failures, reason)
+		close(c.done)
+	}
+}
+
+// storeTaskResults writes the gzip compressed JSON Lines results to a file
+// and gets the rides count. The results are decoded while being written
+func storeTaskResults(filePath string, reader io.Reader) (int, error) {
+	file, err := createAtomicFile(filePath)
+
+	if err != nil {
+		return 0, err
+	}
+
+	decompressor, err := gzip.NewReader(io.TeeReader(reader, file))
+
+	if err != nil {
+		file.Abort()
+		return 0, err
+	}
+
+	decoder := json.NewDecoder(decompressor)
+	rides := 0
+
+	for {
+		var result RideResult
+
+		if err := decoder.Decode(&result); err == io.EOF {
+			break
+		} else if err != nil {
+			file.Abort()
+			return 0, err
+		}
+
+		rides++
+	}
+
+	// Read the rest of the gzip stream
+	if _, err := io.Copy(ioutil.Discard, decompressor); err != nil {
+		file.Abort()
+		return 0, err
+	}
+
+	if err := file.Close(); err != nil {
+		return 0, err
+	}
+
+	return rides, nil
+}
+
+// readTaskResults sends the results of a task results file to the channel
+func readTaskResults(ctx context.Context, filePath string, channel chan<- RideResult) error {
+	file, err := os.Open(filePath)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	decompressor, err := gzip.NewReader(bufio.NewReader(file))
+
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(decompressor)
+
+	for {
+		var result RideResult
+
+		if err := decoder.Decode(&result); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		select {
+		case channel <- result:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
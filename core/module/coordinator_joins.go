@@ -0,0 +1,263 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package module
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+)
+
+// taskResultKey struct type
+// A result of a task by the offset right after its ride in the task input
+type taskResultKey struct {
+	task   int
+	offset int64
+}
+
+// taskEdges struct type
+// The first and the last rides of a task results and the end of the ride before the last one
+type taskEdges struct {
+	first      *RideResult
+	last       *RideResult
+	beforeLast int64
+}
+
+// ridePart struct type
+// The lines of a ride part in a dataset file from Start to End
+type ridePart struct {
+	file        int
+	compression string
+	start       int64
+	end         int64
+}
+
+// joinRides prices again the rides spanning dataset files like a sequential run does.
+// The workers price the trailing part of a dataset file and the leading part of the
+// next one as two rides, so the result of the trailing part is replaced by the joined
+// ride and the results of the other parts are dropped (nil)
+func (c *Coordinator) joinRides(ctx context.Context) (map[taskResultKey]*RideResult, error) {
+	joins := make(map[taskResultKey]*RideResult)
+
+	if len(c.DatasetFiles) < 2 {
+		return joins, nil
+	}
+
+	firstTasks := make([]*taskState, len(c.DatasetFiles))
+	lastTasks := make([]*taskState, len(c.DatasetFiles))
+
+	for _, state := range c.states {
+		if firstTasks[state.task.File] == nil {
+			firstTasks[state.task.File] = state
+		}
+
+		lastTasks[state.task.File] = state
+	}
+
+	edges := make(map[int]*taskEdges)
+
+	for index := range c.DatasetFiles {
+		for _, state := range []*taskState{firstTasks[index], lastTasks[index]} {
+			if _, ok := edges[state.task.ID]; ok {
+				continue
+			}
+
+			taskEdges, err := readTaskEdges(state.results)
+
+			if err != nil {
+				return nil, fmt.Errorf("Invalid results of task %d: %s", state.task.ID, err.Error())
+			}
+
+			edges[state.task.ID] = taskEdges
+		}
+	}
+
+	for index := 0; index < len(c.DatasetFiles)-1; index++ {
+		trailing := edges[lastTasks[index].task.ID].last
+		leading := edges[firstTasks[index+1].task.ID].first
+
+		if trailing == nil || leading == nil || trailing.RideID != leading.RideID {
+			continue
+		}
+
+		state := lastTasks[index]
+		key := taskResultKey{task: state.task.ID, offset: trailing.End.Offset}
+
+		// The ride already continues from a previous dataset file
+		if _, ok := joins[key]; ok {
+			continue
+		}
+
+		start := int64(0)
+
+		if edges[state.task.ID].beforeLast > 0 {
+			start = c.fileOffset(state.task, edges[state.task.ID].beforeLast)
+		} else if state.task.Start > 0 {
+			start = state.task.Start
+		}
+
+		parts := []ridePart{{
+			file:        index,
+			compression: state.task.Compression,
+			start:       start,
+			end:         c.fileOffset(state.task, trailing.End.Offset),
+		}}
+
+		// The ride continues while the leading ride is the only ride of its dataset file
+		for next := index + 1; next < len(c.DatasetFiles); next++ {
+			first := firstTasks[next]
+			leading := edges[first.task.ID].first
+
+			if leading == nil || leading.RideID != trailing.RideID {
+				break
+			}
+
+			parts = append(parts, ridePart{
+				file:        next,
+				compression: first.task.Compression,
+				end:         c.fileOffset(first.task, leading.End.Offset),
+			})
+			joins[taskResultKey{task: first.task.ID, offset: leading.End.Offset}] = nil
+
+			last := lastTasks[next]
+
+			if last != first || edges[last.task.ID].last.End.Offset != leading.End.Offset {
+				break
+			}
+		}
+
+		result, err := c.priceRide(ctx, parts)
+
+		if err != nil {
+			return nil, err
+		}
+
+		joins[key] = result
+	}
+
+	return joins, nil
+}
+
+// priceRide reads the parts of a ride spanning dataset files and calculates its fare
+func (c *Coordinator) priceRide(ctx context.Context, parts []ridePart) (*RideResult, error) {
+	batches := make(chan RideBatch)
+	drained := make(chan int)
+
+	// The parts hold a single ride so any sent ride is unexpected
+	go func() {
+		count := 0
+
+		for range batches {
+			count++
+		}
+
+		drained <- count
+	}()
+
+	scanner := newRideScanner(c.loader, nil, nil, batches)
+	err := c.scanRideParts(ctx, scanner, parts)
+
+	close(batches)
+
+	if count := <-drained; err == nil && count > 0 {
+		err = fmt.Errorf("Unexpected rides before ride %d", scanner.rideID)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	processor := c.Processor
+
+	if processor == nil {
+		processor = &RideProcessor{}
+	}
+
+	result := processBatch(RideBatch{
+		Source:      parts[0].file,
+		RideID:      scanner.rideID,
+		Coordinates: scanner.coordinates,
+		End:         scanner.rideEnd,
+	}, processor)
+
+	return &result, nil
+}
+
+// scanRideParts reads the lines of the ride parts into the scanner
+func (c *Coordinator) scanRideParts(ctx context.Context, scanner *rideScanner, parts []ridePart) error {
+	for _, part := range parts {
+		filePath := c.DatasetFiles[part.file]
+
+		// The header of every dataset file is read again like a sequential run does
+		dataset, err := openDataset(c.DatasetFiles, part.file, part.compression, c.loader, nil)
+
+		if err != nil {
+			return err
+		}
+
+		err = dataset.seek(part.start)
+
+		if err == nil {
+			dataset.reader = bufio.NewReader(io.LimitReader(dataset.reader, part.end-dataset.position.Offset))
+			_, err = scanner.scan(ctx, dataset)
+		}
+
+		dataset.file.Close()
+
+		if err != nil {
+			return fmt.Errorf("Unable to read file %s: %s", filePath, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// fileOffset gets the dataset file offset of an offset in the task input. The
+// tasks starting after the first ride get the header of their file first
+func (c *Coordinator) fileOffset(task Task, offset int64) int64 {
+	if task.Start == 0 {
+		return offset
+	}
+
+	return task.Start + offset - c.headers[task.File]
+}
+
+// readTaskEdges gets the first and the last rides of a task results file by
+// their position in the task input, the results may be out of order
+func readTaskEdges(filePath string) (*taskEdges, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	results := make(chan RideResult)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(results)
+		errs <- readTaskResults(ctx, filePath, results)
+	}()
+
+	edges := &taskEdges{}
+
+	for result := range results {
+		result := result
+
+		if edges.first == nil || result.End.Offset < edges.first.End.Offset {
+			edges.first = &result
+		}
+
+		if edges.last == nil || result.End.Offset > edges.last.End.Offset {
+			if edges.last != nil {
+				edges.beforeLast = edges.last.End.Offset
+			}
+
+			edges.last = &result
+		} else if result.End.Offset > edges.beforeLast {
+			edges.beforeLast = result.End.Offset
+		}
+	}
+
+	return edges, <-errs
+}
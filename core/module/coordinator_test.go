@@ -0,0 +1,283 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package module
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"bitbucket.org/clivern/beat/pkg"
+
+	"github.com/franela/goblin"
+	"github.com/spf13/viper"
+)
+
+// TestCoordinator test cases
+func TestCoordinator(t *testing.T) {
+	baseDir := pkg.GetBaseDir("cache")
+	testDataDir := fmt.Sprintf("%s/%s", baseDir, "testdata")
+	cacheDir := fmt.Sprintf("%s/%s", baseDir, "cache")
+	pkg.LoadConfigs(fmt.Sprintf("%s/config.dist.yml", baseDir))
+
+	g := goblin.Goblin(t)
+
+	// claim claims a task as a worker and gets the response status
+	claim := func(server *httptest.Server, worker string) (Task, int) {
+		response, err := http.Post(fmt.Sprintf("%s/tasks/claim?worker=%s", server.URL, worker), "", nil)
+		g.Assert(err).Equal(nil)
+
+		defer response.Body.Close()
+
+		var task Task
+
+		if response.StatusCode == http.StatusOK {
+			g.Assert(json.NewDecoder(response.Body).Decode(&task)).Equal(nil)
+		}
+
+		return task, response.StatusCode
+	}
+
+	// request sends a request for a task attempt and gets the response status and body
+	request := func(server *httptest.Server, method string, task Task, action string, body []byte) (int, string) {
+		req, err := http.NewRequest(method, fmt.Sprintf("%s/tasks/%d/%s?attempt=%d", server.URL, task.ID, action, task.Attempt), bytes.NewReader(body))
+		g.Assert(err).Equal(nil)
+
+		response, err := http.DefaultClient.Do(req)
+		g.Assert(err).Equal(nil)
+
+		defer response.Body.Close()
+
+		content, err := ioutil.ReadAll(response.Body)
+		g.Assert(err).Equal(nil)
+
+		return response.StatusCode, string(content)
+	}
+
+	// results gets gzip compressed JSON Lines results
+	results := func(rideIDs ...int) []byte {
+		var buffer bytes.Buffer
+
+		compressor := gzip.NewWriter(&buffer)
+
+		for _, rideID := range rideIDs {
+			json.NewEncoder(compressor).Encode(RideResult{RideID: rideID, Fare: 3.47})
+		}
+
+		compressor.Close()
+
+		return buffer.Bytes()
+	}
+
+	newCoordinator := func(filePaths ...string) *Coordinator {
+		coordinator, err := NewCoordinator(filePaths, OutputCSV, CompressionAuto, CSVLoader{}, cacheDir)
+		g.Assert(err).Equal(nil)
+
+		return coordinator
+	}
+
+	g.Describe("Coordinator", func() {
+		g.It("It should split the dataset files into tasks", func() {
+			defer viper.Set("distributed.task_size", 64)
+
+			viper.Set("distributed.task_size", 0.0005)
+
+			coordinator := newCoordinator(
+				fmt.Sprintf("%s/test_paths_01.csv", testDataDir),
+				fmt.Sprintf("%s/test_paths_02.csv.gz", testDataDir),
+			)
+			defer coordinator.Close()
+
+			tasks := coordinator.Tasks()
+			g.Assert(len(tasks) > 2).Equal(true)
+			g.Assert(tasks[0].Start).Equal(int64(0))
+
+			// The compressed file is a single task
+			last := tasks[len(tasks)-1]
+			g.Assert(last.File).Equal(1)
+			g.Assert(last.Start).Equal(int64(0))
+			g.Assert(last.Compression).Equal(CompressionGzip)
+
+			for index, task := range tasks[:len(tasks)-1] {
+				g.Assert(task.ID).Equal(index)
+				g.Assert(task.File).Equal(0)
+
+				if index > 0 {
+					g.Assert(task.Start).Equal(tasks[index-1].End)
+				}
+			}
+
+			g.Assert(coordinator.Status()).Equal(TaskStatus{Tasks: len(tasks), Pending: len(tasks)})
+		})
+
+		g.It("It should fail for the standard input", func() {
+			_, err := NewCoordinator([]string{StdStream}, OutputCSV, CompressionAuto, CSVLoader{}, cacheDir)
+			g.Assert(err != nil).Equal(true)
+		})
+
+		g.It("It should serve the task input with the file header", func() {
+			defer viper.Set("distributed.task_size", 64)
+
+			viper.Set("distributed.task_size", 0.0005)
+
+			content, err := ioutil.ReadFile(fmt.Sprintf("%s/test_paths_01.csv", testDataDir))
+			g.Assert(err).Equal(nil)
+
+			filePath := fmt.Sprintf("%s/coordinator_test02.csv", cacheDir)
+			g.Assert(ioutil.WriteFile(filePath, append([]byte("id,lat,lng,ts\n"), content...), 0644)).Equal(nil)
+
+			loader, err := NewCSVLoader()
+			g.Assert(err).Equal(nil)
+
+			coordinator, err := NewCoordinator([]string{filePath}, OutputCSV, CompressionAuto, loader, cacheDir)
+			g.Assert(err).Equal(nil)
+			defer coordinator.Close()
+
+			server := httptest.NewServer(coordinator.Handler())
+			defer server.Close()
+
+			first, status := claim(server, "worker-1")
+			g.Assert(status).Equal(http.StatusOK)
+
+			second, status := claim(server, "worker-1")
+			g.Assert(status).Equal(http.StatusOK)
+			g.Assert(second.Start > 0).Equal(true)
+
+			status, firstInput := request(server, http.MethodGet, first, "input", nil)
+			g.Assert(status).Equal(http.StatusOK)
+
+			status, secondInput := request(server, http.MethodGet, second, "input", nil)
+			g.Assert(status).Equal(http.StatusOK)
+
+			// Both inputs start with the header row
+			g.Assert(strings.HasPrefix(firstInput, "id,lat,lng,ts\n1,")).Equal(true)
+			g.Assert(strings.HasPrefix(secondInput, "id,lat,lng,ts\n")).Equal(true)
+			g.Assert(int64(len(secondInput))).Equal(int64(len("id,lat,lng,ts\n")) + second.End - second.Start)
+		})
+
+		g.It("It should retry the failed tasks", func() {
+			defer viper.Set("distributed.max_attempts", 3)
+
+			viper.Set("distributed.max_attempts", 2)
+
+			coordinator := newCoordinator(fmt.Sprintf("%s/test_paths_02.csv", testDataDir))
+			defer coordinator.Close()
+
+			server := httptest.NewServer(coordinator.Handler())
+			defer server.Close()
+
+			task, status := claim(server, "worker-1")
+			g.Assert(status).Equal(http.StatusOK)
+			g.Assert(task.Attempt).Equal(1)
+
+			// No pending task while the task runs
+			_, status = claim(server, "worker-2")
+			g.Assert(status).Equal(http.StatusNoContent)
+
+			status, _ = request(server, http.MethodPost, task, "fail", []byte("Out of memory"))
+			g.Assert(status).Equal(http.StatusOK)
+
+			retry, status := claim(server, "worker-2")
+			g.Assert(status).Equal(http.StatusOK)
+			g.Assert(retry.ID).Equal(task.ID)
+			g.Assert(retry.Attempt).Equal(2)
+
+			// The results of the previous attempt are rejected
+			status, _ = request(server, http.MethodPut, task, "results", results(2))
+			g.Assert(status).Equal(http.StatusConflict)
+
+			// Invalid results fail the task for the last time
+			status, _ = request(server, http.MethodPut, retry, "results", []byte("invalid"))
+			g.Assert(status).Equal(http.StatusBadRequest)
+
+			err := coordinator.Wait(context.Background())
+			g.Assert(err != nil).Equal(true)
+			g.Assert(strings.HasPrefix(err.Error(), "Task 0 failed 2 times")).Equal(true)
+
+			_, status = claim(server, "worker-1")
+			g.Assert(status).Equal(http.StatusGone)
+		})
+
+		g.It("It should give the timed out tasks to another worker", func() {
+			coordinator := newCoordinator(fmt.Sprintf("%s/test_paths_02.csv", testDataDir))
+			defer coordinator.Close()
+
+			coordinator.TaskTimeout = 10 * time.Millisecond
+
+			server := httptest.NewServer(coordinator.Handler())
+			defer server.Close()
+
+			task, status := claim(server, "worker-1")
+			g.Assert(status).Equal(http.StatusOK)
+
+			time.Sleep(20 * time.Millisecond)
+
+			retry, status := claim(server, "worker-2")
+			g.Assert(status).Equal(http.StatusOK)
+			g.Assert(retry.Attempt).Equal(task.Attempt + 1)
+
+			status, _ = request(server, http.MethodPut, retry, "results", results(2))
+			g.Assert(status).Equal(http.StatusOK)
+			g.Assert(coordinator.Wait(context.Background())).Equal(nil)
+			g.Assert(coordinator.Status()).Equal(TaskStatus{Tasks: 1, Done: 1, Failures: 1})
+		})
+
+		g.It("It should merge the task results in the tasks order", func() {
+			defer viper.Set("distributed.task_size", 64)
+
+			viper.Set("distributed.task_size", 0.0005)
+
+			coordinator := newCoordinator(fmt.Sprintf("%s/test_paths_01.csv", testDataDir))
+			defer coordinator.Close()
+
+			server := httptest.NewServer(coordinator.Handler())
+			defer server.Close()
+
+			var tasks []Task
+
+			for {
+				task, status := claim(server, "worker-1")
+
+				if status != http.StatusOK {
+					break
+				}
+
+				tasks = append(tasks, task)
+			}
+
+			g.Assert(len(tasks) > 1).Equal(true)
+
+			// The results are uploaded in the reverse order
+			for index := len(tasks) - 1; index >= 0; index-- {
+				status, _ := request(server, http.MethodPut, tasks[index], "results", results(index*2+1, index*2+2))
+				g.Assert(status).Equal(http.StatusOK)
+			}
+
+			g.Assert(coordinator.Wait(context.Background())).Equal(nil)
+
+			outputFile := fmt.Sprintf("%s/coordinator_test01.csv", cacheDir)
+			g.Assert(coordinator.Merge(context.Background(), outputFile, OutputCSV, CompressionAuto, nil)).Equal(nil)
+
+			content, err := ioutil.ReadFile(outputFile)
+			g.Assert(err).Equal(nil)
+
+			var expected []string
+
+			for rideID := 1; rideID <= len(tasks)*2; rideID++ {
+				expected = append(expected, fmt.Sprintf("%d,3.47", rideID))
+			}
+
+			g.Assert(strings.Split(strings.TrimSpace(string(content)), "\n")).Equal(expected)
+		})
+	})
+}
@@ -6,11 +6,14 @@ package module
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
 	"os"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"bitbucket.org/clivern/beat/core/model"
 	"bitbucket.org/clivern/beat/core/util"
@@ -19,135 +22,732 @@ import (
 	"github.com/spf13/viper"
 )
 
+// RideBatch struct type
+// The ride id and the coordinates parsed from the ride lines, the ride position in the
+// dataset, the index of the dataset file where the ride starts and the position right after the ride
+type RideBatch struct {
+	Sequence    int
+	Source      int
+	RideID      int
+	Coordinates []model.Coordinate
+	End         Position
+}
+
+// RideResult struct type
+// The ride id and fare, the ride position in the dataset, the index of its dataset file and
+// the position right after the ride. The metrics, the segments and the kept and removed
+// points are used by the detailed outputs, the removed points include the points rejected
+// by validation which are also counted per rejection reason. The segments are only set for
+// the SQLite segments output. The duration is the time taken to process the ride
+type RideResult struct {
+	Sequence       int
+	Source         int
+	End            Position
+	RideID         int
+	Fare           float64
+	Metrics        model.RideMetrics
+	Segments       []model.RideSegment `json:",omitempty"`
+	PointsKept     int
+	PointsRemoved  int
+	PointsRejected int
+	RejectedBy     map[string]int `json:",omitempty"`
+	Duration       time.Duration
+
+	// The priced ride kept for the anomaly detector
+	ride *model.Ride
+}
+
+// Position struct type
+// The index of a dataset file and a byte offset in its decompressed data
+type Position struct {
+	File   int   `json:"file"`
+	Offset int64 `json:"offset"`
+}
+
+// datasetReader struct type
+// An opened dataset file and the position of its reader
+type datasetReader struct {
+	file     io.ReadCloser
+	reader   *bufio.Reader
+	position Position
+}
+
+// countingReader struct type
+// It counts the bytes read from the underlying reader
+type countingReader struct {
+	io.Reader
+	count int64
+}
+
+// Read reads from the underlying reader and counts the read bytes
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.Reader.Read(p)
+	c.count += int64(n)
+
+	return n, err
+}
+
 // GenerateData sends a ride data as string to a channel
 // The ride data is a certain number of lines containing coordinates (segments)
-func GenerateData(filePath string) (<-chan string, error) {
+// and the loader gets the ride id of each line. Compressed files are decompressed
+// while being read and the file path - reads the standard input
+func GenerateData(ctx context.Context, filePath, compression string, loader RideLoader) (<-chan RideBatch, error) {
+	return GenerateDatasets(ctx, []string{filePath}, compression, loader)
+}
+
+// GenerateDatasets sends the rides of many dataset files to a channel as one dataset
+// A ride at the end of a file continues in the next file if the ride id is the same
+// Reading stops and the channel is closed once ctx is done
+func GenerateDatasets(ctx context.Context, filePaths []string, compression string, loader RideLoader) (<-chan RideBatch, error) {
+	channel := make(chan RideBatch, viper.GetInt("workers.buffers.rides"))
 
-	channel := make(chan string)
+	dataset, err := openDatasets(filePaths, compression, loader, Position{}, nil)
 
-	if !util.FileExists(filePath) {
-		return channel, fmt.Errorf("File %s not found", filePath)
+	if err != nil {
+		return channel, err
 	}
 
 	go func() {
-		var rideInfo string
-		var previousRideID string
-		var rideBatch string
+		if err := readDatasets(ctx, filePaths, compression, loader, dataset, nil, nil, channel); err != nil {
+			log.WithFields(log.Fields{
+				"stage":  StageRead,
+				"reason": err.Error(),
+			}).Error("Error while reading dataset files")
+		}
+	}()
 
-		file, _ := os.Open(filePath)
+	return channel, nil
+}
 
-		defer file.Close()
+// openDatasets checks the dataset files and their headers then opens the
+// dataset file of the start position and moves its reader to the position
+// The bytes read from the dataset files are added to the progress if set
+func openDatasets(filePaths []string, compression string, loader RideLoader, start Position, progress *Progress) (*datasetReader, error) {
+	if len(filePaths) == 0 {
+		return nil, fmt.Errorf("No dataset file provided")
+	}
 
-		reader := bufio.NewReader(file)
+	if start.File < 0 || start.File >= len(filePaths) {
+		return nil, fmt.Errorf("Invalid dataset file index %d", start.File)
+	}
 
-		for {
-			line, err := reader.ReadString('\n')
+	for _, filePath := range filePaths {
+		if filePath == StdStream && len(filePaths) > 1 {
+			return nil, fmt.Errorf("The standard input can't be combined with other dataset files")
+		}
 
-			// If end of lines reached
-			if err == io.EOF && rideInfo == "" {
-				break
-			} else if err == io.EOF {
-				// Send last ride info
-				channel <- rideInfo
-				break
+		if filePath != StdStream && !util.FileExists(filePath) {
+			return nil, fmt.Errorf("File %s not found", filePath)
+		}
+	}
+
+	dataset, err := openDataset(filePaths, start.File, compression, loader, progress)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Check the other files headers before streaming
+	for index := range filePaths {
+		if index == start.File {
+			continue
+		}
+
+		other, err := openDataset(filePaths, index, compression, loader, nil)
+
+		if err != nil {
+			dataset.file.Close()
+			return nil, err
+		}
+
+		other.file.Close()
+	}
+
+	// The files before the start position are already read
+	for index := 0; index < start.File; index++ {
+		if info, err := os.Stat(filePaths[index]); err == nil {
+			progress.addBytes(info.Size())
+		}
+	}
+
+	if err := dataset.seek(start.Offset); err != nil {
+		dataset.file.Close()
+		return nil, fmt.Errorf("Unable to read file %s: %s", filePaths[start.File], err.Error())
+	}
+
+	return dataset, nil
+}
+
+// readDatasets sends the rides of the dataset files to the channel starting from the
+// opened dataset file and closes the channel at the end. Once ctx is done the ride being
+// read is dropped and no error is returned so the rides already sent can be stored
+func readDatasets(ctx context.Context, filePaths []string, compression string, loader RideLoader, dataset *datasetReader, progress *Progress, metrics *Metrics, channel chan<- RideBatch) error {
+	defer close(channel)
+
+	scanner := newRideScanner(loader, progress, metrics, channel)
+
+	for source := dataset.position.File; source < len(filePaths); source++ {
+		if source > dataset.position.File {
+			var err error
+
+			dataset, err = openDataset(filePaths, source, compression, loader, progress)
+
+			if err != nil {
+				return err
 			}
+		}
 
-			line = strings.TrimSpace(line)
+		done, err := scanner.scan(ctx, dataset)
+		dataset.file.Close()
 
-			if line == "" {
-				continue
+		if err != nil {
+			return fmt.Errorf("Unable to read file %s: %s", filePaths[source], err.Error())
+		}
+
+		if !done {
+			return nil
+		}
+	}
+
+	// Send last ride info
+	scanner.flush(ctx)
+
+	return nil
+}
+
+// rideScanner struct type
+// It parses the dataset lines into rides and sends a ride to the channel once the next
+// ride starts. The sequences of the sent rides start from sequence and every ride takes
+// a token of the order first if set
+type rideScanner struct {
+	loader      RideLoader
+	progress    *Progress
+	metrics     *Metrics
+	order       *rideOrder
+	channel     chan<- RideBatch
+	coordinates []model.Coordinate
+	rideID      int
+	rideSource  int
+	rideEnd     Position
+	rideStart   time.Time
+	sequence    int
+	sent        int
+}
+
+// newRideScanner creates a new instance of rideScanner
+func newRideScanner(loader RideLoader, progress *Progress, metrics *Metrics, channel chan<- RideBatch) *rideScanner {
+	return &rideScanner{
+		loader:   loader,
+		progress: progress,
+		metrics:  metrics,
+		channel:  channel,
+	}
+}
+
+// scan reads the lines of a dataset and sends its rides except the last one which may
+// continue in the next dataset. It returns false once ctx is done and fails on any read
+// error but the end of the dataset, a truncated compressed file is not a complete dataset
+func (s *rideScanner) scan(ctx context.Context, dataset *datasetReader) (bool, error) {
+	joiner, joinRecords := s.loader.(RecordJoiner)
+
+	var record string
+
+	for {
+		if ctx.Err() != nil {
+			return false, nil
+		}
+
+		line, err := dataset.reader.ReadString('\n')
+
+		if err != nil && err != io.EOF {
+			return false, err
+		}
+
+		dataset.position.Offset += int64(len(line))
+
+		// A record may span multiple lines like CSV quoted fields with line breaks
+		record += line
+
+		if err == nil && joinRecords && !joiner.IsCompleteRecord(record) {
+			continue
+		}
+
+		line = strings.TrimSpace(record)
+		record = ""
+
+		if line != "" {
+			// Each line is parsed once into the ride coordinates
+			currentRideID, coordinate, parseErr := s.loader.ParseLine(line)
+
+			if parseErr != nil {
+				log.WithFields(log.Fields{
+					"stage":  StageRead,
+					"reason": parseErr.Error(),
+					"line":   line,
+				}).Debug("Skip invalid line")
+				s.progress.addRejected(1)
+				s.metrics.addInvalidLine()
+			} else if len(s.coordinates) == 0 || currentRideID == s.rideID {
+				if len(s.coordinates) == 0 {
+					s.rideSource = dataset.position.File
+					s.rideStart = time.Now()
+				}
+
+				s.coordinates = append(s.coordinates, coordinate)
+				s.rideEnd = dataset.position
+				s.rideID = currentRideID
+			} else {
+				if !s.send(ctx) {
+					return false, nil
+				}
+
+				// The next ride likely has as many coordinates
+				next := make([]model.Coordinate, 1, len(s.coordinates))
+				next[0] = coordinate
+
+				s.coordinates = next
+				s.rideSource = dataset.position.File
+				s.rideStart = time.Now()
+				s.rideEnd = dataset.position
+				s.rideID = currentRideID
 			}
+		}
+
+		// If end of lines reached
+		if err == io.EOF {
+			return true, nil
+		}
+	}
+}
+
+// flush sends the last ride
+func (s *rideScanner) flush(ctx context.Context) {
+	if len(s.coordinates) > 0 {
+		s.send(ctx)
+	}
+}
+
+// send sends the current ride, it returns false once ctx is done
+func (s *rideScanner) send(ctx context.Context) bool {
+	s.metrics.observeRead(time.Since(s.rideStart))
+
+	if s.order != nil && !s.order.acquire(ctx, s.sequence) {
+		return false
+	}
+
+	select {
+	case s.channel <- RideBatch{Sequence: s.sequence, Source: s.rideSource, RideID: s.rideID, Coordinates: s.coordinates, End: s.rideEnd}:
+	case <-ctx.Done():
+		return false
+	}
+
+	s.progress.addRidesRead(1)
+	s.sequence++
+	s.sent++
+
+	return true
+}
+
+// openDataset opens a dataset file and reads its header row
+func openDataset(filePaths []string, index int, compression string, loader RideLoader, progress *Progress) (*datasetReader, error) {
+	file, err := progress.openFile(filePaths[index], compression)
+
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open file %s: %s", filePaths[index], err.Error())
+	}
+
+	counter := &countingReader{Reader: file}
+	reader := bufio.NewReader(counter)
+
+	// Resolve the dataset columns from the header row
+	if parser, ok := loader.(HeaderParser); ok {
+		if err := parser.ParseHeader(reader); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("Invalid file %s: %s", filePaths[index], err.Error())
+		}
+	}
+
+	return &datasetReader{
+		file:     file,
+		reader:   reader,
+		position: Position{File: index, Offset: counter.count - int64(reader.Buffered())},
+	}, nil
+}
+
+// seek moves the reader forward to the offset. Plain files are seeked
+// and the decompressed data of compressed files is skipped
+func (d *datasetReader) seek(offset int64) error {
+	if offset <= d.position.Offset {
+		return nil
+	}
+
+	if seeker, ok := d.file.(io.Seeker); ok {
+		if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+
+		d.reader.Reset(d.file)
+		d.position.Offset = offset
+
+		return nil
+	}
+
+	for d.position.Offset < offset {
+		n, err := d.reader.Discard(int(minInt64(offset-d.position.Offset, 1<<20)))
+		d.position.Offset += int64(n)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// minInt64 gets the smaller of two values
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+// ProcessData gets the rides from the input channel and send the ride id and the
+// fare estimate to output channel. The results are sent in the dataset order if
+// output.ordered or the fare outlier signal of the anomaly detector is enabled,
+// otherwise in the order they are calculated. The workers stop once ctx is done
+func ProcessData(ctx context.Context, inputChannel <-chan RideBatch, processor *RideProcessor) <-chan RideResult {
+	return processData(ctx, inputChannel, processor, NewWorkerPoolFromConfig(), nil, nil)
+}
+
+// processData calculates the rides on the worker pool like ProcessData. The rides of
+// a sharded dataset take the tokens of the order while being read so the order is set
+// by the reader, otherwise the rides take a token here if the results are ordered.
+// The depth of the results buffer is reported as the results queue if metrics is set
+func processData(ctx context.Context, inputChannel <-chan RideBatch, processor *RideProcessor, pool *WorkerPool, order *rideOrder, metrics *Metrics) <-chan RideResult {
+	outChannel := make(chan RideResult, viper.GetInt("workers.buffers.results"))
+	metrics.watchQueue("results", func() int { return len(outChannel) })
+
+	if order == nil && processor.orderedResults() {
+		order = newRideOrder(1, reorderBufferSize(pool, 1))
+		inputChannel = acquireTokens(ctx, inputChannel, order)
+	}
+
+	// The anomaly detector scores the rides once they are sorted
+	sortedChannel := outChannel
+
+	if processor.Detector != nil {
+		sortedChannel = make(chan RideResult)
+
+		go func() {
+			processor.Detector.analyzeResults(ctx, sortedChannel, outChannel)
+			close(outChannel)
+		}()
+	}
+
+	workersChannel := make(chan RideResult)
 
-			lineItems := strings.Split(line, ",")
+	go func() {
+		pool.Run(ctx, func() bool {
+			batch, ok := <-inputChannel
 
-			currentRideID := lineItems[0]
+			if !ok {
+				return false
+			}
 
-			if previousRideID == "" {
-				previousRideID = lineItems[0]
+			// The rides left in the input channel are skipped once ctx is done
+			if ctx.Err() != nil {
+				return true
 			}
 
-			if currentRideID == previousRideID {
-				rideInfo = fmt.Sprintf("%s\n%s", rideInfo, line)
-				continue
+			select {
+			case workersChannel <- processBatch(batch, processor):
+			case <-ctx.Done():
 			}
 
-			previousRideID = ""
-			rideBatch = strings.TrimSpace(rideInfo)
-			rideInfo = strings.TrimSpace(line)
+			return true
+		})
+
+		close(workersChannel)
+	}()
 
-			channel <- rideBatch
+	go func() {
+		if order == nil {
+			for result := range workersChannel {
+				select {
+				case sortedChannel <- result:
+				case <-ctx.Done():
+				}
+			}
+		} else {
+			reorderResults(ctx, workersChannel, sortedChannel, order)
 		}
 
-		close(channel)
+		close(sortedChannel)
 	}()
 
-	return channel, nil
+	return outChannel
 }
 
-// ProcessData gets a ride data as string from input channel and send the ride id and the
-// fare estimate to output channel
-func ProcessData(inputChannel <-chan string) <-chan string {
-	outChannel := make(chan string)
+// reorderBufferSize gets the number of tokens of each shard. Every ride takes a token
+// until its result is sent in order so at most output.reorder_buffer results wait in the
+// reorder buffer for a slow ride. Each shard gets at least a token per worker
+func reorderBufferSize(pool *WorkerPool, shards int) int {
+	size := viper.GetInt("output.reorder_buffer") / shards
 
-	go func() {
-		wg := &sync.WaitGroup{}
+	if size < pool.MaxWorkers() {
+		size = pool.MaxWorkers()
+	}
 
-		// Limit the number of goroutines
-		for t := 0; t < viper.GetInt("app.max_goroutines"); t++ {
-			wg.Add(1)
-			go ProcessRide(inputChannel, outChannel, wg)
-		}
+	return size
+}
 
-		wg.Wait()
+// acquireTokens takes a token for each ride before sending it to the workers
+func acquireTokens(ctx context.Context, inputChannel <-chan RideBatch, order *rideOrder) <-chan RideBatch {
+	channel := make(chan RideBatch)
+
+	go func() {
+		defer close(channel)
 
-		close(outChannel)
+		for batch := range inputChannel {
+			if !order.acquire(ctx, batch.Sequence) {
+				return
+			}
+
+			select {
+			case channel <- batch:
+			case <-ctx.Done():
+				return
+			}
+		}
 	}()
 
-	return outChannel
+	return channel
 }
 
-// ProcessRide calculates the ride fare
-func ProcessRide(inputChannel <-chan string, outChannel chan<- string, wg *sync.WaitGroup) {
-	for lines := range inputChannel {
-		ride := model.NewRide()
-		loader := CSVLoader{}
+// reorderResults sends the results by ride sequence and releases the ride token
+// once its result is sent. Results ahead of the next sequence wait in a buffer
+func reorderResults(ctx context.Context, inputChannel <-chan RideResult, outChannel chan<- RideResult, order *rideOrder) {
+	pending := make(map[int]RideResult)
+	next := order.skip(0)
+
+	for inputChannel != nil {
+		select {
+		case result, ok := <-inputChannel:
+			if !ok {
+				inputChannel = nil
+				continue
+			}
 
-		// Load CSV data into the ride object
-		loader.Load(ride, lines)
+			pending[result.Sequence] = result
+		case <-order.changed:
+			// A shard is read, the next sequence may be in the next shard
+			next = order.skip(next)
+		case <-ctx.Done():
+			return
+		}
 
-		// Remove invalid coordinates
-		ride.NormalizeCoordinates()
+		for {
+			result, ok := pending[next]
 
-		// Calculate The fare
-		fare, err := CalculateRideFare(ride)
+			if !ok {
+				break
+			}
 
-		if err != nil {
-			log.Debug(fmt.Sprintf(
-				"Error while calculating ride %d fare: %s",
-				ride.GetID(),
-				err.Error(),
-			))
+			select {
+			case outChannel <- result:
+			case <-ctx.Done():
+				return
+			}
+
+			delete(pending, next)
+			order.release(next)
+			next = order.skip(next + 1)
+		}
+	}
+
+	// Results after a gap in the sequences are sent at the end. Rides from
+	// GenerateData have no gaps as every ride gets a result
+	if len(pending) > 0 {
+		sequences := make([]int, 0, len(pending))
+
+		for sequence := range pending {
+			sequences = append(sequences, sequence)
 		}
 
-		ride.SetFare(fare)
+		sort.Ints(sequences)
 
-		outChannel <- fmt.Sprintf("%d,%.2f", ride.ID, fare)
+		for _, sequence := range sequences {
+			select {
+			case outChannel <- pending[sequence]:
+			case <-ctx.Done():
+				return
+			}
+		}
 	}
+}
 
-	wg.Done()
+// shardSequenceBits is the number of low bits of a ride sequence that count the rides
+// of its shard, the high bits hold the shard index so the sequences follow the dataset
+// order. It is 40 bits with 64 bits int and 20 bits with 32 bits int
+const shardSequenceBits = 20 + 20*(^uint(0)>>63)
+
+// rideOrder struct type
+// The dataset order of the rides for ordered output. Every ride takes a token of its
+// shard until its result is sent in order, each shard has its own tokens so the rides
+// of the shards read ahead can't take the tokens of the ride the order waits for. The
+// rides count of a shard is set once it is read so the order moves to the next shard
+type rideOrder struct {
+	tokens  []chan struct{}
+	counts  []int
+	mutex   sync.Mutex
+	changed chan struct{}
 }
 
-// StoreData store ride id and fare into a file. it gets the values from input channel
-func StoreData(filePath string, channel <-chan string) error {
-	if util.FileExists(filePath) {
-		if err := util.DeleteFile(filePath); err != nil {
-			return fmt.Errorf("Error! Unable to delete file %s", filePath)
+// newRideOrder creates a new instance of rideOrder with size tokens per shard
+func newRideOrder(shards, size int) *rideOrder {
+	order := &rideOrder{
+		tokens:  make([]chan struct{}, shards),
+		counts:  make([]int, shards),
+		changed: make(chan struct{}, 1),
+	}
+
+	for shard := range order.tokens {
+		order.tokens[shard] = make(chan struct{}, size)
+		order.counts[shard] = -1
+	}
+
+	return order
+}
+
+// acquire takes a token of the ride shard, it returns false once ctx is done
+func (o *rideOrder) acquire(ctx context.Context, sequence int) bool {
+	select {
+	case o.tokens[sequence>>shardSequenceBits] <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// release releases a token of the ride shard
+func (o *rideOrder) release(sequence int) {
+	<-o.tokens[sequence>>shardSequenceBits]
+}
+
+// finish sets the rides count of a read shard
+func (o *rideOrder) finish(shard, count int) {
+	o.mutex.Lock()
+	o.counts[shard] = count
+	o.mutex.Unlock()
+
+	select {
+	case o.changed <- struct{}{}:
+	default:
+	}
+}
+
+// skip moves a sequence after the last ride of its shard to the first ride of the next shard
+func (o *rideOrder) skip(sequence int) int {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	for {
+		shard := sequence >> shardSequenceBits
+
+		if shard >= len(o.counts)-1 || o.counts[shard] < 0 || sequence-shard<<shardSequenceBits < o.counts[shard] {
+			return sequence
 		}
+
+		sequence = (shard + 1) << shardSequenceBits
+	}
+}
+
+// processBatch calculates the fare of a ride batch
+func processBatch(batch RideBatch, processor *RideProcessor) RideResult {
+	start := time.Now()
+	ride := model.NewRide()
+	ride.SetID(batch.RideID)
+	ride.SetCoordinates(batch.Coordinates)
+
+	points := len(ride.GetCoordinates())
+
+	// Calculate The fare
+	fare, err := processor.Process(ride)
+
+	if err != nil {
+		log.WithFields(log.Fields{
+			"ride_id": ride.GetID(),
+			"stage":   StageProcess,
+			"reason":  err.Error(),
+		}).Debug("Error while calculating ride fare")
 	}
 
-	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	result := RideResult{
+		Sequence:       batch.Sequence,
+		Source:         batch.Source,
+		End:            batch.End,
+		RideID:         ride.GetID(),
+		Fare:           fare,
+		Metrics:        ride.GetMetrics(),
+		Segments:       ride.GetSegments(),
+		PointsKept:     len(ride.GetCoordinates()),
+		PointsRemoved:  points - len(ride.GetCoordinates()),
+		PointsRejected: ride.GetRejected(),
+		RejectedBy:     ride.GetRejectedReasons(),
+		Duration:       time.Since(start),
+	}
+
+	if processor.Detector != nil {
+		result.ride = ride
+	}
+
+	return result
+}
+
+// StoreData store ride id and fare into a file. it gets the values from input channel
+// The results are written in the output format (csv, json, jsonl, parquet, sqlite or auto to
+// detect it from the file extension) and compressed if a compression is set. The file
+// path - writes to the standard output. The file is replaced once all values are
+// written and a manifest is written next to it if output.manifest is enabled. The
+// results are discarded if ctx is done before the channel is closed
+func StoreData(ctx context.Context, filePath, format, compression string, channel <-chan RideResult) error {
+	writer, err := createResultWriter(filePath, format, compression)
 
 	if err != nil {
+		return err
+	}
+
+	rows := 0
+
+	for {
+		result, ok, err := receiveResult(ctx, channel)
+
+		if err != nil {
+			writer.Abort()
+			return err
+		}
+
+		if !ok {
+			break
+		}
+
+		if err := writer.Write(result); err != nil {
+			writer.Abort()
+
+			return fmt.Errorf(
+				"Error! Unable to write to file %s: %s",
+				filePath,
+				err.Error(),
+			)
+		}
+
+		rows++
+	}
+
+	// Flush the data and replace the file
+	if err := writer.Close(); err != nil {
 		return fmt.Errorf(
 			"Error! Unable to write to file %s: %s",
 			filePath,
@@ -155,16 +755,169 @@ func StoreData(filePath string, channel <-chan string) error {
 		)
 	}
 
-	defer file.Close()
+	return storeManifest(filePath, rows)
+}
+
+// StoreDataPerInput store ride id and fare into one file per dataset file. The output
+// file of each ride is picked by the index of the dataset file where the ride starts
+func StoreDataPerInput(ctx context.Context, filePaths []string, format, compression string, channel <-chan RideResult) error {
+	writers := make([]ResultWriter, len(filePaths))
+	rows := make([]int, len(filePaths))
+
+	// Discard the written files on failure
+	abortWriters := func() {
+		for _, writer := range writers {
+			if writer != nil {
+				writer.Abort()
+			}
+		}
+	}
+
+	for i, filePath := range filePaths {
+		writer, err := createResultWriter(filePath, format, compression)
+
+		if err != nil {
+			abortWriters()
+			return err
+		}
+
+		writers[i] = writer
+	}
+
+	for {
+		result, ok, err := receiveResult(ctx, channel)
+
+		if err != nil {
+			abortWriters()
+			return err
+		}
+
+		if !ok {
+			break
+		}
+
+		if result.Source < 0 || result.Source >= len(writers) {
+			abortWriters()
+			return fmt.Errorf("Error! Invalid dataset file index %d", result.Source)
+		}
+
+		if err := writers[result.Source].Write(result); err != nil {
+			abortWriters()
+
+			return fmt.Errorf(
+				"Error! Unable to write to file %s: %s",
+				filePaths[result.Source],
+				err.Error(),
+			)
+		}
+
+		rows[result.Source]++
+	}
+
+	for i, writer := range writers {
+		writers[i] = nil
+
+		// Flush the data and replace the file
+		if err := writer.Close(); err != nil {
+			abortWriters()
 
-	for line := range channel {
-		if _, err := file.WriteString(fmt.Sprintf("%s\n", line)); err != nil {
 			return fmt.Errorf(
 				"Error! Unable to write to file %s: %s",
+				filePaths[i],
+				err.Error(),
+			)
+		}
+	}
+
+	for i, filePath := range filePaths {
+		if err := storeManifest(filePath, rows[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// receiveResult gets the next result from the channel, ok is false once the channel
+// is closed. The ctx error is returned if ctx is done since the channel is then
+// closed by the canceled stages too
+func receiveResult(ctx context.Context, channel <-chan RideResult) (RideResult, bool, error) {
+	select {
+	case result, ok := <-channel:
+		if !ok {
+			return result, ok, ctx.Err()
+		}
+
+		return result, ok, nil
+	case <-ctx.Done():
+		return RideResult{}, false, ctx.Err()
+	}
+}
+
+// createResultWriter creates the output file and its result writer
+func createResultWriter(filePath, format, compression string) (ResultWriter, error) {
+	format, err := ResolveOutputFormat(filePath, format)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite writes to the database file directly
+	if format == OutputSQLite {
+		if compression, err = ResolveOutputCompression(filePath, compression); err != nil || compression != CompressionNone {
+			return nil, fmt.Errorf("Error! SQLite database %s can't be compressed", filePath)
+		}
+
+		writer, err := NewSQLiteWriter(filePath)
+
+		if err != nil {
+			return nil, fmt.Errorf(
+				"Error! Unable to write to database %s: %s",
 				filePath,
 				err.Error(),
 			)
 		}
+
+		return writer, nil
+	}
+
+	file, err := CreateCompressedFile(filePath, compression)
+
+	if err != nil {
+		return nil, fmt.Errorf(
+			"Error! Unable to write to file %s: %s",
+			filePath,
+			err.Error(),
+		)
+	}
+
+	writer, err := NewResultWriter(format, file)
+
+	if err != nil {
+		AbortWriter(file)
+
+		return nil, fmt.Errorf(
+			"Error! Unable to write to file %s: %s",
+			filePath,
+			err.Error(),
+		)
+	}
+
+	return writer, nil
+}
+
+// storeManifest writes the output file manifest if output.manifest is enabled
+func storeManifest(filePath string, rows int) error {
+	if !viper.GetBool("output.manifest") || filePath == StdStream {
+		return nil
+	}
+
+	if err := WriteManifest(filePath, rows); err != nil {
+		return fmt.Errorf(
+			"Error! Unable to write manifest of file %s: %s",
+			filePath,
+			err.Error(),
+		)
 	}
 
 	return nil
@@ -5,14 +5,19 @@
 package module
 
 import (
+	"context"
 	"fmt"
+	"io/ioutil"
 	"strings"
 	"testing"
+	"time"
 
+	"bitbucket.org/clivern/beat/core/model"
 	"bitbucket.org/clivern/beat/core/util"
 	"bitbucket.org/clivern/beat/pkg"
 
 	"github.com/franela/goblin"
+	"github.com/spf13/viper"
 )
 
 // TestGenerateData test cases
@@ -26,18 +31,18 @@ func TestGenerateData(t *testing.T) {
 
 	g.Describe("GenerateData", func() {
 		g.It("It should fail since file is missing", func() {
-			_, err := GenerateData(fmt.Sprintf("%s/not_found.csv", testDataDir))
+			_, err := GenerateData(context.Background(), fmt.Sprintf("%s/not_found.csv", testDataDir), CompressionAuto, CSVLoader{})
 			g.Assert(err != nil).Equal(true)
 		})
 
 		g.It("It should satisfy all provided test cases", func() {
-			channel, err := GenerateData(fmt.Sprintf("%s/test_paths_01.csv", testDataDir))
+			channel, err := GenerateData(context.Background(), fmt.Sprintf("%s/test_paths_01.csv", testDataDir), CompressionAuto, CSVLoader{})
 			g.Assert(err).Equal(nil)
 
 			var output []string
 
 			for elem := range channel {
-				output = append(output, elem)
+				output = append(output, formatBatch(elem))
 			}
 
 			// Validate the data sent to the channel & it equals the data that was on the file (cache & testdata)
@@ -53,6 +58,90 @@ func TestGenerateData(t *testing.T) {
 			g.Assert(output[8]).Equal("9,37.944253,23.758287,1405591394\n9,37.944253,23.758287,1405591404\n9,37.944122,23.758543,1405591414\n9,37.944028,23.758845,1405591424\n9,37.943680,23.759372,1405591434\n9,37.943667,23.759413,1405591444\n9,37.943883,23.758887,1405591455\n9,37.944130,23.758447,1405591464\n9,37.944563,23.758408,1405591474")
 			g.Assert(output[9]).Equal("10,37.945335,23.758682,1405591484\n10,37.946275,23.759078,1405591494\n10,37.946490,23.758197,1405591504\n10,37.946472,23.757032,1405591514\n10,37.946410,23.756332,1405591525\n10,37.946610,23.755890,1405591534\n10,37.946832,23.755435,1405591553\n10,37.946408,23.754733,1405591554\n10,37.946613,23.753868,1405591566\n10,37.947072,23.752240,1405591577")
 		})
+
+		g.It("It should join rides spanning many dataset files", func() {
+			channel, err := GenerateDatasets(context.Background(), []string{
+				fmt.Sprintf("%s/test_paths_05_01.csv", testDataDir),
+				fmt.Sprintf("%s/test_paths_05_02.csv", testDataDir),
+			}, CompressionAuto, CSVLoader{})
+			g.Assert(err).Equal(nil)
+
+			var output []RideBatch
+
+			for elem := range channel {
+				output = append(output, elem)
+			}
+
+			g.Assert(len(output)).Equal(10)
+			g.Assert(output[1].Source).Equal(0)
+			g.Assert(output[2]).Equal(RideBatch{
+				Sequence: 2,
+				Source:   0,
+				RideID:   3,
+				Coordinates: []model.Coordinate{
+					{Latitude: 37.946545, Longitude: 23.754918, Timestamp: time.Unix(1405591084, 0)},
+					{Latitude: 37.946413, Longitude: 23.754767, Timestamp: time.Unix(1405591094, 0)},
+					{Latitude: 37.946260, Longitude: 23.754830, Timestamp: time.Unix(1405591103, 0)},
+				},
+				End: Position{File: 1, Offset: 33},
+			})
+			g.Assert(output[3].Source).Equal(1)
+			g.Assert(output[9].Source).Equal(1)
+		})
+
+		g.It("It should fail if a dataset file is missing", func() {
+			_, err := GenerateDatasets(context.Background(), []string{
+				fmt.Sprintf("%s/test_paths_05_01.csv", testDataDir),
+				fmt.Sprintf("%s/not_found.csv", testDataDir),
+			}, CompressionAuto, CSVLoader{})
+			g.Assert(err != nil).Equal(true)
+
+			_, err = GenerateDatasets(context.Background(), []string{StdStream, StdStream}, CompressionAuto, CSVLoader{})
+			g.Assert(err != nil).Equal(true)
+		})
+
+		g.It("It should read CSV datasets with a header, quoted fields and mapped columns", func() {
+			viper.Set("input.delimiter", ";")
+			viper.Set("input.columns", map[string]interface{}{
+				"id":        "ride",
+				"latitude":  "lat",
+				"longitude": "lng",
+				"timestamp": "time",
+			})
+
+			loader, err := NewCSVLoader()
+			g.Assert(err).Equal(nil)
+
+			channel, err := GenerateData(context.Background(), fmt.Sprintf("%s/test_paths_04.csv", testDataDir), CompressionAuto, loader)
+			g.Assert(err).Equal(nil)
+
+			var output []string
+
+			for elem := range channel {
+				output = append(output, formatBatch(elem))
+			}
+
+			g.Assert(len(output)).Equal(4)
+			g.Assert(output[0]).Equal("1,37.966660,23.728308,1405594957")
+			g.Assert(output[1]).Equal("2,37.946545,23.754918,1405591065")
+			g.Assert(output[2]).Equal("3,37.946545,23.754918,1405591084\n3,37.946413,23.754767,1405591094\n3,37.946260,23.754830,1405591103")
+			g.Assert(output[3]).Equal("4,37.946032,23.755347,1405591112")
+
+			viper.Set("input.delimiter", ",")
+			viper.Set("input.columns", map[string]interface{}{
+				"id":        0,
+				"latitude":  1,
+				"longitude": 2,
+				"timestamp": 3,
+			})
+		})
+
+		g.It("It should fail if a mapped column is missing in the header", func() {
+			loader := &CSVLoader{Columns: map[string]string{"latitude": "latitude"}}
+
+			_, err := GenerateData(context.Background(), fmt.Sprintf("%s/test_paths_01.csv", testDataDir), CompressionAuto, loader)
+			g.Assert(err != nil).Equal(true)
+		})
 	})
 }
 
@@ -68,39 +157,71 @@ func TestStoreData(t *testing.T) {
 
 	g.Describe("StoreData", func() {
 		g.It("It should fail since file is missing", func() {
-			_, err := GenerateData(fmt.Sprintf("%s/not_found.csv", testDataDir))
+			_, err := GenerateData(context.Background(), fmt.Sprintf("%s/not_found.csv", testDataDir), CompressionAuto, CSVLoader{})
 			g.Assert(err != nil).Equal(true)
 		})
 
 		g.It("It should satisfy all provided test cases", func() {
-			channel, err := GenerateData(fmt.Sprintf("%s/test_paths_01.csv", testDataDir))
+			err := StoreData(context.Background(), fmt.Sprintf("%s/store_data_test01.csv", cacheDir), OutputCSV, CompressionAuto, sendResults([]RideResult{
+				{RideID: 1, Fare: 3.47},
+				{RideID: 2, Fare: 58.304},
+				{RideID: 3, Fare: 3.476},
+			}))
 			g.Assert(err).Equal(nil)
 
-			err = StoreData(fmt.Sprintf("%s/store_data_test01.csv", cacheDir), channel)
+			fileContent, err := util.ReadFile(fmt.Sprintf("%s/store_data_test01.csv", cacheDir))
 			g.Assert(err).Equal(nil)
+			g.Assert(fileContent).Equal("1,3.47\n2,58.30\n3,3.48\n")
+		})
 
-			// Validate written data using generate method
-			channel, err = GenerateData(fmt.Sprintf("%s/store_data_test01.csv", cacheDir))
-			g.Assert(err).Equal(nil)
+		g.It("It should fail for an invalid output format", func() {
+			err := StoreData(context.Background(), fmt.Sprintf("%s/store_data_test02.csv", cacheDir), "xml", CompressionAuto, sendResults([]RideResult{}))
+			g.Assert(err != nil).Equal(true)
+		})
+	})
+}
 
-			var output []string
+// TestStoreDataPerInput test cases
+func TestStoreDataPerInput(t *testing.T) {
+	// Load Configs
+	baseDir := pkg.GetBaseDir("cache")
+	testDataDir := fmt.Sprintf("%s/%s", baseDir, "testdata")
+	cacheDir := fmt.Sprintf("%s/%s", baseDir, "cache")
+	pkg.LoadConfigs(fmt.Sprintf("%s/config.dist.yml", baseDir))
 
-			for elem := range channel {
-				output = append(output, elem)
+	g := goblin.Goblin(t)
+
+	g.Describe("StoreDataPerInput", func() {
+		g.It("It should store the rides of each dataset file into its output file", func() {
+			channel, err := GenerateDatasets(context.Background(), []string{
+				fmt.Sprintf("%s/test_paths_05_01.csv", testDataDir),
+				fmt.Sprintf("%s/test_paths_05_02.csv", testDataDir),
+			}, CompressionAuto, CSVLoader{})
+			g.Assert(err).Equal(nil)
+
+			outputPaths := []string{
+				fmt.Sprintf("%s/store_data_per_input_test01.csv", cacheDir),
+				fmt.Sprintf("%s/store_data_per_input_test02.csv.gz", cacheDir),
 			}
 
-			// Validate the data sent to the channel & it equals the data that was on the file (cache & testdata)
-			g.Assert(len(output)).Equal(10)
-			g.Assert(output[0]).Equal("1,37.966660,23.728308,1405594957")
-			g.Assert(output[1]).Equal("2,37.946545,23.754918,1405591065\n2,37.946545,23.754918,1405591073")
-			g.Assert(output[2]).Equal("3,37.946545,23.754918,1405591084\n3,37.946413,23.754767,1405591094\n3,37.946260,23.754830,1405591103")
-			g.Assert(output[3]).Equal("4,37.946032,23.755347,1405591112\n4,37.946190,23.755707,1405591121\n4,37.946298,23.756495,1405591132\n4,37.946398,23.758092,1405591142")
-			g.Assert(output[4]).Equal("5,37.946417,23.759267,1405591151\n5,37.945638,23.758867,1405591160\n5,37.945638,23.758867,1405591161\n5,37.945310,23.758720,1405591173\n5,37.945045,23.758625,1405591181")
-			g.Assert(output[5]).Equal("6,37.944860,23.758528,1405591191\n6,37.944530,23.758438,1405591201\n6,37.944370,23.758412,1405591211\n6,37.944365,23.758407,1405591222\n6,37.944365,23.758407,1405591232\n6,37.944365,23.758407,1405591233")
-			g.Assert(output[6]).Equal("7,37.944365,23.758407,1405591243\n7,37.944365,23.758407,1405591253\n7,37.944440,23.758473,1405591263\n7,37.944440,23.758473,1405591273\n7,37.944440,23.758473,1405591283\n7,37.944440,23.758473,1405591293\n7,37.944440,23.758473,1405591303")
-			g.Assert(output[7]).Equal("8,37.944440,23.758473,1405591313\n8,37.944360,23.758402,1405591323\n8,37.944360,23.758402,1405591334\n8,37.944360,23.758402,1405591343\n8,37.944360,23.758402,1405591354\n8,37.944360,23.758402,1405591363\n8,37.944253,23.758287,1405591373\n8,37.944253,23.758287,1405591383")
-			g.Assert(output[8]).Equal("9,37.944253,23.758287,1405591394\n9,37.944253,23.758287,1405591404\n9,37.944122,23.758543,1405591414\n9,37.944028,23.758845,1405591424\n9,37.943680,23.759372,1405591434\n9,37.943667,23.759413,1405591444\n9,37.943883,23.758887,1405591455\n9,37.944130,23.758447,1405591464\n9,37.944563,23.758408,1405591474")
-			g.Assert(output[9]).Equal("10,37.945335,23.758682,1405591484\n10,37.946275,23.759078,1405591494\n10,37.946490,23.758197,1405591504\n10,37.946472,23.757032,1405591514\n10,37.946410,23.756332,1405591525\n10,37.946610,23.755890,1405591534\n10,37.946832,23.755435,1405591553\n10,37.946408,23.754733,1405591554\n10,37.946613,23.753868,1405591566\n10,37.947072,23.752240,1405591577")
+			outChannel := ProcessData(context.Background(), channel, &RideProcessor{})
+
+			err = StoreDataPerInput(context.Background(), outputPaths, OutputCSV, CompressionAuto, outChannel)
+			g.Assert(err).Equal(nil)
+
+			fileContent, err := util.ReadFile(outputPaths[0])
+			g.Assert(err).Equal(nil)
+			g.Assert(strings.Count(fileContent, "\n")).Equal(3)
+			g.Assert(strings.Contains(fileContent, "3,3.47")).Equal(true)
+
+			file, err := OpenCompressedFile(outputPaths[1], CompressionAuto)
+			g.Assert(err).Equal(nil)
+
+			data, err := ioutil.ReadAll(file)
+			g.Assert(err).Equal(nil)
+			g.Assert(file.Close()).Equal(nil)
+			g.Assert(strings.Count(string(data), "\n")).Equal(7)
+			g.Assert(strings.Contains(string(data), "10,3.47")).Equal(true)
 		})
 	})
 }
@@ -117,12 +238,12 @@ func TestProcessData(t *testing.T) {
 
 	g.Describe("ProcessData", func() {
 		g.It("It should satisfy all provided test cases", func() {
-			channel, err := GenerateData(fmt.Sprintf("%s/test_paths_01.csv", testDataDir))
+			channel, err := GenerateData(context.Background(), fmt.Sprintf("%s/test_paths_01.csv", testDataDir), CompressionAuto, CSVLoader{})
 			g.Assert(err).Equal(nil)
 
-			outChannel := ProcessData(channel)
+			outChannel := ProcessData(context.Background(), channel, &RideProcessor{})
 
-			err = StoreData(fmt.Sprintf("%s/process_data_test01.csv", cacheDir), outChannel)
+			err = StoreData(context.Background(), fmt.Sprintf("%s/process_data_test01.csv", cacheDir), OutputCSV, CompressionAuto, outChannel)
 			g.Assert(err).Equal(nil)
 
 			fileContent, err := util.ReadFile(fmt.Sprintf("%s/process_data_test01.csv", cacheDir))
@@ -141,12 +262,12 @@ func TestProcessData(t *testing.T) {
 		})
 
 		g.It("It should satisfy all provided test cases", func() {
-			channel, err := GenerateData(fmt.Sprintf("%s/test_paths_02.csv", testDataDir))
+			channel, err := GenerateData(context.Background(), fmt.Sprintf("%s/test_paths_02.csv", testDataDir), CompressionAuto, CSVLoader{})
 			g.Assert(err).Equal(nil)
 
-			outChannel := ProcessData(channel)
+			outChannel := ProcessData(context.Background(), channel, &RideProcessor{})
 
-			err = StoreData(fmt.Sprintf("%s/process_data_test02.csv", cacheDir), outChannel)
+			err = StoreData(context.Background(), fmt.Sprintf("%s/process_data_test02.csv", cacheDir), OutputCSV, CompressionAuto, outChannel)
 			g.Assert(err).Equal(nil)
 
 			fileContent, err := util.ReadFile(fmt.Sprintf("%s/process_data_test02.csv", cacheDir))
@@ -154,5 +275,131 @@ func TestProcessData(t *testing.T) {
 
 			g.Assert(strings.Contains(fileContent, "2,58.30")).Equal(true)
 		})
+
+		g.It("It should send the results in the dataset order", func() {
+			viper.Set("output.ordered", true)
+			viper.Set("output.reorder_buffer", 4)
+			viper.Set("workers.size", 4)
+
+			for run := 0; run < 5; run++ {
+				channel, err := GenerateData(context.Background(), fmt.Sprintf("%s/test_paths_01.csv", testDataDir), CompressionAuto, CSVLoader{})
+				g.Assert(err).Equal(nil)
+
+				var output []string
+
+				for result := range ProcessData(context.Background(), channel, &RideProcessor{}) {
+					g.Assert(result.Sequence).Equal(len(output))
+					output = append(output, fmt.Sprintf("%d,%.2f", result.RideID, result.Fare))
+				}
+
+				g.Assert(output).Equal([]string{"1,3.47", "2,3.47", "3,3.47", "4,3.47", "5,3.47", "6,3.47", "7,3.47", "8,3.47", "9,3.47", "10,3.47"})
+			}
+
+			viper.Set("output.ordered", false)
+			viper.Set("workers.size", 0)
+		})
+
+		g.It("It should reorder the results by sequence", func() {
+			inputChannel := make(chan RideResult)
+			outChannel := make(chan RideResult)
+			order := newRideOrder(1, 5)
+
+			go func() {
+				for _, sequence := range []int{3, 1, 0, 4, 2} {
+					order.acquire(context.Background(), sequence)
+					inputChannel <- RideResult{Sequence: sequence}
+				}
+
+				close(inputChannel)
+			}()
+
+			go func() {
+				reorderResults(context.Background(), inputChannel, outChannel, order)
+				close(outChannel)
+			}()
+
+			var sequences []int
+
+			for result := range outChannel {
+				sequences = append(sequences, result.Sequence)
+			}
+
+			g.Assert(sequences).Equal([]int{0, 1, 2, 3, 4})
+			g.Assert(len(order.tokens[0])).Equal(0)
+		})
+
+		g.It("It should process JSON Lines datasets", func() {
+			channel, err := GenerateData(context.Background(), fmt.Sprintf("%s/test_paths_03.jsonl", testDataDir), CompressionAuto, NewJSONLoader())
+			g.Assert(err).Equal(nil)
+
+			outChannel := ProcessData(context.Background(), channel, &RideProcessor{})
+
+			err = StoreData(context.Background(), fmt.Sprintf("%s/process_data_test03.csv", cacheDir), OutputCSV, CompressionAuto, outChannel)
+			g.Assert(err).Equal(nil)
+
+			fileContent, err := util.ReadFile(fmt.Sprintf("%s/process_data_test03.csv", cacheDir))
+			g.Assert(err).Equal(nil)
+
+			g.Assert(strings.Contains(fileContent, "2,58.30")).Equal(true)
+			g.Assert(strings.Contains(fileContent, "3,3.47")).Equal(true)
+		})
 	})
 }
+
+// sendResults sends the results to a channel
+func sendResults(results []RideResult) <-chan RideResult {
+	channel := make(chan RideResult)
+
+	go func() {
+		for _, result := range results {
+			channel <- result
+		}
+
+		close(channel)
+	}()
+
+	return channel
+}
+
+// formatBatch formats the coordinates of a ride batch as the CSV lines of the ride
+func formatBatch(batch RideBatch) string {
+	lines := make([]string, 0, len(batch.Coordinates))
+
+	for _, coordinate := range batch.Coordinates {
+		lines = append(lines, fmt.Sprintf(
+			"%d,%.6f,%.6f,%d",
+			batch.RideID,
+			coordinate.Latitude,
+			coordinate.Longitude,
+			coordinate.Timestamp.Unix(),
+		))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// BenchmarkGenerateData benchmark the rows per second read and parsed from the synthetic dataset
+func BenchmarkGenerateData(b *testing.B) {
+	baseDir := pkg.GetBaseDir("cache")
+	pkg.LoadConfigs(fmt.Sprintf("%s/config.dist.yml", baseDir))
+
+	rows := benchmarkRows()
+	filePath := syntheticDataset(b, rows)
+
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		start := time.Now()
+
+		channel, err := GenerateData(context.Background(), filePath, CompressionAuto, CSVLoader{})
+
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		for range channel {
+		}
+
+		b.ReportMetric(float64(rows)/time.Since(start).Seconds(), "rows/s")
+	}
+}
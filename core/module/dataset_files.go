@@ -0,0 +1,106 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package module
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ExpandPaths expands the glob patterns into the matching files. The files matching
+// a pattern are sorted by name and duplicates are removed keeping the first one
+func ExpandPaths(patterns []string) ([]string, error) {
+	filePaths := make([]string, 0)
+	seen := make(map[string]bool)
+
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+
+		if pattern == "" {
+			continue
+		}
+
+		matches := []string{pattern}
+
+		if pattern != StdStream && strings.ContainsAny(pattern, "*?[") {
+			var err error
+
+			matches, err = filepath.Glob(pattern)
+
+			if err != nil {
+				return nil, fmt.Errorf("Invalid pattern %s: %s", pattern, err.Error())
+			}
+
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("No file matches pattern %s", pattern)
+			}
+
+			sort.Strings(matches)
+		}
+
+		for _, match := range matches {
+			if seen[match] {
+				continue
+			}
+
+			seen[match] = true
+			filePaths = append(filePaths, match)
+		}
+	}
+
+	if len(filePaths) == 0 {
+		return nil, fmt.Errorf("No dataset file provided")
+	}
+
+	return filePaths, nil
+}
+
+// GetOutputPaths gets the output file of each dataset file from a naming template
+// {dir} is the output file directory, {name} the dataset file name without
+// extensions and {index} the dataset file position starting from 1
+func GetOutputPaths(filePaths []string, outputFile, template string) ([]string, error) {
+	if outputFile == StdStream {
+		return nil, fmt.Errorf("The standard output can't be used with one output file per dataset file")
+	}
+
+	outputPaths := make([]string, len(filePaths))
+	seen := make(map[string]bool)
+	inputs := make(map[string]bool)
+
+	for _, filePath := range filePaths {
+		inputs[filepath.Clean(filePath)] = true
+	}
+
+	for i, filePath := range filePaths {
+		if filePath == StdStream {
+			return nil, fmt.Errorf("The standard input can't be used with one output file per dataset file")
+		}
+
+		name := filepath.Base(TrimCompressionExt(filePath))
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+
+		outputPath := filepath.Clean(strings.NewReplacer(
+			"{dir}", filepath.Dir(outputFile),
+			"{name}", name,
+			"{index}", strconv.Itoa(i+1),
+		).Replace(template))
+
+		if seen[outputPath] {
+			return nil, fmt.Errorf("Output file %s is used for many dataset files, add {index} to the template", outputPath)
+		}
+
+		if inputs[outputPath] {
+			return nil, fmt.Errorf("Output file %s overwrites a dataset file", outputPath)
+		}
+
+		seen[outputPath] = true
+		outputPaths[i] = outputPath
+	}
+
+	return outputPaths, nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package module
+
+import (
+	"fmt"
+	"testing"
+
+	"bitbucket.org/clivern/beat/pkg"
+
+	"github.com/franela/goblin"
+)
+
+// TestDatasetFiles test cases
+func TestDatasetFiles(t *testing.T) {
+	baseDir := pkg.GetBaseDir("cache")
+	testDataDir := fmt.Sprintf("%s/%s", baseDir, "testdata")
+
+	g := goblin.Goblin(t)
+
+	g.Describe("ExpandPaths", func() {
+		g.It("It should expand glob patterns in order", func() {
+			filePaths, err := ExpandPaths([]string{
+				fmt.Sprintf("%s/test_paths_05_*.csv", testDataDir),
+				fmt.Sprintf("%s/test_paths_01.csv", testDataDir),
+				fmt.Sprintf("%s/test_paths_05_01.csv", testDataDir),
+			})
+
+			g.Assert(err).Equal(nil)
+			g.Assert(filePaths).Equal([]string{
+				fmt.Sprintf("%s/test_paths_05_01.csv", testDataDir),
+				fmt.Sprintf("%s/test_paths_05_02.csv", testDataDir),
+				fmt.Sprintf("%s/test_paths_01.csv", testDataDir),
+			})
+		})
+
+		g.It("It should fail if nothing matches", func() {
+			_, err := ExpandPaths([]string{fmt.Sprintf("%s/not_found_*.csv", testDataDir)})
+			g.Assert(err != nil).Equal(true)
+
+			_, err = ExpandPaths([]string{})
+			g.Assert(err != nil).Equal(true)
+		})
+	})
+
+	g.Describe("GetOutputPaths", func() {
+		g.It("It should render the naming template", func() {
+			outputPaths, err := GetOutputPaths(
+				[]string{"/data/rides_01.csv.gz", "/data/rides_02.jsonl"},
+				"/output/fares.csv",
+				"{dir}/{index}_{name}_fares.csv",
+			)
+
+			g.Assert(err).Equal(nil)
+			g.Assert(outputPaths).Equal([]string{"/output/1_rides_01_fares.csv", "/output/2_rides_02_fares.csv"})
+		})
+
+		g.It("It should fail for conflicting output files", func() {
+			_, err := GetOutputPaths([]string{"/a/rides.csv", "/b/rides.csv"}, "/output/fares.csv", "{dir}/{name}.csv")
+			g.Assert(err != nil).Equal(true)
+
+			_, err = GetOutputPaths([]string{"/data/rides.txt"}, "/data/fares.csv", "{dir}/{name}.txt")
+			g.Assert(err != nil).Equal(true)
+
+			_, err = GetOutputPaths([]string{"/data/rides.csv"}, StdStream, "{dir}/{name}_fares.csv")
+			g.Assert(err != nil).Equal(true)
+		})
+	})
+}
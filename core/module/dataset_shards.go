@@ -0,0 +1,255 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package module
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/spf13/viper"
+	"golang.org/x/sync/errgroup"
+)
+
+// datasetShard struct type
+// A byte range of a dataset file that starts with the first line of a ride
+type datasetShard struct {
+	Start int64
+	End   int64
+}
+
+// splitDataset splits a single uncompressed dataset file into input.shards byte ranges
+// read at the same time. The compressed files, the standard input and many dataset
+// files are read sequentially so no shards are returned for them
+func splitDataset(filePaths []string, compression string, loader RideLoader) ([]datasetShard, error) {
+	if len(filePaths) != 1 || filePaths[0] == StdStream {
+		return nil, nil
+	}
+
+	if compression, err := ResolveCompression(filePaths[0], compression); err != nil || compression != CompressionNone {
+		return nil, nil
+	}
+
+	info, err := os.Stat(filePaths[0])
+
+	if err != nil {
+		return nil, nil
+	}
+
+	count := shardCount(info.Size())
+
+	if count < 2 {
+		return nil, nil
+	}
+
+	// The header is read to get the offset of the first ride
+	dataset, err := openDatasets(filePaths, compression, loader, Position{}, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	dataset.file.Close()
+
+	shards, err := planShards(filePaths[0], loader, dataset.position.Offset, count)
+
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read file %s: %s", filePaths[0], err.Error())
+	}
+
+	if len(shards) < 2 {
+		return nil, nil
+	}
+
+	return shards, nil
+}
+
+// shardCount gets the number of shards of a dataset file from input.shards, 0 uses
+// GOMAXPROCS. Every shard has at least input.min_shard_size MB
+func shardCount(size int64) int {
+	count := viper.GetInt("input.shards")
+
+	if count <= 0 {
+		count = runtime.GOMAXPROCS(0)
+	}
+
+	minSize := viper.GetInt64("input.min_shard_size") << 20
+
+	if minSize > 0 && size/minSize < int64(count) {
+		count = int(size / minSize)
+	}
+
+	return count
+}
+
+// planShards splits a dataset file from the start offset into count byte ranges of about
+// the same size. Every range start is moved forward to the first line of a new ride so
+// rides stay whole, the ride running at a range end is read by that range
+func planShards(filePath string, loader RideLoader, start int64, count int) ([]datasetShard, error) {
+	file, err := os.Open(filePath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	info, err := file.Stat()
+
+	if err != nil {
+		return nil, err
+	}
+
+	size := info.Size()
+	shards := []datasetShard{{Start: start}}
+
+	for index := 1; index < count; index++ {
+		offset := start + (size-start)*int64(index)/int64(count)
+
+		// The previous range already covers the offset
+		if offset <= shards[len(shards)-1].Start {
+			continue
+		}
+
+		rideStart, err := findRideStart(file, offset, loader)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if rideStart >= size {
+			break
+		}
+
+		shards = append(shards, datasetShard{Start: rideStart})
+	}
+
+	for index := range shards {
+		if index < len(shards)-1 {
+			shards[index].End = shards[index+1].Start
+		} else {
+			shards[index].End = size
+		}
+	}
+
+	return shards, nil
+}
+
+// findRideStart gets the offset of the first line after offset where a new ride starts
+// The lines of the ride running at offset are skipped and invalid lines never start a
+// ride like in a sequential read. The file size is returned if no ride starts
+func findRideStart(file *os.File, offset int64, loader RideLoader) (int64, error) {
+	if _, err := file.Seek(offset-1, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	reader := bufio.NewReader(file)
+
+	// Skip the rest of the line running at offset
+	line, err := reader.ReadString('\n')
+	position := offset - 1 + int64(len(line))
+
+	if err == io.EOF {
+		return position, nil
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	var rideID int
+	var found bool
+
+	for {
+		line, err := reader.ReadString('\n')
+
+		if id, _, parseErr := loader.ParseLine(strings.TrimSpace(line)); parseErr == nil {
+			if found && id != rideID {
+				return position, nil
+			}
+
+			rideID = id
+			found = true
+		}
+
+		position += int64(len(line))
+
+		if err == io.EOF {
+			return position, nil
+		}
+
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// readShards sends the rides of the dataset file shards to the channel and closes the
+// channel at the end. The shards are read at the same time and the sequences of their
+// rides hold the shard index, the rides take the tokens of the order if set so the
+// results can be sent in the dataset order. Once ctx is done the rides being read are dropped
+func readShards(ctx context.Context, filePath string, loader RideLoader, shards []datasetShard, order *rideOrder, progress *Progress, metrics *Metrics, channel chan<- RideBatch) error {
+	defer close(channel)
+
+	group := new(errgroup.Group)
+
+	for index, shard := range shards {
+		index, shard := index, shard
+
+		group.Go(func() error {
+			return readShard(ctx, filePath, loader, index, shard, order, progress, metrics, channel)
+		})
+	}
+
+	return group.Wait()
+}
+
+// readShard sends the rides of a dataset file shard to the channel and sets the
+// rides count of the shard in the order once done
+func readShard(ctx context.Context, filePath string, loader RideLoader, index int, shard datasetShard, order *rideOrder, progress *Progress, metrics *Metrics, channel chan<- RideBatch) error {
+	scanner := newRideScanner(loader, progress, metrics, channel)
+	scanner.order = order
+	scanner.sequence = index << shardSequenceBits
+
+	if order != nil {
+		defer func() {
+			order.finish(index, scanner.sent)
+		}()
+	}
+
+	file, err := os.Open(filePath)
+
+	if err != nil {
+		return fmt.Errorf("Unable to open file %s: %s", filePath, err.Error())
+	}
+
+	defer file.Close()
+
+	if _, err := file.Seek(shard.Start, io.SeekStart); err != nil {
+		return fmt.Errorf("Unable to read file %s: %s", filePath, err.Error())
+	}
+
+	dataset := &datasetReader{
+		file:     file,
+		reader:   bufio.NewReader(io.LimitReader(&progressFile{File: file, progress: progress}, shard.End-shard.Start)),
+		position: Position{Offset: shard.Start},
+	}
+
+	done, err := scanner.scan(ctx, dataset)
+
+	if err != nil {
+		return fmt.Errorf("Unable to read file %s: %s", filePath, err.Error())
+	}
+
+	if done {
+		scanner.flush(ctx)
+	}
+
+	return nil
+}
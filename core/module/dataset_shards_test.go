@@ -0,0 +1,284 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package module
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"bitbucket.org/clivern/beat/core/util"
+	"bitbucket.org/clivern/beat/pkg"
+
+	"github.com/franela/goblin"
+	"github.com/spf13/viper"
+)
+
+// TestDatasetShards test cases
+func TestDatasetShards(t *testing.T) {
+	baseDir := pkg.GetBaseDir("cache")
+	testDataDir := fmt.Sprintf("%s/%s", baseDir, "testdata")
+	cacheDir := fmt.Sprintf("%s/%s", baseDir, "cache")
+	pkg.LoadConfigs(fmt.Sprintf("%s/config.dist.yml", baseDir))
+
+	g := goblin.Goblin(t)
+
+	// writeDataset writes a CSV dataset with a header, rides of random lengths and invalid lines
+	writeDataset := func(filePath string) {
+		random := rand.New(rand.NewSource(7))
+		lines := []string{"id,lat,lng,ts"}
+		timestamp := 1405594957
+
+		for ride := 1; ride <= 300; ride++ {
+			for point := 0; point < 1+random.Intn(12); point++ {
+				if random.Intn(20) == 0 {
+					lines = append(lines, fmt.Sprintf("%d,invalid,23.728308,%d", ride, timestamp))
+				}
+
+				lines = append(lines, fmt.Sprintf(
+					"%d,%.6f,%.6f,%d",
+					ride,
+					37.966660+random.Float64()*0.01,
+					23.728308+random.Float64()*0.01,
+					timestamp,
+				))
+
+				timestamp += 10
+			}
+		}
+
+		g.Assert(ioutil.WriteFile(filePath, []byte(strings.Join(lines, "\n")+"\n"), 0644)).Equal(nil)
+	}
+
+	// runPipeline runs the pipeline over the dataset and gets the output lines
+	runPipeline := func(datasetFile, outputFile string, stop chan struct{}) []string {
+		loader, err := NewCSVLoader()
+		g.Assert(err).Equal(nil)
+
+		err = (&Pipeline{
+			DatasetFiles: []string{datasetFile},
+			OutputFile:   outputFile,
+			Format:       "auto",
+			Compression:  CompressionAuto,
+			Loader:       loader,
+			Processor:    &RideProcessor{},
+		}).Run(context.Background(), stop)
+		g.Assert(err).Equal(nil)
+
+		content, err := util.ReadFile(outputFile)
+		g.Assert(err).Equal(nil)
+
+		return strings.Split(strings.TrimSpace(content), "\n")
+	}
+
+	g.Describe("DatasetShards", func() {
+		g.It("It should start every shard with a new ride", func() {
+			filePath := fmt.Sprintf("%s/test_paths_01.csv", testDataDir)
+
+			shards, err := planShards(filePath, CSVLoader{}, 0, 4)
+			g.Assert(err).Equal(nil)
+			g.Assert(len(shards) > 1).Equal(true)
+
+			info, err := os.Stat(filePath)
+			g.Assert(err).Equal(nil)
+
+			content, err := util.ReadFile(filePath)
+			g.Assert(err).Equal(nil)
+
+			g.Assert(shards[0].Start).Equal(int64(0))
+			g.Assert(shards[len(shards)-1].End).Equal(info.Size())
+
+			for index, shard := range shards {
+				if index == 0 {
+					continue
+				}
+
+				g.Assert(shard.Start).Equal(shards[index-1].End)
+				g.Assert(content[shard.Start-1]).Equal(byte('\n'))
+
+				// The ride of the shard first line differs from the ride of the previous line
+				lines := strings.Split(strings.TrimSpace(content[:shard.Start]), "\n")
+				previous, _, err := CSVLoader{}.ParseLine(lines[len(lines)-1])
+				g.Assert(err).Equal(nil)
+
+				current, _, err := CSVLoader{}.ParseLine(strings.SplitN(content[shard.Start:], "\n", 2)[0])
+				g.Assert(err).Equal(nil)
+				g.Assert(current != previous).Equal(true)
+			}
+		})
+
+		g.It("It should merge the ranges without a ride start", func() {
+			// test_paths_02.csv holds a single ride
+			shards, err := planShards(fmt.Sprintf("%s/test_paths_02.csv", testDataDir), CSVLoader{}, 0, 4)
+			g.Assert(err).Equal(nil)
+			g.Assert(len(shards)).Equal(1)
+		})
+
+		g.It("It should split only a single uncompressed dataset file", func() {
+			defer func() {
+				viper.Set("input.shards", 1)
+				viper.Set("input.min_shard_size", 16)
+			}()
+
+			filePath := fmt.Sprintf("%s/test_paths_01.csv", testDataDir)
+
+			shards, err := splitDataset([]string{filePath}, CompressionAuto, CSVLoader{})
+			g.Assert(err).Equal(nil)
+			g.Assert(shards == nil).Equal(true)
+
+			viper.Set("input.shards", 4)
+
+			// Smaller than the min shard size
+			shards, err = splitDataset([]string{filePath}, CompressionAuto, CSVLoader{})
+			g.Assert(err).Equal(nil)
+			g.Assert(shards == nil).Equal(true)
+
+			viper.Set("input.min_shard_size", 0)
+
+			shards, err = splitDataset([]string{filePath}, CompressionAuto, CSVLoader{})
+			g.Assert(err).Equal(nil)
+			g.Assert(len(shards) > 1).Equal(true)
+
+			shards, err = splitDataset([]string{fmt.Sprintf("%s/test_paths_02.csv.gz", testDataDir)}, CompressionAuto, CSVLoader{})
+			g.Assert(err).Equal(nil)
+			g.Assert(shards == nil).Equal(true)
+
+			shards, err = splitDataset([]string{filePath, filePath}, CompressionAuto, CSVLoader{})
+			g.Assert(err).Equal(nil)
+			g.Assert(shards == nil).Equal(true)
+		})
+
+		g.It("It should give the output of a sequential run", func() {
+			defer func() {
+				viper.Set("input.shards", 1)
+				viper.Set("input.min_shard_size", 16)
+				viper.Set("output.ordered", false)
+				viper.Set("output.reorder_buffer", 1000)
+				viper.Set("workers.size", 0)
+			}()
+
+			datasetFile := fmt.Sprintf("%s/dataset_shards_test01.csv", cacheDir)
+			writeDataset(datasetFile)
+
+			viper.Set("output.ordered", true)
+			viper.Set("workers.size", 4)
+
+			expected := runPipeline(datasetFile, fmt.Sprintf("%s/dataset_shards_test02.csv", cacheDir), make(chan struct{}))
+			g.Assert(len(expected)).Equal(300)
+
+			viper.Set("input.shards", 7)
+			viper.Set("input.min_shard_size", 0)
+
+			loader, err := NewCSVLoader()
+			g.Assert(err).Equal(nil)
+
+			shards, err := splitDataset([]string{datasetFile}, CompressionAuto, loader)
+			g.Assert(err).Equal(nil)
+			g.Assert(len(shards)).Equal(7)
+
+			// A small reorder buffer makes the shards read ahead wait for their tokens
+			for _, size := range []int{1000, 4} {
+				viper.Set("output.reorder_buffer", size)
+
+				output := runPipeline(datasetFile, fmt.Sprintf("%s/dataset_shards_test03.csv", cacheDir), make(chan struct{}))
+				g.Assert(output).Equal(expected)
+			}
+
+			viper.Set("output.ordered", false)
+
+			output := runPipeline(datasetFile, fmt.Sprintf("%s/dataset_shards_test03.csv", cacheDir), make(chan struct{}))
+			sort.Strings(output)
+			sort.Strings(expected)
+			g.Assert(output).Equal(expected)
+		})
+
+		g.It("It should store the rides read before a stop", func() {
+			defer func() {
+				viper.Set("input.shards", 1)
+				viper.Set("input.min_shard_size", 16)
+				viper.Set("output.ordered", false)
+			}()
+
+			datasetFile := fmt.Sprintf("%s/dataset_shards_test01.csv", cacheDir)
+			writeDataset(datasetFile)
+
+			viper.Set("input.shards", 7)
+			viper.Set("input.min_shard_size", 0)
+			viper.Set("output.ordered", true)
+
+			stop := make(chan struct{})
+			close(stop)
+
+			output := runPipeline(datasetFile, fmt.Sprintf("%s/dataset_shards_test04.csv", cacheDir), stop)
+			g.Assert(len(output) <= 300).Equal(true)
+		})
+
+		g.It("It should move the order to the next shard once a shard is read", func() {
+			order := newRideOrder(3, 1)
+			g.Assert(order.skip(2)).Equal(2)
+
+			order.finish(0, 2)
+			g.Assert(order.skip(1)).Equal(1)
+			g.Assert(order.skip(2)).Equal(1 << shardSequenceBits)
+
+			// An empty shard is skipped too
+			order.finish(1, 0)
+			g.Assert(order.skip(2)).Equal(2 << shardSequenceBits)
+
+			// The last shard has no next shard
+			order.finish(2, 1)
+			g.Assert(order.skip(2<<shardSequenceBits + 1)).Equal(2<<shardSequenceBits + 1)
+		})
+	})
+}
+
+// BenchmarkReadShards benchmark the rows per second of reading the synthetic dataset
+// in 4 shards
+func BenchmarkReadShards(b *testing.B) {
+	baseDir := pkg.GetBaseDir("cache")
+	pkg.LoadConfigs(fmt.Sprintf("%s/config.dist.yml", baseDir))
+
+	viper.Set("input.shards", 4)
+	viper.Set("input.min_shard_size", 0)
+
+	defer func() {
+		viper.Set("input.shards", 1)
+		viper.Set("input.min_shard_size", 16)
+	}()
+
+	rows := benchmarkRows()
+	filePath := syntheticDataset(b, rows)
+
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		start := time.Now()
+
+		shards, err := splitDataset([]string{filePath}, CompressionAuto, CSVLoader{})
+
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		if shards == nil {
+			b.Fatal("The dataset is not split")
+		}
+
+		channel := make(chan RideBatch, viper.GetInt("workers.buffers.rides"))
+
+		go readShards(context.Background(), filePath, CSVLoader{}, shards, nil, nil, nil, channel)
+
+		for range channel {
+		}
+
+		b.ReportMetric(float64(rows)/time.Since(start).Seconds(), "rows/s")
+	}
+}
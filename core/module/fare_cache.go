@@ -0,0 +1,314 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package module
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"bitbucket.org/clivern/beat/core/model"
+
+	"github.com/spf13/viper"
+)
+
+// fareCacheVersion changes whenever the fare calculation changes so older fares are not reused
+const fareCacheVersion = 1
+
+// tariffSections are the config sections that change the fare of a ride
+var tariffSections = []string{
+	"distance",
+	"fare",
+	"map_matching",
+	"segment",
+	"units",
+	"validation",
+}
+
+// fareCacheMigrations are the schema changes of the fare cache database
+var fareCacheMigrations = []string{
+	`CREATE TABLE fares (
+		ride_hash TEXT NOT NULL,
+		tariff_hash TEXT NOT NULL,
+		fare REAL NOT NULL,
+		metrics TEXT NOT NULL,
+		segments TEXT NOT NULL,
+		PRIMARY KEY (ride_hash, tariff_hash)
+	)`,
+}
+
+// CachedFare struct type
+// The fare of a ride and the values calculated with it
+type CachedFare struct {
+	Fare     float64
+	Metrics  model.RideMetrics
+	Segments []model.RideSegment
+}
+
+// fareCacheEntry struct type
+// A new fare waiting to be written
+type fareCacheEntry struct {
+	rideHash string
+	fare     CachedFare
+}
+
+// FareCache struct type
+// It keeps the fares of the priced rides between runs in a SQLite database inside the
+// cache directory. The fares are keyed by a hash of the ride points and a hash of the
+// tariff config, so a ride is priced again once its points or the tariff change. The
+// database is in WAL mode so the workers read through a pool of connections while the
+// new fares are written on a single one. The segments are only kept for the SQLite
+// segments output
+type FareCache struct {
+	db         *sql.DB
+	reader     *sql.DB
+	get        *sql.Stmt
+	tariffHash string
+	batchSize  int
+	segments   bool
+	pending    []fareCacheEntry
+	mutex      sync.Mutex
+	hits       int64
+	misses     int64
+}
+
+// NewFareCache opens the fare cache database of the cache directory
+func NewFareCache(cacheDir string) (*FareCache, error) {
+	tariffHash, err := TariffHash()
+
+	if err != nil {
+		return nil, err
+	}
+
+	filePath := filepath.Join(cacheDir, "fare_cache.db")
+
+	db, err := sql.Open("sqlite", filePath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// A single connection since SQLite allows one writer at a time
+	db.SetMaxOpenConns(1)
+
+	// The journal mode is kept in the database file so the readers don't wait for the writer
+	if _, err := db.Exec("PRAGMA journal_mode=WAL"); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Unable to open database %s: %s", filePath, err.Error())
+	}
+
+	if err := migrateSQLite(db, fareCacheMigrations); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Unable to migrate database %s: %s", filePath, err.Error())
+	}
+
+	reader, err := sql.Open("sqlite", filePath)
+
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	reader.SetMaxOpenConns(runtime.GOMAXPROCS(0))
+	reader.SetMaxIdleConns(runtime.GOMAXPROCS(0))
+
+	get, err := reader.Prepare("SELECT fare, metrics, segments FROM fares WHERE ride_hash = ? AND tariff_hash = ?")
+
+	if err != nil {
+		reader.Close()
+		db.Close()
+		return nil, fmt.Errorf("Unable to read database %s: %s", filePath, err.Error())
+	}
+
+	cache := &FareCache{
+		db:         db,
+		reader:     reader,
+		get:        get,
+		tariffHash: tariffHash,
+		batchSize:  viper.GetInt("fare_cache.batch_size"),
+		segments:   viper.GetBool("output.sqlite.segments"),
+	}
+
+	if cache.batchSize < 1 {
+		cache.batchSize = 1
+	}
+
+	return cache, nil
+}
+
+// TariffHash gets a hash of the config sections that change the fare of a ride and
+// of the OpenStreetMap extract once the rides are map matched
+func TariffHash() (string, error) {
+	settings := viper.AllSettings()
+	tariff := make(map[string]interface{})
+
+	for _, section := range tariffSections {
+		tariff[section] = settings[section]
+	}
+
+	// The map_matching section only holds the path of the extract
+	if viper.GetBool("map_matching.enabled") {
+		key, err := RoadGraphKey(viper.GetString("map_matching.osm_file"))
+
+		if err != nil {
+			return "", err
+		}
+
+		tariff["road_graph"] = key
+	}
+
+	// Maps are encoded with sorted keys
+	data, err := json.Marshal(tariff)
+
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%d|%s", fareCacheVersion, data)))
+
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// RideHash gets a hash of the ride points
+func RideHash(ride *model.Ride) string {
+	hash := sha256.New()
+
+	for _, coordinate := range ride.GetCoordinates() {
+		hash.Write([]byte(strconv.FormatFloat(coordinate.Latitude, 'g', -1, 64)))
+		hash.Write([]byte{','})
+		hash.Write([]byte(strconv.FormatFloat(coordinate.Longitude, 'g', -1, 64)))
+		hash.Write([]byte{','})
+		hash.Write([]byte(strconv.FormatInt(coordinate.Timestamp.UnixNano(), 10)))
+		hash.Write([]byte{'\n'})
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Get gets the fare of a ride hash priced under the current tariff. A fare stored
+// without its segments is a miss once the segments are needed. A failed read is
+// a miss too and the error is returned
+func (c *FareCache) Get(rideHash string) (CachedFare, bool, error) {
+	var fare CachedFare
+	var metrics, segments string
+
+	err := c.get.QueryRow(rideHash, c.tariffHash).Scan(&fare.Fare, &metrics, &segments)
+
+	if err == sql.ErrNoRows || (err == nil && c.segments && segments == "") {
+		atomic.AddInt64(&c.misses, 1)
+		return CachedFare{}, false, nil
+	}
+
+	if err == nil {
+		err = json.Unmarshal([]byte(metrics), &fare.Metrics)
+	}
+
+	if err == nil && c.segments {
+		err = json.Unmarshal([]byte(segments), &fare.Segments)
+	}
+
+	if err != nil {
+		atomic.AddInt64(&c.misses, 1)
+		return CachedFare{}, false, err
+	}
+
+	atomic.AddInt64(&c.hits, 1)
+
+	return fare, true, nil
+}
+
+// Put adds the fare of a ride hash, fares are written in transactions of fare_cache.batch_size
+func (c *FareCache) Put(rideHash string, fare CachedFare) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.pending = append(c.pending, fareCacheEntry{rideHash: rideHash, fare: fare})
+
+	if len(c.pending) < c.batchSize {
+		return nil
+	}
+
+	return c.flush()
+}
+
+// Stats gets the number of cache hits and misses
+func (c *FareCache) Stats() (int64, int64) {
+	return atomic.LoadInt64(&c.hits), atomic.LoadInt64(&c.misses)
+}
+
+// Close writes the pending fares and closes the database
+func (c *FareCache) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.get.Close()
+	c.reader.Close()
+
+	if err := c.flush(); err != nil {
+		c.db.Close()
+		return err
+	}
+
+	return c.db.Close()
+}
+
+// flush writes the pending fares in one transaction
+func (c *FareCache) flush() error {
+	if len(c.pending) == 0 {
+		return nil
+	}
+
+	tx, err := c.db.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range c.pending {
+		metrics, err := json.Marshal(entry.fare.Metrics)
+
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		// An empty text marks a fare stored without its segments
+		var segments []byte
+
+		if c.segments {
+			segments, err = json.Marshal(entry.fare.Segments)
+
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+
+		_, err = tx.Exec(
+			"INSERT OR REPLACE INTO fares (ride_hash, tariff_hash, fare, metrics, segments) VALUES (?, ?, ?, ?, ?)",
+			entry.rideHash,
+			c.tariffHash,
+			entry.fare.Fare,
+			string(metrics),
+			string(segments),
+		)
+
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	c.pending = c.pending[:0]
+
+	return tx.Commit()
+}
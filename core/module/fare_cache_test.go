@@ -0,0 +1,209 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package module
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	"bitbucket.org/clivern/beat/core/model"
+	"bitbucket.org/clivern/beat/pkg"
+
+	"github.com/franela/goblin"
+	"github.com/spf13/viper"
+)
+
+// TestFareCache test cases
+func TestFareCache(t *testing.T) {
+	baseDir := pkg.GetBaseDir("cache")
+	testDataDir := fmt.Sprintf("%s/%s", baseDir, "testdata")
+	cacheDir := fmt.Sprintf("%s/%s", baseDir, "cache")
+	pkg.LoadConfigs(fmt.Sprintf("%s/config.dist.yml", baseDir))
+
+	g := goblin.Goblin(t)
+
+	newRide := func() *model.Ride {
+		ride := model.NewRide()
+		ride.SetID(1)
+
+		// car was moving @6:42pm (distance is 11.46 km)
+		ride.AppendCoordinate(model.Coordinate{Latitude: 52.316275, Longitude: 4.678871, Timestamp: time.Unix(1608056422, 0)})
+		ride.AppendCoordinate(model.Coordinate{Latitude: 52.370210, Longitude: 4.535538, Timestamp: time.Unix(1608057742, 0)})
+
+		return ride
+	}
+
+	g.Describe("FareCache", func() {
+		g.It("It should hash the ride points and the tariff config", func() {
+			defer viper.Set("fare.minimum", 3.47)
+
+			ride := newRide()
+			g.Assert(RideHash(ride)).Equal(RideHash(newRide()))
+
+			ride.AppendCoordinate(model.Coordinate{Latitude: 52.370210, Longitude: 4.535538, Timestamp: time.Unix(1608057752, 0)})
+			g.Assert(RideHash(ride) != RideHash(newRide())).Equal(true)
+
+			before, err := TariffHash()
+			g.Assert(err).Equal(nil)
+
+			viper.Set("fare.minimum", 4)
+
+			after, err := TariffHash()
+			g.Assert(err).Equal(nil)
+			g.Assert(before != after).Equal(true)
+		})
+
+		g.It("It should hash the map matching extract", func() {
+			defer func() {
+				viper.Set("map_matching.enabled", false)
+				viper.Set("map_matching.osm_file", "")
+			}()
+
+			osmFile := fmt.Sprintf("%s/fare_cache_test01.osm", cacheDir)
+			content, err := ioutil.ReadFile(fmt.Sprintf("%s/test_roads_01.osm", testDataDir))
+			g.Assert(err).Equal(nil)
+			g.Assert(ioutil.WriteFile(osmFile, content, 0644)).Equal(nil)
+
+			viper.Set("map_matching.enabled", true)
+			viper.Set("map_matching.osm_file", osmFile)
+
+			before, err := TariffHash()
+			g.Assert(err).Equal(nil)
+
+			// The extract is replaced at the same path
+			g.Assert(ioutil.WriteFile(osmFile, append(content, '\n'), 0644)).Equal(nil)
+
+			after, err := TariffHash()
+			g.Assert(err).Equal(nil)
+			g.Assert(before != after).Equal(true)
+
+			g.Assert(os.Remove(osmFile)).Equal(nil)
+
+			_, err = TariffHash()
+			g.Assert(err != nil).Equal(true)
+		})
+
+		g.It("It should reuse the fares priced under the same tariff", func() {
+			defer viper.Set("fare.standard_fee", 1.30)
+
+			os.Remove(fmt.Sprintf("%s/fare_cache.db", cacheDir))
+
+			cache, err := NewFareCache(cacheDir)
+			g.Assert(err).Equal(nil)
+
+			ride := newRide()
+
+			fare, err := NewRideProcessor(nil, nil, nil, cache).Process(ride)
+			g.Assert(err).Equal(nil)
+			g.Assert(cache.Close()).Equal(nil)
+
+			hits, misses := cache.Stats()
+			g.Assert(hits).Equal(int64(0))
+			g.Assert(misses).Equal(int64(1))
+
+			cache, err = NewFareCache(cacheDir)
+			g.Assert(err).Equal(nil)
+
+			cached := newRide()
+
+			cachedFare, err := NewRideProcessor(nil, nil, nil, cache).Process(cached)
+			g.Assert(err).Equal(nil)
+			g.Assert(cachedFare).Equal(fare)
+			g.Assert(cached.GetMetrics()).Equal(ride.GetMetrics())
+			g.Assert(cache.Close()).Equal(nil)
+
+			hits, misses = cache.Stats()
+			g.Assert(hits).Equal(int64(1))
+			g.Assert(misses).Equal(int64(0))
+
+			// A tariff change prices the ride again
+			viper.Set("fare.standard_fee", 2.30)
+
+			cache, err = NewFareCache(cacheDir)
+			g.Assert(err).Equal(nil)
+
+			repriced, err := NewRideProcessor(nil, nil, nil, cache).Process(newRide())
+			g.Assert(err).Equal(nil)
+			g.Assert(math.Abs(repriced-fare-1) < 0.000001).Equal(true)
+			g.Assert(cache.Close()).Equal(nil)
+
+			hits, misses = cache.Stats()
+			g.Assert(hits).Equal(int64(0))
+			g.Assert(misses).Equal(int64(1))
+		})
+
+		g.It("It should only keep the segments for the SQLite segments output", func() {
+			defer viper.Set("output.sqlite.segments", false)
+
+			os.Remove(fmt.Sprintf("%s/fare_cache.db", cacheDir))
+
+			cache, err := NewFareCache(cacheDir)
+			g.Assert(err).Equal(nil)
+
+			ride := newRide()
+
+			_, err = NewRideProcessor(nil, nil, nil, cache).Process(ride)
+			g.Assert(err).Equal(nil)
+			g.Assert(len(ride.GetSegments())).Equal(0)
+			g.Assert(cache.Close()).Equal(nil)
+
+			// The fare stored without its segments is priced again
+			viper.Set("output.sqlite.segments", true)
+
+			cache, err = NewFareCache(cacheDir)
+			g.Assert(err).Equal(nil)
+
+			_, found, err := cache.Get(RideHash(newRide()))
+			g.Assert(found).Equal(false)
+			g.Assert(err).Equal(nil)
+
+			ride = newRide()
+
+			_, err = NewRideProcessor(nil, nil, nil, cache).Process(ride)
+			g.Assert(err).Equal(nil)
+			g.Assert(len(ride.GetSegments()) > 0).Equal(true)
+			g.Assert(cache.Close()).Equal(nil)
+
+			cache, err = NewFareCache(cacheDir)
+			g.Assert(err).Equal(nil)
+
+			cached, found, err := cache.Get(RideHash(newRide()))
+			g.Assert(found).Equal(true)
+			g.Assert(err).Equal(nil)
+			g.Assert(len(cached.Segments)).Equal(len(ride.GetSegments()))
+			g.Assert(cache.Close()).Equal(nil)
+		})
+
+		g.It("It should return the errors of invalid cached fares", func() {
+			os.Remove(fmt.Sprintf("%s/fare_cache.db", cacheDir))
+
+			cache, err := NewFareCache(cacheDir)
+			g.Assert(err).Equal(nil)
+
+			_, err = cache.db.Exec(
+				"INSERT INTO fares (ride_hash, tariff_hash, fare, metrics, segments) VALUES (?, ?, ?, ?, ?)",
+				RideHash(newRide()),
+				cache.tariffHash,
+				3.47,
+				"invalid",
+				"",
+			)
+			g.Assert(err).Equal(nil)
+
+			_, found, err := cache.Get(RideHash(newRide()))
+			g.Assert(found).Equal(false)
+			g.Assert(err != nil).Equal(true)
+
+			hits, misses := cache.Stats()
+			g.Assert(hits).Equal(int64(0))
+			g.Assert(misses).Equal(int64(1))
+			g.Assert(cache.Close()).Equal(nil)
+		})
+	})
+}
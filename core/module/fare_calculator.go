@@ -5,100 +5,192 @@
 package module
 
 import (
-	"fmt"
-
 	"bitbucket.org/clivern/beat/core/model"
+	"bitbucket.org/clivern/beat/core/util"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
 
+// segmentFare struct type
+// The distance is in Km and the elapsed time is in hours
+type segmentFare struct {
+	fare        float64
+	distance    float64
+	timeElapsed float64
+	idle        bool
+	night       bool
+}
+
+// segmentTariff struct type
+// The segment pricing config read once per ride instead of once per segment. The
+// idle speed is in Km/h and the moving rates are per Km
+type segmentTariff struct {
+	idleSpeed      float64
+	idlePerHour    float64
+	dayRatePerKm   float64
+	nightRatePerKm float64
+}
+
 // CalculateRideFare calculates the whole ride fare (for a plenty of segments)
+// It also sets the ride metrics like the distance and the idle time. The priced
+// segments are only kept for the SQLite segments output
 func CalculateRideFare(ride *model.Ride) (float64, error) {
 	// Init total from the standard fee
 	total := viper.GetFloat64("fare.standard_fee")
+	metrics := model.RideMetrics{}
+	tariff := loadSegmentTariff()
 
 	coordinates := ride.GetCoordinates()
 
+	var segments []model.RideSegment
+
+	if viper.GetBool("output.sqlite.segments") {
+		segments = make([]model.RideSegment, 0, len(coordinates))
+	}
+
+	// Use the matched road distances if the ride was map matched
+	roadDistances := ride.GetRoadDistances()
+
+	if len(roadDistances) != len(coordinates)-1 {
+		roadDistances = nil
+	}
+
 	for index, coordinate := range coordinates {
 		// If it is the last element, break
 		if index == len(coordinates)-1 {
 			break
 		}
 
+		var distance float64
+
+		if roadDistances != nil {
+			distance = roadDistances[index]
+		} else {
+			_, distance = coordinate.GetDistance(coordinates[index+1])
+		}
+
 		// Calculate the segment fare
-		subTotal, err := calculateSegmentFare(coordinate, coordinates[index+1])
+		segment, err := calculateSegment(coordinate, coordinates[index+1], distance, tariff)
 
 		if err != nil {
 			return total, err
 		}
 
-		log.Debug(fmt.Sprintf(
-			"Ride %d, Segment fare for coodinate (%f, %f, %s) and coodinate (%f, %f, %s) is %f",
-			ride.GetID(),
-			coordinate.Latitude,
-			coordinate.Longitude,
-			coordinate.Timestamp,
-			coordinates[index+1].Latitude,
-			coordinates[index+1].Longitude,
-			coordinates[index+1].Timestamp,
-			subTotal,
-		))
+		// The per-segment logs are sampled
+		if log.IsLevelEnabled(log.DebugLevel) && util.SegmentLogSampler.Sample() {
+			log.WithFields(log.Fields{
+				"ride_id":       ride.GetID(),
+				"segment_index": index,
+				"stage":         "fare",
+				"distance":      segment.distance,
+				"fare":          segment.fare,
+				"sample_rate":   util.SegmentLogSampler.Rate(),
+			}).Debug("Segment fare calculated")
+		}
 
 		// Add segment fare to the total price
-		total += subTotal
+		total += segment.fare
+
+		if segments != nil {
+			segments = append(segments, model.RideSegment{
+				Index:    index,
+				Start:    coordinate,
+				End:      coordinates[index+1],
+				Distance: segment.distance,
+				Duration: segment.timeElapsed,
+				Fare:     segment.fare,
+				Idle:     segment.idle,
+				Night:    segment.night,
+			})
+		}
+
+		metrics.Distance += segment.distance
+
+		if segment.night {
+			metrics.NightDistance += segment.distance
+		}
+
+		if segment.idle {
+			metrics.IdleTime += segment.timeElapsed
+		} else {
+			metrics.MovingTime += segment.timeElapsed
+		}
 	}
 
+	ride.SetMetrics(metrics)
+	ride.SetSegments(segments)
+
 	// If fare is less than the minimum, override with the
 	// minimum value
 	if total < viper.GetFloat64("fare.minimum") {
 		total = viper.GetFloat64("fare.minimum")
 	}
 
-	log.Debug(fmt.Sprintf(
-		"Total fare for ride with ID %d is %f",
-		ride.GetID(),
-		total,
-	))
+	distanceUnit := model.GetDistanceUnit()
+
+	log.WithFields(log.Fields{
+		"ride_id":       ride.GetID(),
+		"stage":         "fare",
+		"fare":          total,
+		"distance":      distanceUnit.FromKm(metrics.Distance),
+		"distance_unit": string(distanceUnit),
+	}).Debug("Ride fare calculated")
 
 	return total, nil
 }
 
-// calculateSegmentFare calculates the fare for a segment. A segment is just two coordinates
-func calculateSegmentFare(oldCoordinate model.Coordinate, newCoordinate model.Coordinate) (float64, error) {
-	var total float64
+// loadSegmentTariff reads the segment pricing config. The idle threshold is in the
+// speed unit and the moving rates are per rate unit
+func loadSegmentTariff() segmentTariff {
+	rateUnit := model.GetRateUnit()
+
+	return segmentTariff{
+		idleSpeed:      model.GetSpeedUnit().ToKm(viper.GetFloat64("segment.pricing.idle.min_threshold")),
+		idlePerHour:    viper.GetFloat64("segment.pricing.idle.price_per_hour"),
+		dayRatePerKm:   rateUnit.RatePerKm(viper.GetFloat64("segment.pricing.moving.from_05_00_per_km")),
+		nightRatePerKm: rateUnit.RatePerKm(viper.GetFloat64("segment.pricing.moving.from_00_05_per_km")),
+	}
+}
+
+// calculateSegment calculates the fare for a segment with a known distance in Km
+func calculateSegment(oldCoordinate model.Coordinate, newCoordinate model.Coordinate, distance float64, tariff segmentTariff) (segmentFare, error) {
+	segment := segmentFare{distance: distance}
 
 	speed, err := oldCoordinate.GetSpeed(newCoordinate)
 
 	if err != nil {
-		return total, err
+		return segment, err
 	}
 
-	if speed > viper.GetFloat64("segment.pricing.idle.min_threshold") {
-		// The car was moving
-		_, distance := oldCoordinate.GetDistance(newCoordinate)
+	timeElapsed, err := oldCoordinate.GetElapsedTime(newCoordinate)
 
-		// Segment start hour
-		hour, _, _ := oldCoordinate.Timestamp.Clock()
+	if err != nil {
+		return segment, err
+	}
 
-		// If hour is more or equal 05:00 and less than or equal 23:00
-		if hour >= 5 && hour <= 23 {
+	segment.timeElapsed = timeElapsed
+
+	// Segment start hour
+	hour, _, _ := oldCoordinate.Timestamp.Clock()
+
+	// If hour is less than 05:00
+	segment.night = hour < 5
+
+	if speed > tariff.idleSpeed {
+		// The car was moving
+		if !segment.night {
 			// Use the 05:00 - 00:00 price
-			total += distance * viper.GetFloat64("segment.pricing.moving.from_05_00_per_km")
+			segment.fare = distance * tariff.dayRatePerKm
 		} else {
 			// Use the 00:00 - 05:00 price
-			total += distance * viper.GetFloat64("segment.pricing.moving.from_00_05_per_km")
+			segment.fare = distance * tariff.nightRatePerKm
 		}
 	} else {
 		// the car was idle
-		timeElapsed, err := oldCoordinate.GetElapsedTime(newCoordinate)
-
-		if err != nil {
-			return total, err
-		}
-
-		total += viper.GetFloat64("segment.pricing.idle.price_per_hour") * timeElapsed
+		segment.idle = true
+		segment.fare = tariff.idlePerHour * timeElapsed
 	}
 
-	return total, nil
+	return segment, nil
 }
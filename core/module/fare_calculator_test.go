@@ -67,9 +67,10 @@ func TestCalculateSegmentFare(t *testing.T) {
 					Timestamp: time.Unix(tt.newTimestamp, 0),
 				}
 
-				fare, err := calculateSegmentFare(old, new)
+				_, distance := old.GetDistance(new)
+				segment, err := calculateSegment(old, new, distance, loadSegmentTariff())
 
-				g.Assert(fare).Equal(tt.wantFare)
+				g.Assert(segment.fare).Equal(tt.wantFare)
 				g.Assert(err == nil).Equal(tt.wantErrorNil)
 			}
 		})
@@ -94,8 +95,11 @@ func BenchmarkCalculateSegmentFare(b *testing.B) {
 		Timestamp: time.Unix(1608057742, 0),
 	}
 
+	tariff := loadSegmentTariff()
+
 	for n := 0; n < b.N; n++ {
-		calculateSegmentFare(old, new)
+		_, distance := old.GetDistance(new)
+		calculateSegment(old, new, distance, tariff)
 	}
 }
 
@@ -250,3 +254,125 @@ func BenchmarkCalculateRideFare(b *testing.B) {
 		CalculateRideFare(ride)
 	}
 }
+
+// TestCalculateRideFareRoadDistances test cases
+func TestCalculateRideFareRoadDistances(t *testing.T) {
+	// Load Configs
+	baseDir := pkg.GetBaseDir("cache")
+	pkg.LoadConfigs(fmt.Sprintf("%s/config.dist.yml", baseDir))
+
+	g := goblin.Goblin(t)
+
+	g.Describe("CalculateRideFare", func() {
+		g.It("It should bill the matched road distances if provided", func() {
+			ride := model.NewRide()
+
+			// car was moving @6:42pm (distance is 11.46 km)
+			ride.AppendCoordinate(model.Coordinate{Latitude: 52.316275, Longitude: 4.678871, Timestamp: time.Unix(1608056422, 0)})
+			ride.AppendCoordinate(model.Coordinate{Latitude: 52.370210, Longitude: 4.535538, Timestamp: time.Unix(1608057742, 0)})
+
+			// The road distance is 15 km
+			ride.SetRoadDistances([]float64{15})
+
+			fare, err := CalculateRideFare(ride)
+
+			g.Assert(err).Equal(nil)
+			g.Assert(fare).Equal(15*viper.GetFloat64("segment.pricing.moving.from_05_00_per_km") + viper.GetFloat64("fare.standard_fee"))
+
+			// Road distances that don't match the segments are ignored
+			ride.SetRoadDistances([]float64{15, 20})
+
+			fare, err = CalculateRideFare(ride)
+
+			g.Assert(err).Equal(nil)
+			g.Assert(fare).Equal(8.462425888868383 + viper.GetFloat64("fare.standard_fee"))
+		})
+	})
+}
+
+// TestCalculateSegmentFareUnits test cases
+func TestCalculateSegmentFareUnits(t *testing.T) {
+	// Load Configs
+	baseDir := pkg.GetBaseDir("cache")
+	pkg.LoadConfigs(fmt.Sprintf("%s/config.dist.yml", baseDir))
+
+	g := goblin.Goblin(t)
+
+	g.Describe("CalculateSegmentFare", func() {
+		g.It("It should apply rates and thresholds set per mile", func() {
+			old := model.Coordinate{Latitude: 52.316275, Longitude: 4.678871, Timestamp: time.Unix(1608056422, 0)}
+			new := model.Coordinate{Latitude: 52.370210, Longitude: 4.535538, Timestamp: time.Unix(1608057742, 0)}
+
+			_, distance := old.GetDistance(new)
+
+			// car was moving @6:42pm (distance is 11.46 km or 7.12 miles)
+			viper.Set("units.rate", "mi")
+			segment, err := calculateSegment(old, new, distance, loadSegmentTariff())
+
+			g.Assert(err).Equal(nil)
+			g.Assert(math.Round(segment.fare*100) / 100).Equal(5.26)
+
+			// car speed is 31.25 km/h or 19.42 mph so it is idle if the threshold is 20 mph
+			viper.Set("units.speed", "mi")
+			viper.Set("segment.pricing.idle.min_threshold", 20)
+			segment, err = calculateSegment(old, new, distance, loadSegmentTariff())
+
+			g.Assert(err).Equal(nil)
+			g.Assert(math.Round(segment.fare*100) / 100).Equal(4.36)
+
+			viper.Set("units.rate", "km")
+			viper.Set("units.speed", "km")
+			viper.Set("segment.pricing.idle.min_threshold", 10)
+		})
+	})
+}
+
+// TestCalculateRideFareMetrics test cases
+func TestCalculateRideFareMetrics(t *testing.T) {
+	// Load Configs
+	baseDir := pkg.GetBaseDir("cache")
+	pkg.LoadConfigs(fmt.Sprintf("%s/config.dist.yml", baseDir))
+
+	g := goblin.Goblin(t)
+
+	g.Describe("CalculateRideFare", func() {
+		g.It("It should set the ride distance, night distance, moving and idle time", func() {
+			defer viper.Set("output.sqlite.segments", false)
+
+			viper.Set("output.sqlite.segments", true)
+
+			ride := model.NewRide()
+
+			// car was moving @1:00am (distance is 11.46 km)
+			ride.AppendCoordinate(model.Coordinate{Latitude: 52.316275, Longitude: 4.678871, Timestamp: time.Unix(1607994000, 0)})
+			ride.AppendCoordinate(model.Coordinate{Latitude: 52.370210, Longitude: 4.535538, Timestamp: time.Unix(1607995320, 0)})
+
+			// car was idle for 1.5 hours
+			ride.AppendCoordinate(model.Coordinate{Latitude: 52.370210, Longitude: 4.535538, Timestamp: time.Unix(1608000720, 0)})
+
+			_, err := CalculateRideFare(ride)
+			g.Assert(err).Equal(nil)
+
+			metrics := ride.GetMetrics()
+
+			g.Assert(math.Round(metrics.Distance*100) / 100).Equal(11.44)
+			g.Assert(metrics.NightDistance).Equal(metrics.Distance)
+			g.Assert(metrics.MovingTime).Equal(0.366667)
+			g.Assert(metrics.IdleTime).Equal(1.5)
+
+			segments := ride.GetSegments()
+
+			g.Assert(len(segments)).Equal(2)
+			g.Assert(segments[0].Idle).Equal(false)
+			g.Assert(segments[1].Idle).Equal(true)
+			g.Assert(segments[1].Duration).Equal(1.5)
+
+			// The segments are only kept for the SQLite segments output
+			viper.Set("output.sqlite.segments", false)
+
+			_, err = CalculateRideFare(ride)
+			g.Assert(err).Equal(nil)
+			g.Assert(ride.GetSegments() == nil).Equal(true)
+		})
+	})
+}
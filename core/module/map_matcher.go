@@ -0,0 +1,219 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package module
+
+import (
+	"math"
+	"sort"
+
+	"bitbucket.org/clivern/beat/core/model"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const (
+	maxMatchCandidates = 8 // max number of candidate roads per coordinate.
+	routeLimitFactor   = 3 // max route distance as a factor of the straight line distance.
+)
+
+// MapMatcher snaps ride coordinates to the road network using a hidden markov model
+// as described in "Hidden Markov Map Matching Through Noise and Sparseness" by Newson and Krumm
+type MapMatcher struct {
+	Graph *model.RoadGraph
+
+	// All the following values are in Km
+	SearchRadius float64
+	Sigma        float64
+	Beta         float64
+}
+
+// matchCandidate is a possible position of a coordinate on a road edge
+type matchCandidate struct {
+	edge     int
+	fraction float64
+	distance float64
+}
+
+// matchStep holds the viterbi state of one coordinate
+type matchStep struct {
+	coordinate int
+	candidates []matchCandidate
+	scores     []float64
+	previous   []int
+	routes     []float64
+}
+
+// NewMapMatcher creates a new instance of MapMatcher. Config values are in meters
+func NewMapMatcher(graph *model.RoadGraph) *MapMatcher {
+	return &MapMatcher{
+		Graph:        graph,
+		SearchRadius: viper.GetFloat64("map_matching.search_radius") / 1000,
+		Sigma:        viper.GetFloat64("map_matching.sigma") / 1000,
+		Beta:         viper.GetFloat64("map_matching.beta") / 1000,
+	}
+}
+
+// Match gets the road distance in Km for each segment of the coordinates. Segments
+// that can't be matched to the road network fall back to the straight line distance
+func (m *MapMatcher) Match(coordinates []model.Coordinate) []float64 {
+	distances := make([]float64, 0)
+
+	if len(coordinates) < 2 {
+		return distances
+	}
+
+	for index := 1; index < len(coordinates); index++ {
+		_, distance := coordinates[index-1].GetDistance(coordinates[index])
+		distances = append(distances, distance)
+	}
+
+	chain := make([]*matchStep, 0)
+
+	for index, coordinate := range coordinates {
+		step := &matchStep{
+			coordinate: index,
+			candidates: m.getCandidates(coordinate),
+		}
+
+		if len(chain) > 0 && len(step.candidates) > 0 && m.transit(chain[len(chain)-1], step, distances[index-1]) {
+			chain = append(chain, step)
+			continue
+		}
+
+		// The HMM breaks here, keep the matched part and start a new chain
+		m.resolve(chain, distances)
+		chain = chain[:0]
+
+		if len(step.candidates) > 0 {
+			step.scores = make([]float64, len(step.candidates))
+
+			for i, candidate := range step.candidates {
+				step.scores[i] = m.getEmission(candidate)
+			}
+
+			chain = append(chain, step)
+		}
+	}
+
+	m.resolve(chain, distances)
+
+	return distances
+}
+
+// getCandidates gets the closest road positions within the search radius of a coordinate
+func (m *MapMatcher) getCandidates(coordinate model.Coordinate) []matchCandidate {
+	candidates := make([]matchCandidate, 0)
+
+	for _, edge := range m.Graph.GetNearbyEdges(coordinate, m.SearchRadius) {
+		distance, fraction := m.Graph.ProjectOnEdge(edge, coordinate)
+
+		if distance <= m.SearchRadius {
+			candidates = append(candidates, matchCandidate{
+				edge:     edge,
+				fraction: fraction,
+				distance: distance,
+			})
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].distance < candidates[j].distance
+	})
+
+	if len(candidates) > maxMatchCandidates {
+		candidates = candidates[:maxMatchCandidates]
+	}
+
+	return candidates
+}
+
+// transit calculates the viterbi scores of the step from the previous one. It returns
+// false if no candidate of the step can be reached from the previous candidates
+func (m *MapMatcher) transit(previous, step *matchStep, straightDistance float64) bool {
+	count := len(step.candidates)
+
+	step.scores = make([]float64, count)
+	step.previous = make([]int, count)
+	step.routes = make([]float64, count)
+
+	for i := range step.scores {
+		step.scores[i] = math.Inf(-1)
+	}
+
+	limit := straightDistance*routeLimitFactor + 2*m.SearchRadius
+
+	for i, from := range previous.candidates {
+		if math.IsInf(previous.scores[i], -1) {
+			continue
+		}
+
+		fromEdge := m.Graph.Edges[from.edge]
+		reachable := m.Graph.GetShortestDistances(fromEdge.To, limit)
+
+		for j, to := range step.candidates {
+			toEdge := m.Graph.Edges[to.edge]
+			route := math.Inf(1)
+
+			if from.edge == to.edge && to.fraction >= from.fraction {
+				route = (to.fraction - from.fraction) * fromEdge.Length
+			} else if distance, ok := reachable[toEdge.From]; ok {
+				route = (1-from.fraction)*fromEdge.Length + distance + to.fraction*toEdge.Length
+			}
+
+			if math.IsInf(route, 1) {
+				continue
+			}
+
+			score := previous.scores[i] + m.getEmission(to) - math.Abs(straightDistance-route)/m.Beta
+
+			if score > step.scores[j] {
+				step.scores[j] = score
+				step.previous[j] = i
+				step.routes[j] = route
+			}
+		}
+	}
+
+	for _, score := range step.scores {
+		if !math.IsInf(score, -1) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resolve backtracks the most likely road path of a chain and stores its segment distances
+func (m *MapMatcher) resolve(chain []*matchStep, distances []float64) {
+	if len(chain) < 2 {
+		return
+	}
+
+	last := chain[len(chain)-1]
+	best := 0
+
+	for i, score := range last.scores {
+		if score > last.scores[best] {
+			best = i
+		}
+	}
+
+	for index := len(chain) - 1; index > 0; index-- {
+		step := chain[index]
+		distances[step.coordinate-1] = step.routes[best]
+		best = step.previous[best]
+	}
+
+	log.WithFields(log.Fields{
+		"stage":       "map_matching",
+		"coordinates": len(chain),
+	}).Debug("Coordinates matched to the road network")
+}
+
+// getEmission gets the log probability of observing a coordinate from a candidate position
+func (m *MapMatcher) getEmission(candidate matchCandidate) float64 {
+	return -0.5 * math.Pow(candidate.distance/m.Sigma, 2)
+}
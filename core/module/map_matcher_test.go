@@ -0,0 +1,93 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package module
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"bitbucket.org/clivern/beat/core/model"
+	"bitbucket.org/clivern/beat/pkg"
+
+	"github.com/franela/goblin"
+)
+
+// TestMapMatcher test cases
+func TestMapMatcher(t *testing.T) {
+	baseDir := pkg.GetBaseDir("cache")
+	testDataDir := fmt.Sprintf("%s/%s", baseDir, "testdata")
+	cacheDir := fmt.Sprintf("%s/%s", baseDir, "cache")
+	pkg.LoadConfigs(fmt.Sprintf("%s/config.dist.yml", baseDir))
+
+	graph, _ := LoadRoadGraph(fmt.Sprintf("%s/test_roads_01.osm", testDataDir), cacheDir)
+
+	g := goblin.Goblin(t)
+
+	g.Describe("MapMatcher", func() {
+		g.It("It should bill the road distance around the corner", func() {
+			matcher := NewMapMatcher(graph)
+
+			coordinates := []model.Coordinate{
+				{Latitude: 52.37001, Longitude: 4.89010, Timestamp: time.Unix(1608034878, 0)},
+				{Latitude: 52.37590, Longitude: 4.89999, Timestamp: time.Unix(1608034998, 0)},
+			}
+
+			_, straightDistance := coordinates[0].GetDistance(coordinates[1])
+			distances := matcher.Match(coordinates)
+
+			g.Assert(len(distances)).Equal(1)
+			g.Assert(math.Round(straightDistance*100) / 100).Equal(0.94)
+			g.Assert(math.Round(distances[0]*100) / 100).Equal(1.33)
+		})
+
+		g.It("It should fall back to the straight line distance away from the roads", func() {
+			matcher := NewMapMatcher(graph)
+
+			coordinates := []model.Coordinate{
+				{Latitude: 52.37001, Longitude: 4.89010, Timestamp: time.Unix(1608034878, 0)},
+				{Latitude: 52.37002, Longitude: 4.89500, Timestamp: time.Unix(1608034938, 0)},
+				{Latitude: 52.38500, Longitude: 4.89500, Timestamp: time.Unix(1608035058, 0)},
+			}
+
+			_, straightDistance := coordinates[1].GetDistance(coordinates[2])
+			distances := matcher.Match(coordinates)
+
+			g.Assert(len(distances)).Equal(2)
+			g.Assert(math.Round(distances[0]*100) / 100).Equal(0.33)
+			g.Assert(distances[1]).Equal(straightDistance)
+		})
+
+		g.It("It should return no distances for a single coordinate", func() {
+			matcher := NewMapMatcher(graph)
+
+			g.Assert(len(matcher.Match([]model.Coordinate{{Latitude: 52.37001, Longitude: 4.89010}}))).Equal(0)
+		})
+	})
+}
+
+// BenchmarkMapMatcher benchmark
+func BenchmarkMapMatcher(b *testing.B) {
+	baseDir := pkg.GetBaseDir("cache")
+	pkg.LoadConfigs(fmt.Sprintf("%s/config.dist.yml", baseDir))
+
+	graph, _ := LoadRoadGraph(
+		fmt.Sprintf("%s/testdata/test_roads_01.osm", baseDir),
+		fmt.Sprintf("%s/cache", baseDir),
+	)
+
+	matcher := NewMapMatcher(graph)
+
+	coordinates := []model.Coordinate{
+		{Latitude: 52.37001, Longitude: 4.89010, Timestamp: time.Unix(1608034878, 0)},
+		{Latitude: 52.37002, Longitude: 4.89500, Timestamp: time.Unix(1608034938, 0)},
+		{Latitude: 52.37590, Longitude: 4.89999, Timestamp: time.Unix(1608034998, 0)},
+	}
+
+	for n := 0; n < b.N; n++ {
+		matcher.Match(coordinates)
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package module
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// stageStoreWait labels the time a result waits for the store stage, the time to
+// write a result is part of the store stage and isn't measured per ride
+const stageStoreWait = "store_wait"
+
+// Metrics struct type
+// The Prometheus metrics of a run, updated by the pipeline stages. The go_* metrics
+// like go_goroutines come from the Go collector. A nil metrics ignores all updates
+type Metrics struct {
+	registry            *prometheus.Registry
+	ridesRead           prometheus.Counter
+	ridesProcessed      prometheus.Counter
+	invalidLines        prometheus.Counter
+	coordinatesRejected *prometheus.CounterVec
+	coordinatesRemoved  prometheus.Counter
+	stageDuration       *prometheus.HistogramVec
+	queues              *queueCollector
+}
+
+// MetricsServer struct type
+// The HTTP server of the metrics and the pprof handlers
+type MetricsServer struct {
+	server   *http.Server
+	listener net.Listener
+}
+
+// queueCollector struct type
+// It collects the depth of the pipeline channels when the metrics are scraped
+type queueCollector struct {
+	mutex  sync.Mutex
+	desc   *prometheus.Desc
+	queues map[string]func() int
+}
+
+// NewMetrics creates a new instance of Metrics
+func NewMetrics() *Metrics {
+	metrics := &Metrics{
+		registry: prometheus.NewRegistry(),
+		ridesRead: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "beat_rides_read_total",
+			Help: "The number of rides read from the dataset files",
+		}),
+		ridesProcessed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "beat_rides_processed_total",
+			Help: "The number of rides with a calculated fare",
+		}),
+		invalidLines: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "beat_invalid_lines_total",
+			Help: "The number of skipped dataset lines without a valid ride id",
+		}),
+		coordinatesRejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "beat_coordinates_rejected_total",
+			Help: "The number of coordinates rejected by validation per rejection reason",
+		}, []string{"reason"}),
+		coordinatesRemoved: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "beat_coordinates_removed_total",
+			Help: "The number of coordinates removed by normalization",
+		}),
+		stageDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "beat_stage_duration_seconds",
+			Help: "The time to read a ride, to process a ride and for a result to wait for the store stage (store_wait)",
+			// From 10µs to about 40s
+			Buckets: prometheus.ExponentialBuckets(0.00001, 4, 12),
+		}, []string{"stage"}),
+		queues: &queueCollector{
+			desc: prometheus.NewDesc(
+				"beat_queue_depth",
+				"The number of items waiting in a pipeline channel",
+				[]string{"queue"},
+				nil,
+			),
+			queues: make(map[string]func() int),
+		},
+	}
+
+	metrics.registry.MustRegister(
+		metrics.ridesRead,
+		metrics.ridesProcessed,
+		metrics.invalidLines,
+		metrics.coordinatesRejected,
+		metrics.coordinatesRemoved,
+		metrics.stageDuration,
+		metrics.queues,
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+
+	return metrics
+}
+
+// Registry gets the registry of the metrics
+func (m *Metrics) Registry() *prometheus.Registry {
+	return m.registry
+}
+
+// StartMetricsServer serves the metrics on /metrics and the Go pprof handlers
+// on /debug/pprof/ in the background
+func StartMetricsServer(addr string, metrics *Metrics) (*MetricsServer, error) {
+	listener, err := net.Listen("tcp", addr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(metrics.registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	server := &MetricsServer{
+		server:   &http.Server{Handler: mux},
+		listener: listener,
+	}
+
+	go server.server.Serve(listener)
+
+	return server, nil
+}
+
+// Addr gets the address the server listens on
+func (s *MetricsServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Shutdown stops the server once the running requests are done or ctx is done
+func (s *MetricsServer) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// watchQueue reports the depth of a pipeline channel as the queue
+func (m *Metrics) watchQueue(name string, depth func() int) {
+	if m == nil {
+		return
+	}
+
+	m.queues.mutex.Lock()
+	defer m.queues.mutex.Unlock()
+
+	m.queues.queues[name] = depth
+}
+
+// addInvalidLine counts a skipped dataset line
+func (m *Metrics) addInvalidLine() {
+	if m != nil {
+		m.invalidLines.Inc()
+	}
+}
+
+// observeRead counts a read ride and the time to read it
+func (m *Metrics) observeRead(duration time.Duration) {
+	if m != nil {
+		m.ridesRead.Inc()
+		m.stageDuration.WithLabelValues(StageRead).Observe(duration.Seconds())
+	}
+}
+
+// track counts the processed rides and their removed coordinates while passing the results
+// through. It should be the last stage before storing as the time to send each result
+// is the time the result waits for the store stage, observed as store_wait
+func (m *Metrics) track(ctx context.Context, inputChannel <-chan RideResult) <-chan RideResult {
+	if m == nil {
+		return inputChannel
+	}
+
+	outChannel := make(chan RideResult)
+
+	go func() {
+		defer close(outChannel)
+
+		for result := range inputChannel {
+			m.ridesProcessed.Inc()
+			m.coordinatesRemoved.Add(float64(result.PointsRemoved - result.PointsRejected))
+
+			for reason, count := range result.RejectedBy {
+				m.coordinatesRejected.WithLabelValues(reason).Add(float64(count))
+			}
+
+			m.stageDuration.WithLabelValues(StageProcess).Observe(result.Duration.Seconds())
+
+			start := time.Now()
+
+			select {
+			case outChannel <- result:
+			case <-ctx.Done():
+				return
+			}
+
+			m.stageDuration.WithLabelValues(stageStoreWait).Observe(time.Since(start).Seconds())
+		}
+	}()
+
+	return outChannel
+}
+
+// Describe sends the description of the queue depth metric
+func (c *queueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect sends the current depth of each queue
+func (c *queueCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for name, depth := range c.queues {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(depth()), name)
+	}
+}
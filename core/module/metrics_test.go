@@ -0,0 +1,161 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package module
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"bitbucket.org/clivern/beat/core/model"
+	"bitbucket.org/clivern/beat/pkg"
+
+	"github.com/franela/goblin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// TestMetrics test cases
+func TestMetrics(t *testing.T) {
+	baseDir := pkg.GetBaseDir("cache")
+	testDataDir := fmt.Sprintf("%s/%s", baseDir, "testdata")
+	cacheDir := fmt.Sprintf("%s/%s", baseDir, "cache")
+	pkg.LoadConfigs(fmt.Sprintf("%s/config.dist.yml", baseDir))
+
+	g := goblin.Goblin(t)
+
+	g.Describe("Metrics", func() {
+		g.It("It should count the rides of a run", func() {
+			metrics := NewMetrics()
+			progress := NewProgress([]string{fmt.Sprintf("%s/test_paths_01.csv", testDataDir)})
+
+			err := (&Pipeline{
+				DatasetFiles: []string{fmt.Sprintf("%s/test_paths_01.csv", testDataDir)},
+				OutputFile:   fmt.Sprintf("%s/metrics_test01.csv", cacheDir),
+				Format:       "auto",
+				Compression:  CompressionAuto,
+				Loader:       CSVLoader{},
+				Processor:    &RideProcessor{},
+				Progress:     progress,
+				Metrics:      metrics,
+			}).Run(context.Background(), make(chan struct{}))
+			g.Assert(err).Equal(nil)
+
+			snapshot := progress.Snapshot()
+			g.Assert(testutil.ToFloat64(metrics.ridesRead)).Equal(float64(snapshot.RidesRead))
+			g.Assert(testutil.ToFloat64(metrics.ridesProcessed)).Equal(float64(snapshot.RidesPriced))
+			g.Assert(snapshot.RidesPriced > 0).Equal(true)
+
+			// Every ride has a read and a process latency and a store wait
+			count, err := testutil.GatherAndCount(metrics.Registry(), "beat_stage_duration_seconds")
+			g.Assert(err).Equal(nil)
+			g.Assert(count).Equal(3)
+
+			text := gatherText(metrics)
+
+			for _, stage := range []string{StageRead, StageProcess, stageStoreWait} {
+				g.Assert(strings.Contains(
+					text,
+					fmt.Sprintf("beat_stage_duration_seconds_count{stage=\"%s\"} %d", stage, snapshot.RidesPriced),
+				)).Equal(true)
+			}
+
+			g.Assert(strings.Contains(text, "beat_queue_depth{queue=\"rides\"} 0")).Equal(true)
+		})
+
+		g.It("It should report the results waiting to be stored", func() {
+			metrics := NewMetrics()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			channel, err := GenerateData(ctx, fmt.Sprintf("%s/test_paths_01.csv", testDataDir), CompressionAuto, CSVLoader{})
+			g.Assert(err).Equal(nil)
+
+			// The results are not read so they stay in the results buffer
+			processData(ctx, channel, &RideProcessor{}, NewWorkerPool(1), nil, metrics)
+
+			found := false
+
+			for i := 0; i < 100; i++ {
+				if strings.Contains(gatherText(metrics), "beat_queue_depth{queue=\"results\"} 10") {
+					found = true
+					break
+				}
+
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			g.Assert(found).Equal(true)
+		})
+
+		g.It("It should count the rejected coordinates per reason", func() {
+			metrics := NewMetrics()
+
+			for range metrics.track(context.Background(), sendResults([]RideResult{
+				{RideID: 1, PointsRemoved: 3, PointsRejected: 2, RejectedBy: map[string]int{model.RejectNullIsland: 2}},
+				{RideID: 2, PointsRemoved: 1, PointsRejected: 1, RejectedBy: map[string]int{model.RejectOldTimestamp: 1}},
+			})) {
+			}
+
+			g.Assert(testutil.ToFloat64(metrics.coordinatesRejected.WithLabelValues(model.RejectNullIsland))).Equal(2.0)
+			g.Assert(testutil.ToFloat64(metrics.coordinatesRejected.WithLabelValues(model.RejectOldTimestamp))).Equal(1.0)
+			g.Assert(testutil.ToFloat64(metrics.coordinatesRemoved)).Equal(1.0)
+		})
+
+		g.It("It should serve the metrics and the pprof handlers", func() {
+			server, err := StartMetricsServer("127.0.0.1:0", NewMetrics())
+			g.Assert(err).Equal(nil)
+
+			defer server.Shutdown(context.Background())
+
+			for path, content := range map[string]string{
+				"/metrics":      "go_goroutines",
+				"/debug/pprof/": "goroutine",
+			} {
+				response, err := http.Get(fmt.Sprintf("http://%s%s", server.Addr(), path))
+				g.Assert(err).Equal(nil)
+
+				body, err := ioutil.ReadAll(response.Body)
+				response.Body.Close()
+
+				g.Assert(err).Equal(nil)
+				g.Assert(response.StatusCode).Equal(http.StatusOK)
+				g.Assert(strings.Contains(string(body), content)).Equal(true)
+			}
+		})
+
+		g.It("It should ignore the updates of a nil metrics", func() {
+			var metrics *Metrics
+
+			metrics.addInvalidLine()
+			metrics.observeRead(0)
+			metrics.watchQueue("rides", func() int { return 0 })
+
+			channel := make(chan RideResult)
+			g.Assert(metrics.track(context.Background(), channel) == channel).Equal(true)
+		})
+	})
+}
+
+// gatherText gets the metrics in the Prometheus text format
+func gatherText(metrics *Metrics) string {
+	server, _ := StartMetricsServer("127.0.0.1:0", metrics)
+	defer server.Shutdown(context.Background())
+
+	response, err := http.Get(fmt.Sprintf("http://%s/metrics", server.Addr()))
+
+	if err != nil {
+		return ""
+	}
+
+	defer response.Body.Close()
+
+	body, _ := ioutil.ReadAll(response.Body)
+
+	return string(body)
+}
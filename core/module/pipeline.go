@@ -0,0 +1,126 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package module
+
+import (
+	"context"
+
+	"github.com/spf13/viper"
+	"golang.org/x/sync/errgroup"
+)
+
+// Pipeline struct type
+// It reads the dataset files, calculates the rides fares and stores the results.
+// The results go to OutputFile or to one of OutputFiles per dataset file if set. With
+// a checkpoint, reading starts from the checkpoint position and the results are
+// appended to the partial output file of the checkpoint. A single uncompressed dataset
+// file is split into shards read at the same time unless a checkpoint is set. The
+// progress, the stats and the metrics are optional
+type Pipeline struct {
+	DatasetFiles []string
+	OutputFile   string
+	OutputFiles  []string
+	Format       string
+	Compression  string
+	Loader       RideLoader
+	Processor    *RideProcessor
+	Checkpoint   *Checkpoint
+	Progress     *Progress
+	Stats        *RunStats
+	Metrics      *Metrics
+}
+
+// Run runs the pipeline stages in an error group so the first fatal error stops
+// all of them and discards the results. Closing stop stops reading the dataset,
+// the rides already read are still calculated and stored. Canceling ctx aborts
+// the run without storing the results
+func (p *Pipeline) Run(ctx context.Context, stop <-chan struct{}) error {
+	var start Position
+	var shards []datasetShard
+	var dataset *datasetReader
+	var err error
+
+	// The checkpoint position needs the rides read in the dataset order
+	if p.Checkpoint != nil {
+		start = p.Checkpoint.Position
+	} else {
+		shards, err = splitDataset(p.DatasetFiles, p.Compression, p.Loader)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if shards == nil {
+		dataset, err = openDatasets(p.DatasetFiles, p.Compression, p.Loader, start, p.Progress)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	pool := NewWorkerPoolFromConfig()
+
+	var order *rideOrder
+
+	if shards != nil && p.Processor.orderedResults() {
+		order = newRideOrder(len(shards), reorderBufferSize(pool, len(shards)))
+	}
+
+	group, ctx := errgroup.WithContext(ctx)
+
+	readCtx, stopReading := context.WithCancel(ctx)
+	defer stopReading()
+
+	go func() {
+		select {
+		case <-stop:
+			stopReading()
+		case <-readCtx.Done():
+		}
+	}()
+
+	batches := make(chan RideBatch, viper.GetInt("workers.buffers.rides"))
+
+	// The stages run at the same time, each one ends once its input is done
+	p.Stats.StartStage(StageRead)
+	p.Stats.StartStage(StageProcess)
+	p.Stats.StartStage(StageStore)
+
+	group.Go(func() error {
+		defer p.Stats.EndStage(StageRead)
+
+		if shards != nil {
+			return readShards(readCtx, p.DatasetFiles[0], p.Loader, shards, order, p.Progress, p.Metrics, batches)
+		}
+
+		return readDatasets(readCtx, p.DatasetFiles, p.Compression, p.Loader, dataset, p.Progress, p.Metrics, batches)
+	})
+
+	results := p.Progress.track(ctx, processData(ctx, batches, p.Processor, pool, order, p.Metrics))
+	results = p.Stats.track(ctx, results)
+	results = p.Metrics.track(ctx, results)
+
+	p.Metrics.watchQueue("rides", func() int { return len(batches) })
+
+	group.Go(func() error {
+		defer p.Stats.EndStage(StageStore)
+
+		if p.Checkpoint != nil {
+			// The results channel is closed before the dataset end only if reading stopped
+			return StoreDataWithCheckpoints(ctx, p.Checkpoint, results, func() bool {
+				return readCtx.Err() != nil
+			})
+		}
+
+		if len(p.OutputFiles) > 0 {
+			return StoreDataPerInput(ctx, p.OutputFiles, p.Format, p.Compression, results)
+		}
+
+		return StoreData(ctx, p.OutputFile, p.Format, p.Compression, results)
+	})
+
+	return group.Wait()
+}
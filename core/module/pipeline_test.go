@@ -0,0 +1,222 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package module
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"bitbucket.org/clivern/beat/core/util"
+	"bitbucket.org/clivern/beat/pkg"
+
+	"github.com/franela/goblin"
+)
+
+// TestPipeline test cases
+func TestPipeline(t *testing.T) {
+	baseDir := pkg.GetBaseDir("cache")
+	testDataDir := fmt.Sprintf("%s/%s", baseDir, "testdata")
+	cacheDir := fmt.Sprintf("%s/%s", baseDir, "cache")
+	pkg.LoadConfigs(fmt.Sprintf("%s/config.dist.yml", baseDir))
+
+	g := goblin.Goblin(t)
+
+	newPipeline := func(outputFile string) *Pipeline {
+		return &Pipeline{
+			DatasetFiles: []string{fmt.Sprintf("%s/test_paths_01.csv", testDataDir)},
+			OutputFile:   outputFile,
+			Format:       "auto",
+			Compression:  CompressionAuto,
+			Loader:       CSVLoader{},
+			Processor:    &RideProcessor{},
+		}
+	}
+
+	// run fails the test if the pipeline doesn't return in time
+	run := func(pipeline *Pipeline, ctx context.Context, stop <-chan struct{}) error {
+		done := make(chan error, 1)
+
+		go func() {
+			done <- pipeline.Run(ctx, stop)
+		}()
+
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(10 * time.Second):
+			return fmt.Errorf("The pipeline is blocked")
+		}
+	}
+
+	g.Describe("Pipeline", func() {
+		g.It("It should store all the rides", func() {
+			outputFile := fmt.Sprintf("%s/pipeline_test01.csv", cacheDir)
+
+			err := run(newPipeline(outputFile), context.Background(), make(chan struct{}))
+			g.Assert(err).Equal(nil)
+
+			content, err := util.ReadFile(outputFile)
+			g.Assert(err).Equal(nil)
+			g.Assert(strings.Count(content, "\n")).Equal(10)
+		})
+
+		g.It("It should store the rides read before a stop", func() {
+			outputFile := fmt.Sprintf("%s/pipeline_test02.csv", cacheDir)
+			os.Remove(outputFile)
+
+			stop := make(chan struct{})
+			close(stop)
+
+			err := run(newPipeline(outputFile), context.Background(), stop)
+			g.Assert(err).Equal(nil)
+
+			content, err := util.ReadFile(outputFile)
+			g.Assert(err).Equal(nil)
+			g.Assert(strings.Count(content, "\n") <= 10).Equal(true)
+		})
+
+		g.It("It should discard the results once canceled", func() {
+			outputFile := fmt.Sprintf("%s/pipeline_test03.csv", cacheDir)
+			os.Remove(outputFile)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			err := run(newPipeline(outputFile), ctx, make(chan struct{}))
+			g.Assert(err).Equal(context.Canceled)
+			g.Assert(util.FileExists(outputFile)).Equal(false)
+		})
+
+		g.It("It should stop reading once storing fails", func() {
+			err := run(newPipeline(fmt.Sprintf("%s/not_found/pipeline_test04.csv", cacheDir)), context.Background(), make(chan struct{}))
+			g.Assert(err != nil).Equal(true)
+			g.Assert(err.Error() != "The pipeline is blocked").Equal(true)
+		})
+
+		g.It("It should fail for a missing dataset file", func() {
+			pipeline := newPipeline(fmt.Sprintf("%s/pipeline_test05.csv", cacheDir))
+			pipeline.DatasetFiles = []string{fmt.Sprintf("%s/not_found.csv", testDataDir)}
+
+			g.Assert(run(pipeline, context.Background(), make(chan struct{})) != nil).Equal(true)
+		})
+
+		g.It("It should fail for a truncated compressed dataset file", func() {
+			content, err := ioutil.ReadFile(fmt.Sprintf("%s/test_paths_01.csv", testDataDir))
+			g.Assert(err).Equal(nil)
+
+			var buffer bytes.Buffer
+
+			compressor := gzip.NewWriter(&buffer)
+			compressor.Write(content)
+			compressor.Close()
+
+			datasetFile := fmt.Sprintf("%s/pipeline_test06.csv.gz", cacheDir)
+			g.Assert(ioutil.WriteFile(datasetFile, buffer.Bytes()[:buffer.Len()/2], 0644)).Equal(nil)
+
+			outputFile := fmt.Sprintf("%s/pipeline_test06.csv", cacheDir)
+			os.Remove(outputFile)
+
+			pipeline := newPipeline(outputFile)
+			pipeline.DatasetFiles = []string{datasetFile}
+
+			err = run(pipeline, context.Background(), make(chan struct{}))
+			g.Assert(err != nil).Equal(true)
+			g.Assert(strings.Contains(err.Error(), "unexpected EOF")).Equal(true)
+			g.Assert(util.FileExists(outputFile)).Equal(false)
+		})
+	})
+}
+
+// benchmarkRows gets the number of rows of the synthetic benchmark dataset from
+// BEAT_BENCHMARK_ROWS, 100000 by default so make test stays fast
+func benchmarkRows() int {
+	rows, err := strconv.Atoi(os.Getenv("BEAT_BENCHMARK_ROWS"))
+
+	if err != nil || rows <= 0 {
+		return 100000
+	}
+
+	return rows
+}
+
+// syntheticDataset gets a CSV dataset of rows coordinates in rides of 50 coordinates
+// 10 seconds apart. The file is written to the cache dir once and reused
+func syntheticDataset(b *testing.B, rows int) string {
+	baseDir := pkg.GetBaseDir("cache")
+	filePath := fmt.Sprintf("%s/cache/synthetic_%d.csv", baseDir, rows)
+
+	if util.FileExists(filePath) {
+		return filePath
+	}
+
+	file, err := createAtomicFile(filePath)
+
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	writer := bufio.NewWriterSize(file, 1<<20)
+	random := rand.New(rand.NewSource(1))
+	latitude, longitude := 37.966660, 23.728308
+
+	for row := 0; row < rows; row++ {
+		// Move up to about 100 meters between two coordinates
+		latitude += (random.Float64() - 0.5) * 0.002
+		longitude += (random.Float64() - 0.5) * 0.002
+
+		fmt.Fprintf(writer, "%d,%.6f,%.6f,%d\n", row/50+1, latitude, longitude, 1405594957+int64(row)*10)
+	}
+
+	if err := writer.Flush(); err != nil {
+		file.Abort()
+		b.Fatal(err)
+	}
+
+	if err := file.Close(); err != nil {
+		b.Fatal(err)
+	}
+
+	return filePath
+}
+
+// BenchmarkPipeline benchmark the rows per second of a run over the synthetic dataset
+func BenchmarkPipeline(b *testing.B) {
+	baseDir := pkg.GetBaseDir("cache")
+	pkg.LoadConfigs(fmt.Sprintf("%s/config.dist.yml", baseDir))
+
+	rows := benchmarkRows()
+	filePath := syntheticDataset(b, rows)
+
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		start := time.Now()
+
+		err := (&Pipeline{
+			DatasetFiles: []string{filePath},
+			OutputFile:   fmt.Sprintf("%s/cache/benchmark_pipeline.csv", baseDir),
+			Format:       "auto",
+			Compression:  CompressionAuto,
+			Loader:       CSVLoader{},
+			Processor:    &RideProcessor{},
+		}).Run(context.Background(), make(chan struct{}))
+
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.ReportMetric(float64(rows)/time.Since(start).Seconds(), "rows/s")
+	}
+}
@@ -0,0 +1,296 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package module
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Progress struct type
+// The counters of a run, updated by the pipeline stages while the progress report
+// reads them. The bytes are read from the dataset files before decompression
+// A nil progress ignores all updates
+type Progress struct {
+	totalBytes  int64
+	bytesRead   int64
+	ridesRead   int64
+	ridesPriced int64
+	rejected    int64
+	start       time.Time
+}
+
+// ProgressSnapshot struct type
+// The counters of a run at a point in time. The rejected rows are the invalid
+// dataset lines and the coordinates removed by validation and normalization
+type ProgressSnapshot struct {
+	TotalBytes  int64
+	BytesRead   int64
+	RidesRead   int64
+	RidesPriced int64
+	Rejected    int64
+	Elapsed     time.Duration
+}
+
+// progressFile struct type
+// A dataset file that adds the read bytes to the progress
+type progressFile struct {
+	*os.File
+	progress *Progress
+}
+
+// NewProgress creates a new instance of Progress, the total bytes is the size of the
+// dataset files. It is unknown for the standard input
+func NewProgress(filePaths []string) *Progress {
+	progress := &Progress{start: time.Now()}
+
+	for _, filePath := range filePaths {
+		if filePath == StdStream {
+			continue
+		}
+
+		if info, err := os.Stat(filePath); err == nil {
+			progress.totalBytes += info.Size()
+		}
+	}
+
+	return progress
+}
+
+// Snapshot gets the current counters
+func (p *Progress) Snapshot() ProgressSnapshot {
+	return ProgressSnapshot{
+		TotalBytes:  atomic.LoadInt64(&p.totalBytes),
+		BytesRead:   atomic.LoadInt64(&p.bytesRead),
+		RidesRead:   atomic.LoadInt64(&p.ridesRead),
+		RidesPriced: atomic.LoadInt64(&p.ridesPriced),
+		Rejected:    atomic.LoadInt64(&p.rejected),
+		Elapsed:     time.Since(p.start),
+	}
+}
+
+// RidesPerSecond gets the rate of the priced rides
+func (s ProgressSnapshot) RidesPerSecond() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+
+	return float64(s.RidesPriced) / s.Elapsed.Seconds()
+}
+
+// ETA gets the estimated remaining time from the rate of the read bytes
+// ok is false if the total bytes is unknown or nothing is read yet
+func (s ProgressSnapshot) ETA() (time.Duration, bool) {
+	if s.TotalBytes <= 0 || s.BytesRead <= 0 {
+		return 0, false
+	}
+
+	if s.BytesRead >= s.TotalBytes {
+		return 0, true
+	}
+
+	remaining := float64(s.Elapsed) * float64(s.TotalBytes-s.BytesRead) / float64(s.BytesRead)
+
+	return time.Duration(remaining).Round(time.Second), true
+}
+
+// String formats the counters as one line
+func (s ProgressSnapshot) String() string {
+	parts := make([]string, 0, 6)
+
+	if s.TotalBytes > 0 {
+		parts = append(parts, fmt.Sprintf(
+			"%s / %s (%.1f%%)",
+			formatBytes(s.BytesRead),
+			formatBytes(s.TotalBytes),
+			100*float64(s.BytesRead)/float64(s.TotalBytes),
+		))
+	} else {
+		parts = append(parts, formatBytes(s.BytesRead))
+	}
+
+	parts = append(parts,
+		fmt.Sprintf("%d rides read", s.RidesRead),
+		fmt.Sprintf("%d priced", s.RidesPriced),
+		fmt.Sprintf("%.0f rides/s", s.RidesPerSecond()),
+		fmt.Sprintf("%d rejected", s.Rejected),
+	)
+
+	if eta, ok := s.ETA(); ok {
+		parts = append(parts, fmt.Sprintf("ETA %s", eta))
+	}
+
+	return strings.Join(parts, " | ")
+}
+
+// ReportProgress writes the progress to the writer every interval till ctx is done then
+// writes the last progress. On a terminal the progress line is redrawn in place
+func ReportProgress(ctx context.Context, progress *Progress, writer io.Writer, terminal bool, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			writeProgress(writer, progress.Snapshot(), terminal)
+		case <-ctx.Done():
+			writeProgress(writer, progress.Snapshot(), terminal)
+
+			if terminal {
+				fmt.Fprintln(writer)
+			}
+
+			return
+		}
+	}
+}
+
+// IsTerminal checks if the file is a terminal
+func IsTerminal(file *os.File) bool {
+	info, err := file.Stat()
+
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// writeProgress writes a progress line, the line is redrawn on a terminal
+// and prefixed with the time otherwise
+func writeProgress(writer io.Writer, snapshot ProgressSnapshot, terminal bool) {
+	if terminal {
+		// Clear the rest of the previous line
+		fmt.Fprintf(writer, "\r%s\033[K", snapshot)
+		return
+	}
+
+	fmt.Fprintf(writer, "%s %s\n", time.Now().UTC().Format(time.RFC3339), snapshot)
+}
+
+// formatBytes formats a size in bytes with a binary unit
+func formatBytes(size int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	value := float64(size)
+	unit := 0
+
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+
+	if unit == 0 {
+		return fmt.Sprintf("%d %s", size, units[unit])
+	}
+
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
+
+// openFile opens a dataset file like OpenCompressedFile and counts the bytes
+// read from the file before decompression
+func (p *Progress) openFile(filePath, compression string) (io.ReadCloser, error) {
+	if p == nil || filePath == StdStream {
+		return OpenCompressedFile(filePath, compression)
+	}
+
+	compression, err := ResolveCompression(filePath, compression)
+
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(filePath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewCompressedReader(&progressFile{File: file, progress: p}, compression)
+}
+
+// track counts the priced rides and their removed coordinates while
+// passing the results through
+func (p *Progress) track(ctx context.Context, inputChannel <-chan RideResult) <-chan RideResult {
+	if p == nil {
+		return inputChannel
+	}
+
+	outChannel := make(chan RideResult)
+
+	go func() {
+		defer close(outChannel)
+
+		for result := range inputChannel {
+			p.addRidesPriced(1)
+			p.addRejected(int64(result.PointsRemoved))
+
+			select {
+			case outChannel <- result:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return outChannel
+}
+
+// addBytes adds read bytes
+func (p *Progress) addBytes(n int64) {
+	if p != nil {
+		atomic.AddInt64(&p.bytesRead, n)
+	}
+}
+
+// addRidesRead adds read rides
+func (p *Progress) addRidesRead(n int64) {
+	if p != nil {
+		atomic.AddInt64(&p.ridesRead, n)
+	}
+}
+
+// addRidesPriced adds priced rides
+func (p *Progress) addRidesPriced(n int64) {
+	if p != nil {
+		atomic.AddInt64(&p.ridesPriced, n)
+	}
+}
+
+// addRejected adds rejected rows
+func (p *Progress) addRejected(n int64) {
+	if p != nil {
+		atomic.AddInt64(&p.rejected, n)
+	}
+}
+
+// Read reads from the file and adds the read bytes to the progress
+func (f *progressFile) Read(b []byte) (int, error) {
+	n, err := f.File.Read(b)
+	f.progress.addBytes(int64(n))
+
+	return n, err
+}
+
+// Seek moves the file offset and adds the skipped bytes to the progress
+func (f *progressFile) Seek(offset int64, whence int) (int64, error) {
+	before, err := f.File.Seek(0, io.SeekCurrent)
+
+	if err != nil {
+		return 0, err
+	}
+
+	after, err := f.File.Seek(offset, whence)
+
+	if err == nil {
+		f.progress.addBytes(after - before)
+	}
+
+	return after, err
+}
@@ -0,0 +1,99 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package module
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"bitbucket.org/clivern/beat/pkg"
+
+	"github.com/franela/goblin"
+)
+
+// TestProgress test cases
+func TestProgress(t *testing.T) {
+	baseDir := pkg.GetBaseDir("cache")
+	testDataDir := fmt.Sprintf("%s/%s", baseDir, "testdata")
+	cacheDir := fmt.Sprintf("%s/%s", baseDir, "cache")
+	pkg.LoadConfigs(fmt.Sprintf("%s/config.dist.yml", baseDir))
+
+	g := goblin.Goblin(t)
+
+	g.Describe("Progress", func() {
+		g.It("It should count the bytes and the rides of a run", func() {
+			for _, fileName := range []string{"test_paths_01.csv", "test_paths_02.csv.gz"} {
+				filePath := fmt.Sprintf("%s/%s", testDataDir, fileName)
+
+				info, err := os.Stat(filePath)
+				g.Assert(err).Equal(nil)
+
+				progress := NewProgress([]string{filePath})
+
+				err = (&Pipeline{
+					DatasetFiles: []string{filePath},
+					OutputFile:   fmt.Sprintf("%s/progress_test01.csv", cacheDir),
+					Format:       "auto",
+					Compression:  CompressionAuto,
+					Loader:       CSVLoader{},
+					Processor:    &RideProcessor{},
+					Progress:     progress,
+				}).Run(context.Background(), make(chan struct{}))
+				g.Assert(err).Equal(nil)
+
+				snapshot := progress.Snapshot()
+				g.Assert(snapshot.TotalBytes).Equal(info.Size())
+				g.Assert(snapshot.BytesRead).Equal(info.Size())
+				g.Assert(snapshot.RidesRead).Equal(snapshot.RidesPriced)
+				g.Assert(snapshot.RidesPriced > 0).Equal(true)
+			}
+		})
+
+		g.It("It should estimate the remaining time", func() {
+			snapshot := ProgressSnapshot{
+				TotalBytes:  4096,
+				BytesRead:   1024,
+				RidesRead:   120,
+				RidesPriced: 100,
+				Rejected:    3,
+				Elapsed:     10 * time.Second,
+			}
+
+			eta, ok := snapshot.ETA()
+			g.Assert(ok).Equal(true)
+			g.Assert(eta).Equal(30 * time.Second)
+			g.Assert(snapshot.RidesPerSecond()).Equal(float64(10))
+			g.Assert(snapshot.String()).Equal("1.0 KB / 4.0 KB (25.0%) | 120 rides read | 100 priced | 10 rides/s | 3 rejected | ETA 30s")
+
+			// The size of the standard input is unknown
+			snapshot.TotalBytes = 0
+
+			_, ok = snapshot.ETA()
+			g.Assert(ok).Equal(false)
+			g.Assert(strings.HasPrefix(snapshot.String(), "1.0 KB | ")).Equal(true)
+		})
+
+		g.It("It should write a progress line per interval", func() {
+			var output bytes.Buffer
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			ReportProgress(ctx, NewProgress([]string{StdStream}), &output, false, time.Hour)
+			g.Assert(strings.Count(output.String(), "\n")).Equal(1)
+			g.Assert(strings.Contains(output.String(), "0 rides read")).Equal(true)
+
+			output.Reset()
+
+			ReportProgress(ctx, NewProgress([]string{StdStream}), &output, true, time.Hour)
+			g.Assert(strings.HasPrefix(output.String(), "\r0 B")).Equal(true)
+		})
+	})
+}
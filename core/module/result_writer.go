@@ -0,0 +1,267 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package module
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/viper"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+const (
+	// OutputCSV for CSV output files
+	OutputCSV = "csv"
+	// OutputJSON for JSON array output files
+	OutputJSON = "json"
+	// OutputJSONL for JSON Lines output files
+	OutputJSONL = "jsonl"
+	// OutputParquet for Parquet output files
+	OutputParquet = "parquet"
+	// OutputSQLite for SQLite databases
+	OutputSQLite = "sqlite"
+)
+
+// ResultWriter interface
+// Close flushes the results and replaces the output file, Abort discards them
+type ResultWriter interface {
+	Write(RideResult) error
+	Close() error
+	Abort() error
+}
+
+// resultRow struct type
+// The fields of a ride result in the output files
+type resultRow struct {
+	ID   int64   `json:"id" parquet:"name=id, type=INT64"`
+	Fare float64 `json:"fare" parquet:"name=fare, type=DOUBLE"`
+}
+
+// CSVWriter struct type
+type CSVWriter struct {
+	writer io.WriteCloser
+	header bool
+}
+
+// JSONWriter struct type
+// It writes the results as one JSON array
+type JSONWriter struct {
+	writer io.WriteCloser
+	count  int
+}
+
+// JSONLWriter struct type
+// It writes a JSON object per line
+type JSONLWriter struct {
+	writer io.WriteCloser
+}
+
+// ParquetWriter struct type
+type ParquetWriter struct {
+	writer  io.WriteCloser
+	parquet *writer.ParquetWriter
+}
+
+// NewResultWriter creates a result writer for an output format (csv, json, jsonl or parquet)
+// SQLite databases are created with NewSQLiteWriter
+func NewResultWriter(format string, w io.WriteCloser) (ResultWriter, error) {
+	switch format {
+	case OutputCSV:
+		return NewCSVWriter(w, viper.GetBool("output.csv_header"))
+	case OutputJSON:
+		return NewJSONWriter(w)
+	case OutputJSONL:
+		return &JSONLWriter{writer: w}, nil
+	case OutputParquet:
+		return NewParquetWriter(w)
+	case OutputSQLite:
+		return nil, fmt.Errorf("SQLite output is written to a database file not a stream")
+	}
+
+	return nil, fmt.Errorf("Invalid output format %s", format)
+}
+
+// DetectOutputFormat detects the output format from the file extension, CSV is the default
+func DetectOutputFormat(filePath string) string {
+	switch strings.ToLower(filepath.Ext(TrimCompressionExt(filePath))) {
+	case ".json":
+		return OutputJSON
+	case ".jsonl", ".ndjson":
+		return OutputJSONL
+	case ".parquet":
+		return OutputParquet
+	case ".db", ".sqlite", ".sqlite3":
+		return OutputSQLite
+	}
+
+	return OutputCSV
+}
+
+// ResolveOutputFormat gets the output format of a file, the format is
+// detected from the file extension if it is empty or auto
+func ResolveOutputFormat(filePath, format string) (string, error) {
+	switch format {
+	case "", "auto":
+		return DetectOutputFormat(filePath), nil
+	case OutputCSV, OutputJSON, OutputJSONL, OutputParquet, OutputSQLite:
+		return format, nil
+	}
+
+	return "", fmt.Errorf("Invalid output format %s", format)
+}
+
+// NewCSVWriter creates a new instance of CSVWriter and writes the header row if enabled
+func NewCSVWriter(w io.WriteCloser, header bool) (*CSVWriter, error) {
+	if header {
+		if _, err := io.WriteString(w, "id,fare\n"); err != nil {
+			return nil, err
+		}
+	}
+
+	return &CSVWriter{writer: w, header: header}, nil
+}
+
+// Write writes the ride id and fare as a CSV line
+func (c *CSVWriter) Write(result RideResult) error {
+	_, err := io.WriteString(c.writer, fmt.Sprintf("%d,%.2f\n", result.RideID, result.Fare))
+
+	return err
+}
+
+// Close flushes the results
+func (c *CSVWriter) Close() error {
+	return c.writer.Close()
+}
+
+// Abort discards the results
+func (c *CSVWriter) Abort() error {
+	return AbortWriter(c.writer)
+}
+
+// NewJSONWriter creates a new instance of JSONWriter and opens the JSON array
+func NewJSONWriter(w io.WriteCloser) (*JSONWriter, error) {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return nil, err
+	}
+
+	return &JSONWriter{writer: w}, nil
+}
+
+// Write writes the result as an item of the JSON array
+func (j *JSONWriter) Write(result RideResult) error {
+	data, err := json.Marshal(newResultRow(result))
+
+	if err != nil {
+		return err
+	}
+
+	separator := ",\n"
+
+	if j.count == 0 {
+		separator = "\n"
+	}
+
+	j.count++
+
+	_, err = io.WriteString(j.writer, separator+string(data))
+
+	return err
+}
+
+// Close closes the JSON array and flushes the results
+func (j *JSONWriter) Close() error {
+	end := "\n]\n"
+
+	if j.count == 0 {
+		end = "]\n"
+	}
+
+	if _, err := io.WriteString(j.writer, end); err != nil {
+		AbortWriter(j.writer)
+		return err
+	}
+
+	return j.writer.Close()
+}
+
+// Abort discards the results
+func (j *JSONWriter) Abort() error {
+	return AbortWriter(j.writer)
+}
+
+// Write writes the result as a JSON line
+func (j *JSONLWriter) Write(result RideResult) error {
+	data, err := json.Marshal(newResultRow(result))
+
+	if err != nil {
+		return err
+	}
+
+	_, err = j.writer.Write(append(data, '\n'))
+
+	return err
+}
+
+// Close flushes the results
+func (j *JSONLWriter) Close() error {
+	return j.writer.Close()
+}
+
+// Abort discards the results
+func (j *JSONLWriter) Abort() error {
+	return AbortWriter(j.writer)
+}
+
+// NewParquetWriter creates a new instance of ParquetWriter
+// Rows are buffered in row groups of output.parquet.row_group_size MB
+func NewParquetWriter(w io.WriteCloser) (*ParquetWriter, error) {
+	pw, err := writer.NewParquetWriterFromWriter(w, new(resultRow), 1)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if size := viper.GetInt64("output.parquet.row_group_size"); size > 0 {
+		pw.RowGroupSize = size * 1024 * 1024
+	}
+
+	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+
+	return &ParquetWriter{writer: w, parquet: pw}, nil
+}
+
+// Write adds the result to the current row group
+func (p *ParquetWriter) Write(result RideResult) error {
+	return p.parquet.Write(newResultRow(result))
+}
+
+// Close writes the last row group and the file footer then flushes the results
+func (p *ParquetWriter) Close() error {
+	if err := p.parquet.WriteStop(); err != nil {
+		AbortWriter(p.writer)
+		return err
+	}
+
+	return p.writer.Close()
+}
+
+// Abort discards the results
+func (p *ParquetWriter) Abort() error {
+	return AbortWriter(p.writer)
+}
+
+// newResultRow gets the output fields of a result, the fare is rounded to cents
+func newResultRow(result RideResult) resultRow {
+	return resultRow{
+		ID:   int64(result.RideID),
+		Fare: math.Round(result.Fare*100) / 100,
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package module
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/franela/goblin"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+// TestResultWriter test cases
+func TestResultWriter(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	results := []RideResult{
+		{RideID: 1, Fare: 3.47},
+		{RideID: 2, Fare: 58.304},
+	}
+
+	g.Describe("ResultWriter", func() {
+		g.It("It should detect the output format from the file extension", func() {
+			var tests = []struct {
+				filePath   string
+				wantFormat string
+			}{
+				{"fares.csv", OutputCSV},
+				{"fares.json", OutputJSON},
+				{"fares.jsonl.gz", OutputJSONL},
+				{"fares.ndjson", OutputJSONL},
+				{"fares.parquet", OutputParquet},
+				{StdStream, OutputCSV},
+			}
+
+			for _, tt := range tests {
+				g.Assert(DetectOutputFormat(tt.filePath)).Equal(tt.wantFormat)
+			}
+
+			_, err := ResolveOutputFormat("fares.csv", "xml")
+			g.Assert(err != nil).Equal(true)
+		})
+
+		g.It("It should write CSV with and without a header", func() {
+			var tests = []struct {
+				header      bool
+				wantContent string
+			}{
+				{false, "1,3.47\n2,58.30\n"},
+				{true, "id,fare\n1,3.47\n2,58.30\n"},
+			}
+
+			for _, tt := range tests {
+				var output bytes.Buffer
+
+				writer, err := NewCSVWriter(nopWriteCloser{&output}, tt.header)
+				g.Assert(err).Equal(nil)
+
+				for _, result := range results {
+					g.Assert(writer.Write(result)).Equal(nil)
+				}
+
+				g.Assert(writer.Close()).Equal(nil)
+				g.Assert(output.String()).Equal(tt.wantContent)
+			}
+		})
+
+		g.It("It should write a JSON array", func() {
+			var output bytes.Buffer
+
+			writer, err := NewJSONWriter(nopWriteCloser{&output})
+			g.Assert(err).Equal(nil)
+
+			for _, result := range results {
+				g.Assert(writer.Write(result)).Equal(nil)
+			}
+
+			g.Assert(writer.Close()).Equal(nil)
+			g.Assert(output.String()).Equal("[\n{\"id\":1,\"fare\":3.47},\n{\"id\":2,\"fare\":58.3}\n]\n")
+
+			output.Reset()
+
+			writer, err = NewJSONWriter(nopWriteCloser{&output})
+			g.Assert(err).Equal(nil)
+			g.Assert(writer.Close()).Equal(nil)
+			g.Assert(output.String()).Equal("[]\n")
+		})
+
+		g.It("It should write JSON Lines", func() {
+			var output bytes.Buffer
+
+			writer, err := NewResultWriter(OutputJSONL, nopWriteCloser{&output})
+			g.Assert(err).Equal(nil)
+
+			for _, result := range results {
+				g.Assert(writer.Write(result)).Equal(nil)
+			}
+
+			g.Assert(writer.Close()).Equal(nil)
+			g.Assert(output.String()).Equal("{\"id\":1,\"fare\":3.47}\n{\"id\":2,\"fare\":58.3}\n")
+		})
+
+		g.It("It should write Parquet", func() {
+			var output bytes.Buffer
+
+			writer, err := NewParquetWriter(nopWriteCloser{&output})
+			g.Assert(err).Equal(nil)
+
+			for _, result := range results {
+				g.Assert(writer.Write(result)).Equal(nil)
+			}
+
+			g.Assert(writer.Close()).Equal(nil)
+
+			file, err := buffer.NewBufferFile(output.Bytes())
+			g.Assert(err).Equal(nil)
+
+			pr, err := reader.NewParquetReader(file, new(resultRow), 1)
+			g.Assert(err).Equal(nil)
+			g.Assert(pr.GetNumRows()).Equal(int64(2))
+
+			rows := make([]resultRow, 2)
+			g.Assert(pr.Read(&rows)).Equal(nil)
+			pr.ReadStop()
+
+			g.Assert(rows).Equal([]resultRow{{ID: 1, Fare: 3.47}, {ID: 2, Fare: 58.3}})
+		})
+	})
+}
@@ -5,62 +5,367 @@
 package module
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"bitbucket.org/clivern/beat/core/model"
 	"bitbucket.org/clivern/beat/core/util"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	// FormatCSV for CSV datasets
+	FormatCSV = "csv"
+	// FormatJSONL for JSON Lines datasets
+	FormatJSONL = "jsonl"
 )
 
+// HeaderAuto detects whether the first row of a CSV dataset is a header
+const HeaderAuto = "auto"
+
+// maxHeaderSize is the max size of a CSV header row
+const maxHeaderSize = 4096
+
+// maxSniffSize is the size of the standard input data used to detect the format
+const maxSniffSize = 4096
+
+// csvFields are the ride fields in the default CSV columns order
+var csvFields = []string{"id", "latitude", "longitude", "timestamp"}
+
 // RideLoader interface
+// ParseLine parses a dataset line into the ride id and the coordinate so
+// each line is parsed once while reading the dataset
 type RideLoader interface {
 	Load(*model.Ride, string) (*model.Ride, error)
+	GetRideID(string) (string, error)
+	ParseLine(string) (int, model.Coordinate, error)
+}
+
+// HeaderParser interface is implemented by the loaders of datasets with a header row
+type HeaderParser interface {
+	ParseHeader(*bufio.Reader) error
+}
+
+// RecordJoiner interface is implemented by the loaders of datasets with records
+// that may span multiple lines
+type RecordJoiner interface {
+	IsCompleteRecord(string) bool
 }
 
 // CSVLoader struct type
+// Columns maps the ride fields (id, latitude, longitude and timestamp) to
+// a header name or a zero based column index
 type CSVLoader struct {
+	Delimiter rune
+	Header    string
+	Columns   map[string]string
+
+	indexes []int
 }
 
-// Load load a CSV data of a complete ride into ride object
-// CSV data provided in the form of (id_ride, lat, lng, timestamp)
-func (c CSVLoader) Load(ride *model.Ride, csv string) (*model.Ride, error) {
-	var itemsPerLine []string
-	var err error
-	var id int
-	var lat float64
-	var lng float64
-	var timestamp time.Time
+// JSONLoader struct type
+// It loads JSON Lines data using the configured field names
+type JSONLoader struct {
+	IDField        string
+	LatitudeField  string
+	LongitudeField string
+	TimestampField string
+}
 
-	lines := strings.Split(csv, "\n")
+// NewRideLoader creates a ride loader for a dataset format (csv or jsonl)
+func NewRideLoader(format string) (RideLoader, error) {
+	switch format {
+	case FormatCSV:
+		loader, err := NewCSVLoader()
 
-	for i := 0; i < len(lines); i++ {
-		lines[i] = strings.TrimSpace(lines[i])
+		if err != nil {
+			return nil, err
+		}
 
-		if strings.TrimSpace(lines[i]) == "" {
+		return loader, nil
+	case FormatJSONL:
+		return NewJSONLoader(), nil
+	}
+
+	return nil, fmt.Errorf("Invalid dataset format %s", format)
+}
+
+// DetectFormat detects the dataset format from the file extension or
+// from the file content if the extension is unknown. The compression
+// extension is ignored and compressed content is decompressed
+func DetectFormat(filePath, compression string) (string, error) {
+	switch strings.ToLower(filepath.Ext(TrimCompressionExt(filePath))) {
+	case ".csv", ".txt":
+		return FormatCSV, nil
+	case ".jsonl", ".ndjson", ".json":
+		return FormatJSONL, nil
+	}
+
+	// The standard input can't be read twice, so the format is detected from the peeked data
+	if filePath == StdStream {
+		compression, err := ResolveCompression(filePath, compression)
+
+		if err != nil {
+			return "", err
+		}
+
+		data, _ := stdin.Peek(maxSniffSize)
+
+		reader, err := NewCompressedReader(ioutil.NopCloser(bytes.NewReader(data)), compression)
+
+		if err != nil {
+			return "", err
+		}
+
+		defer reader.Close()
+
+		return sniffFormat(reader)
+	}
+
+	file, err := OpenCompressedFile(filePath, compression)
+
+	if err != nil {
+		return "", fmt.Errorf("Unable to open file %s: %s", filePath, err.Error())
+	}
+
+	defer file.Close()
+
+	return sniffFormat(file)
+}
+
+// sniffFormat detects the dataset format from the first non space character
+func sniffFormat(r io.Reader) (string, error) {
+	reader := bufio.NewReader(r)
+
+	for {
+		char, _, err := reader.ReadRune()
+
+		// The peeked data of a compressed stream may end unexpectedly
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return FormatCSV, nil
+		}
+
+		if err != nil {
+			return "", err
+		}
+
+		if strings.TrimSpace(string(char)) == "" {
 			continue
 		}
 
-		itemsPerLine = strings.Split(lines[i], ",")
+		if char == '{' {
+			return FormatJSONL, nil
+		}
 
-		id, err = util.StringToInt(strings.TrimSpace(itemsPerLine[0]))
+		return FormatCSV, nil
+	}
+}
+
+// NewCSVLoader creates a new instance of CSVLoader with the delimiter, header
+// mode and column map in the config
+func NewCSVLoader() (*CSVLoader, error) {
+	loader := &CSVLoader{
+		Delimiter: ',',
+		Header:    strings.ToLower(viper.GetString("input.header")),
+		Columns:   make(map[string]string),
+	}
+
+	delimiter := viper.GetString("input.delimiter")
+
+	if strings.ToLower(delimiter) == "tab" {
+		delimiter = "\t"
+	}
+
+	if delimiter != "" {
+		runes := []rune(delimiter)
+
+		if len(runes) != 1 || runes[0] == '"' || runes[0] == '\r' || runes[0] == '\n' {
+			return nil, fmt.Errorf("Invalid CSV delimiter %s", delimiter)
+		}
+
+		loader.Delimiter = runes[0]
+	}
+
+	if loader.Header == "" {
+		loader.Header = HeaderAuto
+	}
+
+	if loader.Header != HeaderAuto && loader.Header != "true" && loader.Header != "false" {
+		return nil, fmt.Errorf("Invalid CSV header mode %s", loader.Header)
+	}
+
+	for _, field := range csvFields {
+		if column := strings.TrimSpace(viper.GetString(fmt.Sprintf("input.columns.%s", field))); column != "" {
+			loader.Columns[field] = column
+		}
+	}
+
+	return loader, nil
+}
+
+// ParseHeader reads the first row of the dataset and resolves the column of each
+// ride field. A header row is consumed so the reader is left at the first data row
+// It is called for each dataset file, the columns are resolved from the first one
+func (c *CSVLoader) ParseHeader(reader *bufio.Reader) error {
+	// The reader may return less data than the header size with an EOF error
+	data, _ := reader.Peek(maxHeaderSize)
+	line := string(data)
+
+	if index := strings.IndexByte(line, '\n'); index >= 0 {
+		line = line[:index+1]
+	}
+
+	record, err := c.parseRecord(line)
+
+	// Empty dataset
+	if err == io.EOF {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("Invalid CSV first row: %s", err.Error())
+	}
+
+	named := false
+	indexes := make([]int, len(csvFields))
+
+	for i, field := range csvFields {
+		column, ok := c.Columns[field]
+
+		if !ok {
+			indexes[i] = i
+			continue
+		}
+
+		if indexes[i], err = strconv.Atoi(column); err != nil {
+			named = true
+		} else if indexes[i] < 0 {
+			return fmt.Errorf("Invalid column %s for field %s", column, field)
+		}
+	}
+
+	isHeader := c.Header == "true" || named
+
+	// In auto mode, the first row is a header if its latitude is not a number
+	if c.Header == HeaderAuto && !isHeader {
+		if indexes[1] >= len(record) {
+			isHeader = true
+		} else if _, err := util.StringToFloat64(record[indexes[1]]); err != nil {
+			isHeader = true
+		}
+	}
+
+	if named && c.Header == "false" {
+		return fmt.Errorf("Columns are mapped by name but the dataset has no header row")
+	}
+
+	if isHeader {
+		for i, field := range csvFields {
+			column, ok := c.Columns[field]
+
+			// Fields without a column name keep their index
+			if !ok {
+				continue
+			}
+
+			if _, err := strconv.Atoi(column); err == nil {
+				continue
+			}
+
+			indexes[i] = -1
+
+			for j, name := range record {
+				if strings.EqualFold(strings.TrimSpace(name), column) {
+					indexes[i] = j
+					break
+				}
+			}
+
+			if indexes[i] < 0 {
+				return fmt.Errorf("Column %s of field %s is missing in the header row", column, field)
+			}
+		}
+
+		if _, err := reader.Discard(len(line)); err != nil {
+			return err
+		}
+	}
+
+	// The columns of the following dataset files must match the first one
+	// since the loader may be in use already
+	if c.indexes != nil {
+		for i := range indexes {
+			if indexes[i] != c.indexes[i] {
+				return fmt.Errorf("Columns don't match the columns of the first dataset file")
+			}
+		}
+
+		return nil
+	}
+
+	c.indexes = indexes
+
+	return nil
+}
+
+// IsCompleteRecord reports whether the data holds complete CSV records. A record
+// with a quoted field containing line breaks spans multiple lines
+func (c CSVLoader) IsCompleteRecord(data string) bool {
+	return strings.Count(data, "\"")%2 == 0
+}
+
+// Load load a CSV data of a complete ride into ride object
+// CSV data provided in the form of (id_ride, lat, lng, timestamp) unless the columns are mapped
+func (c CSVLoader) Load(ride *model.Ride, data string) (*model.Ride, error) {
+	reader := c.newReader(strings.NewReader(data))
+
+	for {
+		record, err := reader.Read()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return ride, err
+		}
+
+		values, err := c.getValues(record)
+
+		if err != nil {
+			return ride, err
+		}
+
+		id, err := util.StringToInt(values[0])
 
 		if err != nil {
 			return ride, err
 		}
 
-		lat, err = util.StringToFloat64(strings.TrimSpace(itemsPerLine[1]))
+		lat, err := util.StringToFloat64(values[1])
 
 		if err != nil {
 			return ride, err
 		}
 
-		lng, err = util.StringToFloat64(strings.TrimSpace(itemsPerLine[2]))
+		lng, err := util.StringToFloat64(values[2])
 
 		if err != nil {
 			return ride, err
 		}
 
-		timestamp, err = util.StringToTimestamp(strings.TrimSpace(itemsPerLine[3]))
+		timestamp, err := util.StringToTimestamp(values[3])
 
 		if err != nil {
 			return ride, err
@@ -76,3 +381,299 @@ func (c CSVLoader) Load(ride *model.Ride, csv string) (*model.Ride, error) {
 
 	return ride, nil
 }
+
+// GetRideID gets the ride id of a CSV record
+func (c CSVLoader) GetRideID(line string) (string, error) {
+	record, err := c.parseRecord(line)
+
+	if err != nil {
+		return "", err
+	}
+
+	values, err := c.getValues(record)
+
+	if err != nil {
+		return "", err
+	}
+
+	return values[0], nil
+}
+
+// ParseLine parses a CSV record into the ride id and the coordinate. Records
+// without quotes are split in place, quoted records are parsed as RFC 4180
+func (c CSVLoader) ParseLine(line string) (int, model.Coordinate, error) {
+	var values [4]string
+
+	if strings.IndexByte(line, '"') >= 0 {
+		record, err := c.parseRecord(line)
+
+		if err != nil {
+			return 0, model.Coordinate{}, err
+		}
+
+		fields, err := c.getValues(record)
+
+		if err != nil {
+			return 0, model.Coordinate{}, err
+		}
+
+		copy(values[:], fields)
+	} else if err := c.splitValues(line, &values); err != nil {
+		return 0, model.Coordinate{}, err
+	}
+
+	id, err := util.StringToInt(values[0])
+
+	if err != nil {
+		return 0, model.Coordinate{}, err
+	}
+
+	lat, err := util.StringToFloat64(values[1])
+
+	if err != nil {
+		return 0, model.Coordinate{}, err
+	}
+
+	lng, err := util.StringToFloat64(values[2])
+
+	if err != nil {
+		return 0, model.Coordinate{}, err
+	}
+
+	timestamp, err := util.StringToTimestamp(values[3])
+
+	if err != nil {
+		return 0, model.Coordinate{}, err
+	}
+
+	return id, model.Coordinate{Latitude: lat, Longitude: lng, Timestamp: timestamp}, nil
+}
+
+// splitValues gets the id, latitude, longitude and timestamp values of a CSV record
+// without quotes. The values are slices of the record so nothing is allocated
+func (c CSVLoader) splitValues(line string, values *[4]string) error {
+	delimiter := c.getDelimiter()
+	record := line
+	found := 0
+
+	for column := 0; found != 1<<uint(len(csvFields))-1; column++ {
+		field := record
+		end := strings.IndexRune(record, delimiter)
+
+		if end >= 0 {
+			field = record[:end]
+		}
+
+		for i := range csvFields {
+			if c.getIndex(i) == column {
+				values[i] = strings.TrimSpace(field)
+				found |= 1 << uint(i)
+			}
+		}
+
+		if end < 0 {
+			break
+		}
+
+		record = record[end+utf8.RuneLen(delimiter):]
+	}
+
+	for i := range csvFields {
+		if found&(1<<uint(i)) == 0 {
+			return fmt.Errorf("Field %s is missing in CSV record %s", csvFields[i], line)
+		}
+	}
+
+	return nil
+}
+
+// parseRecord parses a single CSV record
+func (c CSVLoader) parseRecord(line string) ([]string, error) {
+	return c.newReader(strings.NewReader(line)).Read()
+}
+
+// getValues gets the id, latitude, longitude and timestamp values of a CSV record
+func (c CSVLoader) getValues(record []string) ([]string, error) {
+	values := make([]string, len(csvFields))
+
+	for i := range csvFields {
+		index := c.getIndex(i)
+
+		if index >= len(record) {
+			return nil, fmt.Errorf(
+				"Field %s is missing in CSV record %s",
+				csvFields[i],
+				strings.Join(record, string(c.getDelimiter())),
+			)
+		}
+
+		values[i] = strings.TrimSpace(record[index])
+	}
+
+	return values, nil
+}
+
+// newReader creates an RFC 4180 reader with the loader delimiter
+func (c CSVLoader) newReader(r io.Reader) *csv.Reader {
+	reader := csv.NewReader(r)
+	reader.Comma = c.getDelimiter()
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.ReuseRecord = true
+
+	return reader
+}
+
+// getIndex gets the column index of a ride field
+func (c CSVLoader) getIndex(field int) int {
+	if c.indexes != nil {
+		return c.indexes[field]
+	}
+
+	return field
+}
+
+// getDelimiter gets the loader delimiter, comma by default
+func (c CSVLoader) getDelimiter() rune {
+	if c.Delimiter == 0 {
+		return ','
+	}
+
+	return c.Delimiter
+}
+
+// NewJSONLoader creates a new instance of JSONLoader with the field names in the config
+func NewJSONLoader() JSONLoader {
+	loader := JSONLoader{
+		IDField:        viper.GetString("input.jsonl.fields.id"),
+		LatitudeField:  viper.GetString("input.jsonl.fields.latitude"),
+		LongitudeField: viper.GetString("input.jsonl.fields.longitude"),
+		TimestampField: viper.GetString("input.jsonl.fields.timestamp"),
+	}
+
+	if loader.IDField == "" {
+		loader.IDField = "id"
+	}
+
+	if loader.LatitudeField == "" {
+		loader.LatitudeField = "lat"
+	}
+
+	if loader.LongitudeField == "" {
+		loader.LongitudeField = "lng"
+	}
+
+	if loader.TimestampField == "" {
+		loader.TimestampField = "ts"
+	}
+
+	return loader
+}
+
+// Load load a JSON Lines data of a complete ride into ride object. Each line is
+// a JSON object with the ride id, lat, lng and timestamp fields, any other field is ignored
+func (j JSONLoader) Load(ride *model.Ride, jsonl string) (*model.Ride, error) {
+	lines := strings.Split(jsonl, "\n")
+
+	for i := 0; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) == "" {
+			continue
+		}
+
+		id, coordinate, err := j.ParseLine(lines[i])
+
+		if err != nil {
+			return ride, err
+		}
+
+		ride.SetID(id)
+		ride.AppendCoordinate(coordinate)
+	}
+
+	return ride, nil
+}
+
+// ParseLine parses a JSON line into the ride id and the coordinate
+func (j JSONLoader) ParseLine(line string) (int, model.Coordinate, error) {
+	fields, err := j.decode(line)
+
+	if err != nil {
+		return 0, model.Coordinate{}, err
+	}
+
+	id, err := util.StringToInt(fields[j.IDField])
+
+	if err != nil {
+		return 0, model.Coordinate{}, err
+	}
+
+	lat, err := util.StringToFloat64(fields[j.LatitudeField])
+
+	if err != nil {
+		return 0, model.Coordinate{}, err
+	}
+
+	lng, err := util.StringToFloat64(fields[j.LongitudeField])
+
+	if err != nil {
+		return 0, model.Coordinate{}, err
+	}
+
+	timestamp, err := util.StringToTimestamp(fields[j.TimestampField])
+
+	if err != nil {
+		// Also accept RFC 3339 timestamps
+		if timestamp, err = time.Parse(time.RFC3339, fields[j.TimestampField]); err != nil {
+			return 0, model.Coordinate{}, fmt.Errorf(
+				"Unable to convert value %s to timestamp",
+				fields[j.TimestampField],
+			)
+		}
+	}
+
+	return id, model.Coordinate{Latitude: lat, Longitude: lng, Timestamp: timestamp}, nil
+}
+
+// GetRideID gets the ride id of a JSON line
+func (j JSONLoader) GetRideID(line string) (string, error) {
+	fields, err := j.decode(line)
+
+	if err != nil {
+		return "", err
+	}
+
+	return fields[j.IDField], nil
+}
+
+// decode decodes the ride fields of a JSON line into strings
+func (j JSONLoader) decode(line string) (map[string]string, error) {
+	data := make(map[string]interface{})
+
+	decoder := json.NewDecoder(bytes.NewBufferString(line))
+	decoder.UseNumber()
+
+	if err := decoder.Decode(&data); err != nil {
+		return nil, fmt.Errorf("Invalid JSON line %s: %s", line, err.Error())
+	}
+
+	fields := make(map[string]string)
+
+	for _, name := range []string{j.IDField, j.LatitudeField, j.LongitudeField, j.TimestampField} {
+		value, ok := data[name]
+
+		if !ok {
+			return nil, fmt.Errorf("Field %s is missing in JSON line %s", name, line)
+		}
+
+		switch v := value.(type) {
+		case json.Number:
+			fields[name] = v.String()
+		case string:
+			fields[name] = v
+		default:
+			return nil, fmt.Errorf("Field %s has an invalid value in JSON line %s", name, line)
+		}
+	}
+
+	return fields, nil
+}
@@ -5,15 +5,26 @@
 package module
 
 import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"strings"
 	"testing"
+	"time"
 
 	"bitbucket.org/clivern/beat/core/model"
+	"bitbucket.org/clivern/beat/pkg"
 
 	"github.com/franela/goblin"
+	"github.com/spf13/viper"
 )
 
 // TestCSVLoader test cases
 func TestCSVLoader(t *testing.T) {
+	// Load Configs
+	baseDir := pkg.GetBaseDir("cache")
+	pkg.LoadConfigs(fmt.Sprintf("%s/config.dist.yml", baseDir))
+
 	g := goblin.Goblin(t)
 
 	g.Describe("CSVLoader", func() {
@@ -76,6 +87,115 @@ func TestCSVLoader(t *testing.T) {
 			}
 		})
 
+		g.It("It should parse quoted fields and mapped columns", func() {
+			loader := &CSVLoader{
+				Delimiter: ';',
+				indexes:   []int{3, 0, 1, 2},
+			}
+
+			id, err := loader.GetRideID("37.966660;23.728308;1405594957;\"7\"")
+			g.Assert(id).Equal("7")
+			g.Assert(err).Equal(nil)
+
+			ride, err := loader.Load(model.NewRide(), "\"37.966660\";23.728308;1405594957;7\n37.966627;23.728263;1405594966;7")
+			g.Assert(err).Equal(nil)
+			g.Assert(ride.GetID()).Equal(7)
+			g.Assert(len(ride.GetCoordinates())).Equal(2)
+			g.Assert(ride.GetCoordinates()[0].Latitude).Equal(37.966660)
+
+			_, err = loader.GetRideID("37.966660;23.728308")
+			g.Assert(err != nil).Equal(true)
+
+			g.Assert(loader.IsCompleteRecord("1;\"line one")).Equal(false)
+			g.Assert(loader.IsCompleteRecord("1;\"line one\nline \"\"two\"\"\";2")).Equal(true)
+		})
+
+		g.It("It should parse a line into the ride id and the coordinate", func() {
+			loader := &CSVLoader{
+				Delimiter: ';',
+				indexes:   []int{3, 0, 1, 2},
+			}
+
+			// The quoted and the unquoted records give the same coordinate
+			for _, line := range []string{"37.966660; 23.728308;1405594957;7", "\"37.966660\";23.728308;1405594957;\"7\""} {
+				id, coordinate, err := loader.ParseLine(line)
+				g.Assert(err).Equal(nil)
+				g.Assert(id).Equal(7)
+				g.Assert(coordinate).Equal(model.Coordinate{
+					Latitude:  37.966660,
+					Longitude: 23.728308,
+					Timestamp: time.Unix(1405594957, 0),
+				})
+			}
+
+			_, _, err := loader.ParseLine("37.966660;23.728308;1405594957")
+			g.Assert(err.Error()).Equal("Field id is missing in CSV record 37.966660;23.728308;1405594957")
+
+			_, _, err = loader.ParseLine("37.966660;23.728308;1405594957;seven")
+			g.Assert(err != nil).Equal(true)
+
+			id, coordinate, err := JSONLoader{
+				IDField:        "id",
+				LatitudeField:  "lat",
+				LongitudeField: "lng",
+				TimestampField: "ts",
+			}.ParseLine("{\"id\": 7, \"lat\": 37.966660, \"lng\": 23.728308, \"ts\": \"2014-07-17T10:49:17Z\"}")
+			g.Assert(err).Equal(nil)
+			g.Assert(id).Equal(7)
+			g.Assert(coordinate.Latitude).Equal(37.966660)
+			g.Assert(coordinate.Timestamp.Unix()).Equal(int64(1405594157))
+		})
+
+		g.It("It should detect the header row", func() {
+			var tests = []struct {
+				data       string
+				header     string
+				columns    map[string]string
+				wantRow    string
+				wantErrNil bool
+			}{
+				{"id,lat,lng,ts\n1,37.966660,23.728308,1405594957\n", HeaderAuto, nil, "1,37.966660,23.728308,1405594957\n", true},
+				{"1,37.966660,23.728308,1405594957\n", HeaderAuto, nil, "1,37.966660,23.728308,1405594957\n", true},
+				{"1,37.966660,23.728308,1405594957\n", "true", nil, "", true},
+				{"ts,id,lat,lng\n1405594957,1,37.966660,23.728308\n", HeaderAuto, map[string]string{"id": "ID", "latitude": "lat", "longitude": "lng", "timestamp": "ts"}, "1405594957,1,37.966660,23.728308\n", true},
+				{"1,37.966660,23.728308,1405594957\n", "false", map[string]string{"latitude": "lat"}, "", false},
+				{"id,lat,lng,ts\n", HeaderAuto, map[string]string{"latitude": "latitude"}, "", false},
+			}
+
+			for _, tt := range tests {
+				loader := &CSVLoader{Header: tt.header, Columns: tt.columns}
+				reader := bufio.NewReader(strings.NewReader(tt.data))
+
+				err := loader.ParseHeader(reader)
+				g.Assert(err == nil).Equal(tt.wantErrNil)
+
+				if tt.wantErrNil {
+					row, _ := reader.ReadString('\n')
+					g.Assert(row).Equal(tt.wantRow)
+				}
+			}
+		})
+
+		g.It("It should create the loader from config", func() {
+			viper.Set("input.delimiter", "tab")
+			viper.Set("input.header", "false")
+
+			loader, err := NewCSVLoader()
+			g.Assert(err).Equal(nil)
+			g.Assert(loader.Delimiter).Equal('\t')
+			g.Assert(loader.Header).Equal("false")
+
+			viper.Set("input.delimiter", ";;")
+			_, err = NewCSVLoader()
+			g.Assert(err != nil).Equal(true)
+
+			viper.Set("input.delimiter", ",")
+			viper.Set("input.header", "maybe")
+			_, err = NewCSVLoader()
+			g.Assert(err != nil).Equal(true)
+
+			viper.Set("input.header", HeaderAuto)
+		})
 	})
 }
 
@@ -88,3 +208,139 @@ func BenchmarkCSVLoad(b *testing.B) {
 		loader.Load(ride, "1,37.966660,23.728308,1405594957\n1,37.966627,23.728263,1405594966\n1,37.966625,23.728263,1405594974")
 	}
 }
+
+// TestJSONLoader test cases
+func TestJSONLoader(t *testing.T) {
+	// Load Configs
+	baseDir := pkg.GetBaseDir("cache")
+	pkg.LoadConfigs(fmt.Sprintf("%s/config.dist.yml", baseDir))
+
+	g := goblin.Goblin(t)
+
+	g.Describe("JSONLoader", func() {
+
+		g.It("JSONLoader should implement RideLoader", func() {
+			var _ RideLoader = JSONLoader{}
+		})
+
+		g.It("It should satisfy all provided test cases", func() {
+
+			var tests = []struct {
+				data string
+
+				wantRideID                   int
+				wantCoordinatesCount         int
+				wantFirstCoordinateLatitude  float64
+				wantFirstCoordinateLongitude float64
+				wantLastCoordinateLatitude   float64
+				wantLastCoordinateLongitude  float64
+				wantErrorNil                 bool
+			}{
+				// Valid data with extra attributes
+				{"{\"id\": 1, \"lat\": 37.966660, \"lng\": 23.728308, \"ts\": 1405594957, \"driver\": \"x\"}\n{\"id\": 1, \"lat\": 37.966625, \"lng\": 23.728263, \"ts\": 1405594974}", 1, 2, 37.966660, 23.728308, 37.966625, 23.728263, true},
+
+				// Valid data with string values and RFC 3339 timestamp
+				{"{\"id\": \"1\", \"lat\": \"37.966660\", \"lng\": \"23.728308\", \"ts\": \"2014-07-17T11:02:37Z\"}", 1, 1, 37.966660, 23.728308, 37.966660, 23.728308, true},
+
+				// Missing timestamp field
+				{"{\"id\": 1, \"lat\": 37.966660, \"lng\": 23.728308}", 0, 0, 0, 0, 0, 0, false},
+
+				// Invalid latitude in data
+				{"{\"id\": 1, \"lat\": 37.966660, \"lng\": 23.728308, \"ts\": 1405594957}\n{\"id\": 1, \"lat\": \"ji\", \"lng\": 23.728308, \"ts\": 1405594957}", 1, 1, 0, 0, 0, 0, false},
+
+				// Invalid JSON
+				{"1,37.966660,23.728308,1405594957", 0, 0, 0, 0, 0, 0, false},
+			}
+
+			for _, tt := range tests {
+				ride := model.NewRide()
+				loader := NewJSONLoader()
+				_, err := loader.Load(ride, tt.data)
+
+				g.Assert(ride.ID).Equal(tt.wantRideID)
+				g.Assert(len(ride.Coordinates)).Equal(tt.wantCoordinatesCount)
+
+				if tt.wantErrorNil {
+					// Verify the first element
+					g.Assert(ride.Coordinates[0].Latitude).Equal(tt.wantFirstCoordinateLatitude)
+					g.Assert(ride.Coordinates[0].Longitude).Equal(tt.wantFirstCoordinateLongitude)
+
+					// Verify the last element
+					g.Assert(ride.Coordinates[tt.wantCoordinatesCount-1].Latitude).Equal(tt.wantLastCoordinateLatitude)
+					g.Assert(ride.Coordinates[tt.wantCoordinatesCount-1].Longitude).Equal(tt.wantLastCoordinateLongitude)
+				}
+
+				g.Assert(err == nil).Equal(tt.wantErrorNil)
+			}
+		})
+
+		g.It("It should use the configured field names", func() {
+			loader := JSONLoader{
+				IDField:        "ride",
+				LatitudeField:  "latitude",
+				LongitudeField: "longitude",
+				TimestampField: "time",
+			}
+
+			id, err := loader.GetRideID("{\"ride\": 7, \"latitude\": 37.966660, \"longitude\": 23.728308, \"time\": 1405594957}")
+
+			g.Assert(id).Equal("7")
+			g.Assert(err).Equal(nil)
+
+			_, err = loader.GetRideID("{\"id\": 7, \"lat\": 37.966660, \"lng\": 23.728308, \"ts\": 1405594957}")
+
+			g.Assert(err != nil).Equal(true)
+		})
+	})
+}
+
+// TestDetectFormat test cases
+func TestDetectFormat(t *testing.T) {
+	baseDir := pkg.GetBaseDir("cache")
+	testDataDir := fmt.Sprintf("%s/%s", baseDir, "testdata")
+	cacheDir := fmt.Sprintf("%s/%s", baseDir, "cache")
+
+	g := goblin.Goblin(t)
+
+	g.Describe("DetectFormat", func() {
+		g.It("It should detect the format from the file extension", func() {
+			format, err := DetectFormat(fmt.Sprintf("%s/test_paths_01.csv", testDataDir), CompressionAuto)
+			g.Assert(format).Equal(FormatCSV)
+			g.Assert(err).Equal(nil)
+
+			format, err = DetectFormat(fmt.Sprintf("%s/test_paths_03.jsonl", testDataDir), CompressionAuto)
+			g.Assert(format).Equal(FormatJSONL)
+			g.Assert(err).Equal(nil)
+		})
+
+		g.It("It should detect the format from the file content", func() {
+			filePath := fmt.Sprintf("%s/detect_format_test01.dat", cacheDir)
+
+			ioutil.WriteFile(filePath, []byte("\n  {\"id\": 1, \"lat\": 37.966660, \"lng\": 23.728308, \"ts\": 1405594957}\n"), 0644)
+			format, err := DetectFormat(filePath, CompressionAuto)
+			g.Assert(format).Equal(FormatJSONL)
+			g.Assert(err).Equal(nil)
+
+			ioutil.WriteFile(filePath, []byte("1,37.966660,23.728308,1405594957\n"), 0644)
+			format, err = DetectFormat(filePath, CompressionAuto)
+			g.Assert(format).Equal(FormatCSV)
+			g.Assert(err).Equal(nil)
+		})
+
+		g.It("It should fail since file is missing", func() {
+			_, err := DetectFormat(fmt.Sprintf("%s/not_found.dat", testDataDir), CompressionAuto)
+			g.Assert(err != nil).Equal(true)
+		})
+	})
+}
+
+// BenchmarkCSVLoaderParseLine benchmark
+func BenchmarkCSVLoaderParseLine(b *testing.B) {
+	loader := CSVLoader{}
+
+	b.ReportAllocs()
+
+	for n := 0; n < b.N; n++ {
+		loader.ParseLine("1,37.966660,23.728308,1405594957")
+	}
+}
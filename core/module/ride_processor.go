@@ -0,0 +1,119 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package module
+
+import (
+	"bitbucket.org/clivern/beat/core/model"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// RideProcessor struct type
+// It runs the processing stages of a ride. The validator, the map matcher,
+// the anomaly detector and the fare cache are optional. The anomaly detector
+// scores the priced rides in the order of the results
+type RideProcessor struct {
+	Validator *model.CoordinateValidator
+	Matcher   *MapMatcher
+	Detector  *AnomalyDetector
+	Cache     *FareCache
+}
+
+// NewRideProcessor creates a new instance of RideProcessor
+func NewRideProcessor(validator *model.CoordinateValidator, matcher *MapMatcher, detector *AnomalyDetector, cache *FareCache) *RideProcessor {
+	return &RideProcessor{
+		Validator: validator,
+		Matcher:   matcher,
+		Detector:  detector,
+		Cache:     cache,
+	}
+}
+
+// Process removes the rejected and invalid coordinates of the ride then calculates its fare
+// A fare found in the fare cache is reused instead of matching and pricing the ride again
+func (p *RideProcessor) Process(ride *model.Ride) (float64, error) {
+	var rideHash string
+	var cached CachedFare
+	var found bool
+
+	// The hash of the raw points before any of them is removed
+	if p.Cache != nil {
+		var err error
+
+		rideHash = RideHash(ride)
+		cached, found, err = p.Cache.Get(rideHash)
+
+		if err != nil {
+			log.WithFields(log.Fields{
+				"ride_id": ride.GetID(),
+				"stage":   "fare_cache",
+				"reason":  err.Error(),
+			}).Error("Error while reading the ride fare from the fare cache")
+		}
+	}
+
+	// Remove rejected coordinates
+	if p.Validator != nil {
+		ride.SetRejectedReasons(ride.ValidateCoordinates(p.Validator))
+	}
+
+	// Remove invalid coordinates
+	ride.NormalizeCoordinates()
+
+	var fare float64
+
+	if found {
+		fare = cached.Fare
+		ride.SetMetrics(cached.Metrics)
+		ride.SetSegments(cached.Segments)
+		ride.SetFare(fare)
+	} else {
+		// Snap the coordinates to the road network
+		if p.Matcher != nil {
+			ride.SetRoadDistances(p.Matcher.Match(ride.GetCoordinates()))
+		}
+
+		// Calculate The fare
+		var err error
+
+		fare, err = CalculateRideFare(ride)
+
+		ride.SetFare(fare)
+
+		if err != nil {
+			return fare, err
+		}
+
+		if p.Cache != nil {
+			err := p.Cache.Put(rideHash, CachedFare{
+				Fare:     fare,
+				Metrics:  ride.GetMetrics(),
+				Segments: ride.GetSegments(),
+			})
+
+			if err != nil {
+				log.WithFields(log.Fields{
+					"ride_id": ride.GetID(),
+					"stage":   "fare_cache",
+					"reason":  err.Error(),
+				}).Error("Error while writing the ride fare to the fare cache")
+			}
+		}
+	}
+
+	return fare, nil
+}
+
+// orderedResults reports whether the results are sent in the dataset order. The fare
+// outlier signal compares a ride with the rides before it so the anomaly detector
+// needs the dataset order for the same flagged rides on every run
+func (p *RideProcessor) orderedResults() bool {
+	if viper.GetBool("output.ordered") {
+		return true
+	}
+
+	return p.Detector != nil && viper.GetBool("anomaly.signals.fare_outlier.enabled")
+}
@@ -0,0 +1,65 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package module
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"bitbucket.org/clivern/beat/core/model"
+	"bitbucket.org/clivern/beat/pkg"
+
+	"github.com/franela/goblin"
+	"github.com/spf13/viper"
+)
+
+// TestRideProcessor test cases
+func TestRideProcessor(t *testing.T) {
+	// Load Configs
+	baseDir := pkg.GetBaseDir("cache")
+	pkg.LoadConfigs(fmt.Sprintf("%s/config.dist.yml", baseDir))
+
+	g := goblin.Goblin(t)
+
+	g.Describe("RideProcessor", func() {
+		g.It("It should drop rejected coordinates before calculating the fare", func() {
+			validator, err := model.NewCoordinateValidator()
+			g.Assert(err).Equal(nil)
+
+			processor := NewRideProcessor(validator, nil, nil, nil)
+
+			ride := model.NewRide()
+			ride.SetID(1)
+
+			// car was moving @6:42pm (distance is 11.46 km)
+			ride.AppendCoordinate(model.Coordinate{Latitude: 52.316275, Longitude: 4.678871, Timestamp: time.Unix(1608056422, 0)})
+			ride.AppendCoordinate(model.Coordinate{Latitude: 0, Longitude: 0, Timestamp: time.Unix(1608056482, 0)})
+			ride.AppendCoordinate(model.Coordinate{Latitude: 52.370210, Longitude: 4.535538, Timestamp: time.Unix(1608057742, 0)})
+
+			fare, err := processor.Process(ride)
+
+			g.Assert(err).Equal(nil)
+			g.Assert(fare).Equal(8.462425888868383 + viper.GetFloat64("fare.standard_fee"))
+			g.Assert(ride.GetFare()).Equal(fare)
+			g.Assert(len(ride.GetCoordinates())).Equal(2)
+			g.Assert(ride.GetRejectedReasons()).Equal(map[string]int{model.RejectNullIsland: 1})
+		})
+
+		g.It("It should keep all coordinates without a validator", func() {
+			processor := NewRideProcessor(nil, nil, nil, nil)
+
+			ride := model.NewRide()
+			ride.AppendCoordinate(model.Coordinate{Latitude: 0, Longitude: 0, Timestamp: time.Unix(1608056422, 0)})
+			ride.AppendCoordinate(model.Coordinate{Latitude: 0, Longitude: 0, Timestamp: time.Unix(1608056482, 0)})
+
+			fare, err := processor.Process(ride)
+
+			g.Assert(err).Equal(nil)
+			g.Assert(fare).Equal(viper.GetFloat64("fare.minimum"))
+			g.Assert(len(ride.GetCoordinates())).Equal(2)
+		})
+	})
+}
@@ -0,0 +1,284 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package module
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"bitbucket.org/clivern/beat/core/model"
+	"bitbucket.org/clivern/beat/core/util"
+
+	"github.com/paulmach/osm"
+	"github.com/paulmach/osm/osmpbf"
+	"github.com/paulmach/osm/osmxml"
+	log "github.com/sirupsen/logrus"
+)
+
+// roadGraphCacheVersion changes whenever the cached road graph format changes
+const roadGraphCacheVersion = 1
+
+// drivableHighways are the OpenStreetMap highway values a car can use
+var drivableHighways = map[string]bool{
+	"motorway":       true,
+	"motorway_link":  true,
+	"trunk":          true,
+	"trunk_link":     true,
+	"primary":        true,
+	"primary_link":   true,
+	"secondary":      true,
+	"secondary_link": true,
+	"tertiary":       true,
+	"tertiary_link":  true,
+	"unclassified":   true,
+	"residential":    true,
+	"living_street":  true,
+	"service":        true,
+	"road":           true,
+}
+
+// LoadRoadGraph loads the road graph from a local OpenStreetMap extract (PBF or XML).
+// The parsed graph is cached inside the cache directory, so later runs on the same
+// extract skip the parsing
+func LoadRoadGraph(filePath, cacheDir string) (*model.RoadGraph, error) {
+	key, err := RoadGraphKey(filePath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	cacheFile := filepath.Join(cacheDir, fmt.Sprintf("road_graph_%s.gob", key))
+
+	if util.FileExists(cacheFile) {
+		graph, err := readRoadGraphCache(cacheFile)
+
+		if err == nil {
+			log.WithField("cache_file", cacheFile).Debug("Road graph loaded from cache file")
+			return graph, nil
+		}
+
+		log.WithFields(log.Fields{
+			"cache_file": cacheFile,
+			"reason":     err.Error(),
+		}).Warn("Ignore invalid road graph cache file")
+	}
+
+	graph, err := parseRoadNetwork(filePath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	log.WithFields(log.Fields{
+		"osm_file": filePath,
+		"nodes":    len(graph.Nodes),
+		"edges":    len(graph.Edges),
+	}).Debug("Road graph loaded")
+
+	if err := writeRoadGraphCache(cacheFile, graph); err != nil {
+		log.WithFields(log.Fields{
+			"cache_file": cacheFile,
+			"reason":     err.Error(),
+		}).Warn("Unable to write road graph cache file")
+	}
+
+	return graph, nil
+}
+
+// parseRoadNetwork builds the road graph from the drivable ways of an OpenStreetMap extract.
+// The extract is scanned twice, first for the ways and then for the nodes they use
+func parseRoadNetwork(filePath string) (*model.RoadGraph, error) {
+	ways := make([]*osm.Way, 0)
+	usedNodes := make(map[osm.NodeID]int)
+
+	err := scanOSMFile(filePath, false, func(object osm.Object) {
+		way, ok := object.(*osm.Way)
+
+		if !ok || !drivableHighways[way.Tags.Find("highway")] {
+			return
+		}
+
+		ways = append(ways, way)
+
+		for _, node := range way.Nodes {
+			usedNodes[node.ID] = -1
+		}
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	graph := model.NewRoadGraph()
+
+	err = scanOSMFile(filePath, true, func(object osm.Object) {
+		node, ok := object.(*osm.Node)
+
+		if !ok {
+			return
+		}
+
+		if index, ok := usedNodes[node.ID]; ok && index == -1 {
+			usedNodes[node.ID] = graph.AddNode(model.RoadNode{
+				ID:        int64(node.ID),
+				Latitude:  node.Lat,
+				Longitude: node.Lon,
+			})
+		}
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, way := range ways {
+		forward, backward := getWayDirections(way)
+
+		for i := 1; i < len(way.Nodes); i++ {
+			from := usedNodes[way.Nodes[i-1].ID]
+			to := usedNodes[way.Nodes[i].ID]
+
+			// Nodes outside the extract boundaries
+			if from < 0 || to < 0 || from == to {
+				continue
+			}
+
+			if forward {
+				graph.AddEdge(from, to)
+			}
+
+			if backward {
+				graph.AddEdge(to, from)
+			}
+		}
+	}
+
+	graph.BuildIndex()
+
+	return graph, nil
+}
+
+// scanOSMFile calls the handler for each object inside the OpenStreetMap extract
+func scanOSMFile(filePath string, nodesOnly bool, handler func(osm.Object)) error {
+	file, err := os.Open(filePath)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	var scanner osm.Scanner
+
+	if strings.HasSuffix(strings.ToLower(filePath), ".pbf") {
+		pbfScanner := osmpbf.New(context.Background(), file, runtime.GOMAXPROCS(0))
+		pbfScanner.SkipRelations = true
+		pbfScanner.SkipNodes = !nodesOnly
+		pbfScanner.SkipWays = nodesOnly
+		scanner = pbfScanner
+	} else {
+		scanner = osmxml.New(context.Background(), file)
+	}
+
+	defer scanner.Close()
+
+	for scanner.Scan() {
+		handler(scanner.Object())
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf(
+			"Error while parsing OpenStreetMap file %s: %s",
+			filePath,
+			err.Error(),
+		)
+	}
+
+	return nil
+}
+
+// getWayDirections reports whether a way can be driven forward and backward
+func getWayDirections(way *osm.Way) (bool, bool) {
+	switch way.Tags.Find("oneway") {
+	case "yes", "true", "1":
+		return true, false
+	case "-1", "reverse":
+		return false, true
+	}
+
+	if way.Tags.Find("junction") == "roundabout" {
+		return true, false
+	}
+
+	return true, true
+}
+
+// RoadGraphKey gets a hash of the path, the size and the modification time of an
+// OpenStreetMap extract, it changes once the extract is replaced
+func RoadGraphKey(filePath string) (string, error) {
+	info, err := os.Stat(filePath)
+
+	if err != nil {
+		return "", fmt.Errorf("File %s not found", filePath)
+	}
+
+	absPath, err := filepath.Abs(filePath)
+
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(fmt.Sprintf(
+		"%d|%s|%d|%d",
+		roadGraphCacheVersion,
+		absPath,
+		info.Size(),
+		info.ModTime().UnixNano(),
+	)))), nil
+}
+
+// readRoadGraphCache decodes a cached road graph
+func readRoadGraphCache(cacheFile string) (*model.RoadGraph, error) {
+	file, err := os.Open(cacheFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	graph := model.NewRoadGraph()
+
+	if err := gob.NewDecoder(file).Decode(graph); err != nil {
+		return nil, err
+	}
+
+	graph.BuildIndex()
+
+	return graph, nil
+}
+
+// writeRoadGraphCache encodes the road graph into the cache file
+func writeRoadGraphCache(cacheFile string, graph *model.RoadGraph) error {
+	file, err := os.Create(cacheFile)
+
+	if err != nil {
+		return err
+	}
+
+	if err := gob.NewEncoder(file).Encode(graph); err != nil {
+		file.Close()
+		util.DeleteFile(cacheFile)
+		return err
+	}
+
+	return file.Close()
+}
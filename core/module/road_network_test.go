@@ -0,0 +1,52 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package module
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"bitbucket.org/clivern/beat/pkg"
+
+	"github.com/franela/goblin"
+)
+
+// TestLoadRoadGraph test cases
+func TestLoadRoadGraph(t *testing.T) {
+	baseDir := pkg.GetBaseDir("cache")
+	testDataDir := fmt.Sprintf("%s/%s", baseDir, "testdata")
+	cacheDir := fmt.Sprintf("%s/%s", baseDir, "cache")
+
+	g := goblin.Goblin(t)
+
+	g.Describe("LoadRoadGraph", func() {
+		g.It("It should fail since file is missing", func() {
+			_, err := LoadRoadGraph(fmt.Sprintf("%s/not_found.osm", testDataDir), cacheDir)
+			g.Assert(err != nil).Equal(true)
+		})
+
+		g.It("It should load the drivable roads and cache the graph", func() {
+			graph, err := LoadRoadGraph(fmt.Sprintf("%s/test_roads_01.osm", testDataDir), cacheDir)
+			g.Assert(err).Equal(nil)
+
+			// The footway and its node are skipped
+			g.Assert(len(graph.Nodes)).Equal(8)
+
+			// Two way roads have an edge per direction and the oneway road has one
+			g.Assert(len(graph.Edges)).Equal(13)
+
+			cacheFiles, _ := filepath.Glob(fmt.Sprintf("%s/road_graph_*.gob", cacheDir))
+			g.Assert(len(cacheFiles) > 0).Equal(true)
+
+			// Load again from the cache
+			cached, err := LoadRoadGraph(fmt.Sprintf("%s/test_roads_01.osm", testDataDir), cacheDir)
+			g.Assert(err).Equal(nil)
+			g.Assert(cached.Nodes).Equal(graph.Nodes)
+			g.Assert(cached.Edges).Equal(graph.Edges)
+			g.Assert(len(cached.GetNearbyEdges(cached.GetNodeCoordinate(0), 0.05)) > 0).Equal(true)
+		})
+	})
+}
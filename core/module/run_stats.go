@@ -0,0 +1,395 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package module
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"bitbucket.org/clivern/beat/core/model"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	// StageRead for the dataset reading stage
+	StageRead = "read"
+	// StageProcess for the fare calculation stage
+	StageProcess = "process"
+	// StageStore for the results storing stage
+	StageStore = "store"
+)
+
+// fareSketchGamma is the ratio between the fare buckets bounds
+// so percentiles are estimated within 0.1%
+const fareSketchGamma = 1.001
+
+// reportPercentiles are the fare percentiles of the report
+var reportPercentiles = []float64{50, 90, 95, 99}
+
+// RunStats struct type
+// It collects the statistics of a run from the ride results. Distances are
+// in Km and times are in hours like the ride metrics, the report distances
+// are in units.distance
+type RunStats struct {
+	mutex              sync.Mutex
+	minimumFare        float64
+	distanceUnit       model.Unit
+	rides              int64
+	totalFare          float64
+	minimumFareRides   int64
+	fares              *fareSketch
+	rejected           int64
+	rejectedReasons    map[string]int64
+	normalized         int64
+	idleTime           float64
+	movingTime         float64
+	nightDistance      float64
+	dayDistance        float64
+	processingDuration time.Duration
+	stages             map[string]*stageTiming
+}
+
+// StatsReport struct type
+// The statistics of a run
+type StatsReport struct {
+	Rides                 int64              `json:"rides"`
+	TotalFare             float64            `json:"total_fare"`
+	MeanFare              float64            `json:"mean_fare"`
+	FarePercentiles       map[string]float64 `json:"fare_percentiles"`
+	MinimumFareRides      int64              `json:"minimum_fare_rides"`
+	CoordinatesRejected   int64              `json:"coordinates_rejected"`
+	RejectedReasons       map[string]int64   `json:"coordinates_rejected_by_reason"`
+	CoordinatesNormalized int64              `json:"coordinates_removed_by_normalization"`
+	IdleHours             float64            `json:"idle_hours"`
+	MovingHours           float64            `json:"moving_hours"`
+	NightDistance         float64            `json:"night_distance"`
+	DayDistance           float64            `json:"day_distance"`
+	DistanceUnit          string             `json:"distance_unit"`
+	Stages                []StageReport      `json:"stages"`
+}
+
+// StageReport struct type
+// The time a pipeline stage ran and, for the process stage, the sum of the rides processing times
+type StageReport struct {
+	Name     string  `json:"name"`
+	Duration float64 `json:"duration_seconds"`
+	Busy     float64 `json:"busy_seconds,omitempty"`
+}
+
+// stageTiming struct type
+type stageTiming struct {
+	start time.Time
+	end   time.Time
+}
+
+// fareSketch struct type
+// It counts the fares in buckets growing by fareSketchGamma so percentiles
+// are estimated with a bounded memory on any number of rides
+type fareSketch struct {
+	buckets map[int]*fareBucket
+	zeros   int64
+	count   int64
+}
+
+// fareBucket struct type
+// The count and the sum of the fares in a bucket, the mean of the bucket
+// is exact when all its fares are the same like the minimum fare
+type fareBucket struct {
+	count int64
+	sum   float64
+}
+
+// NewRunStats creates a new instance of RunStats
+func NewRunStats() *RunStats {
+	return &RunStats{
+		minimumFare:     viper.GetFloat64("fare.minimum"),
+		distanceUnit:    model.GetDistanceUnit(),
+		rejectedReasons: make(map[string]int64),
+		fares:           &fareSketch{buckets: make(map[int]*fareBucket)},
+		stages:          make(map[string]*stageTiming),
+	}
+}
+
+// Add adds a ride result to the statistics
+func (s *RunStats) Add(result RideResult) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.rides++
+	s.totalFare += result.Fare
+	s.fares.add(result.Fare)
+
+	// The minimum fare overrides lower fares
+	if math.Abs(result.Fare-s.minimumFare) < 1e-9 {
+		s.minimumFareRides++
+	}
+
+	s.rejected += int64(result.PointsRejected)
+
+	for reason, count := range result.RejectedBy {
+		s.rejectedReasons[reason] += int64(count)
+	}
+
+	s.normalized += int64(result.PointsRemoved - result.PointsRejected)
+	s.idleTime += result.Metrics.IdleTime
+	s.movingTime += result.Metrics.MovingTime
+	s.nightDistance += result.Metrics.NightDistance
+	s.dayDistance += result.Metrics.Distance - result.Metrics.NightDistance
+	s.processingDuration += result.Duration
+}
+
+// StartStage records the start time of a pipeline stage
+func (s *RunStats) StartStage(name string) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.stages[name] = &stageTiming{start: time.Now()}
+}
+
+// EndStage records the end time of a pipeline stage
+func (s *RunStats) EndStage(name string) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if stage, ok := s.stages[name]; ok {
+		stage.end = time.Now()
+	}
+}
+
+// Report gets the statistics of the run
+func (s *RunStats) Report() StatsReport {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	report := StatsReport{
+		Rides:                 s.rides,
+		TotalFare:             roundFare(s.totalFare),
+		FarePercentiles:       make(map[string]float64),
+		MinimumFareRides:      s.minimumFareRides,
+		CoordinatesRejected:   s.rejected,
+		RejectedReasons:       make(map[string]int64, len(s.rejectedReasons)),
+		CoordinatesNormalized: s.normalized,
+		IdleHours:             s.idleTime,
+		MovingHours:           s.movingTime,
+		NightDistance:         s.distanceUnit.FromKm(s.nightDistance),
+		DayDistance:           s.distanceUnit.FromKm(s.dayDistance),
+		DistanceUnit:          string(s.distanceUnit),
+		Stages:                make([]StageReport, 0, len(s.stages)),
+	}
+
+	for reason, count := range s.rejectedReasons {
+		report.RejectedReasons[reason] = count
+	}
+
+	if s.rides > 0 {
+		report.MeanFare = roundFare(s.totalFare / float64(s.rides))
+
+		for _, percentile := range reportPercentiles {
+			report.FarePercentiles[fmt.Sprintf("p%g", percentile)] = roundFare(s.fares.quantile(percentile / 100))
+		}
+	}
+
+	for _, name := range []string{StageRead, StageProcess, StageStore} {
+		stage, ok := s.stages[name]
+
+		if !ok {
+			continue
+		}
+
+		end := stage.end
+
+		if end.IsZero() {
+			end = time.Now()
+		}
+
+		stageReport := StageReport{Name: name, Duration: end.Sub(stage.start).Seconds()}
+
+		if name == StageProcess {
+			stageReport.Busy = s.processingDuration.Seconds()
+		}
+
+		report.Stages = append(report.Stages, stageReport)
+	}
+
+	return report
+}
+
+// String formats the report as a summary
+func (r StatsReport) String() string {
+	percentiles := make([]string, 0, len(reportPercentiles))
+
+	for _, percentile := range reportPercentiles {
+		name := fmt.Sprintf("p%g", percentile)
+		percentiles = append(percentiles, fmt.Sprintf("%s %.2f", name, r.FarePercentiles[name]))
+	}
+
+	stages := make([]string, 0, len(r.Stages))
+
+	for _, stage := range r.Stages {
+		if stage.Busy > 0 {
+			stages = append(stages, fmt.Sprintf("%s %.2fs (busy %.2fs)", stage.Name, stage.Duration, stage.Busy))
+		} else {
+			stages = append(stages, fmt.Sprintf("%s %.2fs", stage.Name, stage.Duration))
+		}
+	}
+
+	reasons := make([]string, 0, len(r.RejectedReasons))
+
+	for reason := range r.RejectedReasons {
+		reasons = append(reasons, reason)
+	}
+
+	sort.Strings(reasons)
+
+	rejected := fmt.Sprintf("%d", r.CoordinatesRejected)
+
+	for index, reason := range reasons {
+		reasons[index] = fmt.Sprintf("%s %d", reason, r.RejectedReasons[reason])
+	}
+
+	if len(reasons) > 0 {
+		rejected = fmt.Sprintf("%s (%s)", rejected, strings.Join(reasons, ", "))
+	}
+
+	lines := []string{
+		fmt.Sprintf("Rides: %d", r.Rides),
+		fmt.Sprintf("Fares: total %.2f, mean %.2f, %s", r.TotalFare, r.MeanFare, strings.Join(percentiles, ", ")),
+		fmt.Sprintf("Rides at the minimum fare: %d", r.MinimumFareRides),
+		fmt.Sprintf("Coordinates rejected by validation: %s, removed by normalization: %d", rejected, r.CoordinatesNormalized),
+		fmt.Sprintf("Time: idle %.2f h, moving %.2f h", r.IdleHours, r.MovingHours),
+		fmt.Sprintf("Distance: night %.2f %s, day %.2f %s", r.NightDistance, r.DistanceUnit, r.DayDistance, r.DistanceUnit),
+		fmt.Sprintf("Stages: %s", strings.Join(stages, ", ")),
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// WriteStatsReport writes the report as JSON, the file is replaced atomically
+func WriteStatsReport(filePath string, report StatsReport) error {
+	data, err := json.MarshalIndent(report, "", "    ")
+
+	if err != nil {
+		return err
+	}
+
+	file, err := createAtomicFile(filePath)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Abort()
+		return err
+	}
+
+	return file.Close()
+}
+
+// track adds the results to the statistics while passing them through
+// The process stage ends once the results channel is closed
+func (s *RunStats) track(ctx context.Context, inputChannel <-chan RideResult) <-chan RideResult {
+	if s == nil {
+		return inputChannel
+	}
+
+	outChannel := make(chan RideResult)
+
+	go func() {
+		defer close(outChannel)
+		defer s.EndStage(StageProcess)
+
+		for result := range inputChannel {
+			s.Add(result)
+
+			select {
+			case outChannel <- result:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return outChannel
+}
+
+// add counts a fare
+func (f *fareSketch) add(fare float64) {
+	f.count++
+
+	if fare <= 0 {
+		f.zeros++
+		return
+	}
+
+	key := int(math.Ceil(math.Log(fare) / math.Log(fareSketchGamma)))
+	bucket, ok := f.buckets[key]
+
+	if !ok {
+		bucket = &fareBucket{}
+		f.buckets[key] = bucket
+	}
+
+	bucket.count++
+	bucket.sum += fare
+}
+
+// quantile estimates the fare at the quantile q between 0 and 1
+func (f *fareSketch) quantile(q float64) float64 {
+	if f.count == 0 {
+		return 0
+	}
+
+	rank := int64(math.Ceil(q * float64(f.count)))
+
+	if rank < 1 {
+		rank = 1
+	}
+
+	if rank <= f.zeros {
+		return 0
+	}
+
+	keys := make([]int, 0, len(f.buckets))
+
+	for key := range f.buckets {
+		keys = append(keys, key)
+	}
+
+	sort.Ints(keys)
+
+	seen := f.zeros
+
+	for _, key := range keys {
+		bucket := f.buckets[key]
+		seen += bucket.count
+
+		if seen >= rank {
+			return bucket.sum / float64(bucket.count)
+		}
+	}
+
+	return 0
+}
+
+// roundFare rounds a fare to cents
+func roundFare(fare float64) float64 {
+	return math.Round(fare*100) / 100
+}
@@ -0,0 +1,121 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package module
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"bitbucket.org/clivern/beat/core/model"
+	"bitbucket.org/clivern/beat/core/util"
+	"bitbucket.org/clivern/beat/pkg"
+
+	"github.com/franela/goblin"
+	"github.com/spf13/viper"
+)
+
+// TestRunStats test cases
+func TestRunStats(t *testing.T) {
+	baseDir := pkg.GetBaseDir("cache")
+	cacheDir := fmt.Sprintf("%s/%s", baseDir, "cache")
+	pkg.LoadConfigs(fmt.Sprintf("%s/config.dist.yml", baseDir))
+
+	g := goblin.Goblin(t)
+
+	g.Describe("RunStats", func() {
+		g.It("It should summarize the ride results", func() {
+			stats := NewRunStats()
+			stats.StartStage(StageProcess)
+
+			for i := 1; i <= 100; i++ {
+				stats.Add(RideResult{
+					RideID:         i,
+					Fare:           float64(i),
+					PointsRemoved:  3,
+					PointsRejected: 1,
+					Duration:       time.Millisecond,
+					Metrics: model.RideMetrics{
+						Distance:      2,
+						NightDistance: 0.5,
+						MovingTime:    0.25,
+						IdleTime:      0.05,
+					},
+				})
+			}
+
+			stats.Add(RideResult{
+				RideID:         101,
+				Fare:           3.47,
+				PointsRemoved:  3,
+				PointsRejected: 3,
+				RejectedBy:     map[string]int{model.RejectNullIsland: 2, model.RejectOldTimestamp: 1},
+			})
+			stats.EndStage(StageProcess)
+
+			report := stats.Report()
+			g.Assert(report.Rides).Equal(int64(101))
+			g.Assert(report.TotalFare).Equal(5053.47)
+			g.Assert(report.MeanFare).Equal(50.03)
+			g.Assert(report.FarePercentiles["p50"]).Equal(50.0)
+			g.Assert(report.FarePercentiles["p99"]).Equal(99.0)
+			g.Assert(report.MinimumFareRides).Equal(int64(1))
+			g.Assert(report.CoordinatesRejected).Equal(int64(103))
+			g.Assert(report.RejectedReasons).Equal(map[string]int64{model.RejectNullIsland: 2, model.RejectOldTimestamp: 1})
+			g.Assert(report.CoordinatesNormalized).Equal(int64(200))
+			g.Assert(report.NightDistance).Equal(50.0)
+			g.Assert(report.DayDistance).Equal(150.0)
+			g.Assert(report.DistanceUnit).Equal("km")
+			g.Assert(len(report.Stages)).Equal(1)
+			g.Assert(report.Stages[0].Name).Equal(StageProcess)
+			g.Assert(report.Stages[0].Busy).Equal(0.1)
+			g.Assert(strings.Contains(
+				report.String(),
+				"Coordinates rejected by validation: 103 (null_island 2, old_timestamp 1), removed by normalization: 200",
+			)).Equal(true)
+		})
+
+		g.It("It should report the distances in units.distance", func() {
+			defer viper.Set("units.distance", "km")
+
+			viper.Set("units.distance", "mi")
+
+			stats := NewRunStats()
+			stats.Add(RideResult{
+				RideID: 1,
+				Fare:   58.3,
+				Metrics: model.RideMetrics{
+					Distance:      16.09344,
+					NightDistance: 8.04672,
+				},
+			})
+
+			report := stats.Report()
+			g.Assert(report.NightDistance).Equal(5.0)
+			g.Assert(report.DayDistance).Equal(5.0)
+			g.Assert(report.DistanceUnit).Equal("mi")
+		})
+
+		g.It("It should write the report file", func() {
+			filePath := fmt.Sprintf("%s/run_stats_test01.json", cacheDir)
+
+			stats := NewRunStats()
+			stats.Add(RideResult{RideID: 1, Fare: 58.3})
+
+			g.Assert(WriteStatsReport(filePath, stats.Report())).Equal(nil)
+
+			content, err := util.ReadFile(filePath)
+			g.Assert(err).Equal(nil)
+
+			var report StatsReport
+
+			g.Assert(json.Unmarshal([]byte(content), &report)).Equal(nil)
+			g.Assert(report.Rides).Equal(int64(1))
+			g.Assert(report.FarePercentiles["p90"]).Equal(58.3)
+		})
+	})
+}
@@ -0,0 +1,246 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package module
+
+import (
+	"database/sql"
+	"fmt"
+
+	"bitbucket.org/clivern/beat/core/model"
+
+	"github.com/spf13/viper"
+
+	// SQLite driver without cgo
+	_ "modernc.org/sqlite"
+)
+
+// sqliteMigrations are the schema changes applied in order, the position
+// of a migration is its version so existing migrations must not change
+var sqliteMigrations = []string{
+	// The distance is in units.distance and the duration is in seconds
+	`CREATE TABLE rides (
+		id INTEGER PRIMARY KEY,
+		fare REAL NOT NULL,
+		distance REAL NOT NULL,
+		duration REAL NOT NULL,
+		points_kept INTEGER NOT NULL,
+		points_removed INTEGER NOT NULL,
+		tariff_version TEXT NOT NULL
+	)`,
+	`CREATE TABLE segments (
+		ride_id INTEGER NOT NULL,
+		segment_index INTEGER NOT NULL,
+		start_latitude REAL NOT NULL,
+		start_longitude REAL NOT NULL,
+		start_timestamp INTEGER NOT NULL,
+		end_latitude REAL NOT NULL,
+		end_longitude REAL NOT NULL,
+		end_timestamp INTEGER NOT NULL,
+		distance REAL NOT NULL,
+		duration REAL NOT NULL,
+		fare REAL NOT NULL,
+		idle INTEGER NOT NULL,
+		night INTEGER NOT NULL,
+		PRIMARY KEY (ride_id, segment_index)
+	)`,
+}
+
+// SQLiteWriter struct type
+// It writes the rides and optionally their segments into a SQLite database. Rides
+// are written in transactions of output.sqlite.batch_size rides and a ride that
+// already exists is replaced
+type SQLiteWriter struct {
+	db            *sql.DB
+	tx            *sql.Tx
+	pending       int
+	batchSize     int
+	segments      bool
+	tariffVersion string
+	distanceUnit  model.Unit
+}
+
+// NewSQLiteWriter opens the SQLite database and migrates its schema
+func NewSQLiteWriter(filePath string) (*SQLiteWriter, error) {
+	if filePath == StdStream {
+		return nil, fmt.Errorf("SQLite output can't be written to the standard output")
+	}
+
+	db, err := sql.Open("sqlite", filePath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// A single connection since SQLite allows one writer at a time
+	db.SetMaxOpenConns(1)
+
+	if err := migrateSQLite(db, sqliteMigrations); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Unable to migrate database %s: %s", filePath, err.Error())
+	}
+
+	writer := &SQLiteWriter{
+		db:            db,
+		batchSize:     viper.GetInt("output.sqlite.batch_size"),
+		segments:      viper.GetBool("output.sqlite.segments"),
+		tariffVersion: viper.GetString("fare.tariff_version"),
+		distanceUnit:  model.GetDistanceUnit(),
+	}
+
+	if writer.batchSize < 1 {
+		writer.batchSize = 1
+	}
+
+	return writer, nil
+}
+
+// Write adds the ride and its segments to the current transaction
+func (s *SQLiteWriter) Write(result RideResult) error {
+	if s.tx == nil {
+		tx, err := s.db.Begin()
+
+		if err != nil {
+			return err
+		}
+
+		s.tx = tx
+	}
+
+	_, err := s.tx.Exec(
+		`INSERT OR REPLACE INTO rides (id, fare, distance, duration, points_kept, points_removed, tariff_version)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		result.RideID,
+		newResultRow(result).Fare,
+		s.distanceUnit.FromKm(result.Metrics.Distance),
+		(result.Metrics.MovingTime+result.Metrics.IdleTime)*3600,
+		result.PointsKept,
+		result.PointsRemoved,
+		s.tariffVersion,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	if s.segments {
+		if err := s.writeSegments(result); err != nil {
+			return err
+		}
+	}
+
+	s.pending++
+
+	if s.pending < s.batchSize {
+		return nil
+	}
+
+	return s.commit()
+}
+
+// Close commits the last transaction and closes the database
+func (s *SQLiteWriter) Close() error {
+	if err := s.commit(); err != nil {
+		s.db.Close()
+		return err
+	}
+
+	return s.db.Close()
+}
+
+// Abort rolls back the current transaction and closes the database
+// The transactions committed before are kept
+func (s *SQLiteWriter) Abort() error {
+	if s.tx != nil {
+		s.tx.Rollback()
+		s.tx = nil
+	}
+
+	return s.db.Close()
+}
+
+// writeSegments replaces the segments of the ride
+func (s *SQLiteWriter) writeSegments(result RideResult) error {
+	if _, err := s.tx.Exec("DELETE FROM segments WHERE ride_id = ?", result.RideID); err != nil {
+		return err
+	}
+
+	for _, segment := range result.Segments {
+		_, err := s.tx.Exec(
+			`INSERT INTO segments (ride_id, segment_index, start_latitude, start_longitude, start_timestamp,
+			end_latitude, end_longitude, end_timestamp, distance, duration, fare, idle, night)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			result.RideID,
+			segment.Index,
+			segment.Start.Latitude,
+			segment.Start.Longitude,
+			segment.Start.Timestamp.Unix(),
+			segment.End.Latitude,
+			segment.End.Longitude,
+			segment.End.Timestamp.Unix(),
+			s.distanceUnit.FromKm(segment.Distance),
+			segment.Duration*3600,
+			segment.Fare,
+			segment.Idle,
+			segment.Night,
+		)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// commit commits the current transaction if any
+func (s *SQLiteWriter) commit() error {
+	if s.tx == nil {
+		return nil
+	}
+
+	err := s.tx.Commit()
+
+	s.tx = nil
+	s.pending = 0
+
+	return err
+}
+
+// migrateSQLite applies the migrations newer than the database schema version
+func migrateSQLite(db *sql.DB, migrations []string) error {
+	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)"); err != nil {
+		return err
+	}
+
+	var version int
+
+	if err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version); err != nil {
+		return err
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := db.Begin()
+
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if _, err := tx.Exec("INSERT INTO schema_migrations (version) VALUES (?)", i+1); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
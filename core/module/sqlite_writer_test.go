@@ -0,0 +1,90 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package module
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+
+	"bitbucket.org/clivern/beat/pkg"
+
+	"github.com/franela/goblin"
+	"github.com/spf13/viper"
+)
+
+// TestSQLiteWriter test cases
+func TestSQLiteWriter(t *testing.T) {
+	baseDir := pkg.GetBaseDir("cache")
+	testDataDir := fmt.Sprintf("%s/%s", baseDir, "testdata")
+	cacheDir := fmt.Sprintf("%s/%s", baseDir, "cache")
+	pkg.LoadConfigs(fmt.Sprintf("%s/config.dist.yml", baseDir))
+
+	g := goblin.Goblin(t)
+
+	g.Describe("SQLiteWriter", func() {
+		g.It("It should write the rides and segments tables", func() {
+			filePath := fmt.Sprintf("%s/sqlite_writer_test01.db", cacheDir)
+			os.Remove(filePath)
+
+			viper.Set("output.sqlite.segments", true)
+			viper.Set("output.sqlite.batch_size", 3)
+
+			channel, err := GenerateData(context.Background(), fmt.Sprintf("%s/test_paths_01.csv", testDataDir), CompressionAuto, CSVLoader{})
+			g.Assert(err).Equal(nil)
+
+			err = StoreData(context.Background(), filePath, "auto", CompressionAuto, ProcessData(context.Background(), channel, &RideProcessor{}))
+			g.Assert(err).Equal(nil)
+
+			// Run twice to check the migrations and the replaced rides
+			channel, err = GenerateData(context.Background(), fmt.Sprintf("%s/test_paths_01.csv", testDataDir), CompressionAuto, CSVLoader{})
+			g.Assert(err).Equal(nil)
+
+			err = StoreData(context.Background(), filePath, OutputSQLite, CompressionAuto, ProcessData(context.Background(), channel, &RideProcessor{}))
+			g.Assert(err).Equal(nil)
+
+			viper.Set("output.sqlite.segments", false)
+			viper.Set("output.sqlite.batch_size", 1000)
+
+			db, err := sql.Open("sqlite", filePath)
+			g.Assert(err).Equal(nil)
+
+			defer db.Close()
+
+			var count, kept, removed, version int
+			var fare, distance, duration float64
+			var tariff string
+
+			g.Assert(db.QueryRow("SELECT COUNT(*) FROM rides").Scan(&count)).Equal(nil)
+			g.Assert(count).Equal(10)
+
+			g.Assert(db.QueryRow("SELECT MAX(version) FROM schema_migrations").Scan(&version)).Equal(nil)
+			g.Assert(version).Equal(len(sqliteMigrations))
+
+			g.Assert(db.QueryRow(
+				"SELECT fare, distance, duration, points_kept, points_removed, tariff_version FROM rides WHERE id = 9",
+			).Scan(&fare, &distance, &duration, &kept, &removed, &tariff)).Equal(nil)
+
+			g.Assert(fare).Equal(3.47)
+			g.Assert(distance > 0).Equal(true)
+			g.Assert(duration > 0).Equal(true)
+			g.Assert(kept + removed).Equal(9)
+			g.Assert(tariff).Equal(viper.GetString("fare.tariff_version"))
+
+			g.Assert(db.QueryRow("SELECT COUNT(*) FROM segments WHERE ride_id = 9").Scan(&count)).Equal(nil)
+			g.Assert(count).Equal(kept - 1)
+		})
+
+		g.It("It should fail for compressed or stream outputs", func() {
+			err := StoreData(context.Background(), fmt.Sprintf("%s/sqlite_writer_test02.db.gz", cacheDir), OutputSQLite, CompressionAuto, sendResults([]RideResult{}))
+			g.Assert(err != nil).Equal(true)
+
+			err = StoreData(context.Background(), StdStream, OutputSQLite, CompressionAuto, sendResults([]RideResult{}))
+			g.Assert(err != nil).Equal(true)
+		})
+	})
+}
@@ -0,0 +1,331 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package module
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"golang.org/x/sync/errgroup"
+)
+
+// TaskWorker struct type
+// It claims the tasks of a coordinator, calculates the rides fares of each task with the
+// processor and uploads the results. An idle worker claims a task every PollInterval and
+// the coordinator is retried for RetryTimeout before giving up
+type TaskWorker struct {
+	ID           string
+	Coordinator  string
+	Processor    *RideProcessor
+	WorkDir      string
+	PollInterval time.Duration
+	RetryTimeout time.Duration
+	Client       *http.Client
+}
+
+// NewTaskWorker creates a new instance of TaskWorker for the coordinator URL like
+// http://127.0.0.1:8080. The task input is downloaded to app.cache_dir
+func NewTaskWorker(coordinator string, processor *RideProcessor) *TaskWorker {
+	hostname, _ := os.Hostname()
+
+	return &TaskWorker{
+		ID:           fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+		Coordinator:  strings.TrimSuffix(coordinator, "/"),
+		Processor:    processor,
+		WorkDir:      viper.GetString("app.cache_dir"),
+		PollInterval: time.Duration(viper.GetFloat64("distributed.poll_interval") * float64(time.Second)),
+		RetryTimeout: time.Duration(viper.GetFloat64("distributed.retry_timeout") * float64(time.Second)),
+		Client:       &http.Client{},
+	}
+}
+
+// Run runs the tasks till the coordinator ends the run and gets the number of done tasks.
+// Closing stop stops claiming tasks once the running task is done, canceling ctx aborts it
+func (w *TaskWorker) Run(ctx context.Context, stop <-chan struct{}) (int, error) {
+	done := 0
+
+	for {
+		select {
+		case <-stop:
+			return done, nil
+		default:
+		}
+
+		task, err := w.claim(ctx, stop)
+
+		if err != nil || task == nil {
+			return done, err
+		}
+
+		if err := w.runTask(ctx, task); err != nil {
+			if ctx.Err() != nil {
+				return done, ctx.Err()
+			}
+
+			log.WithFields(log.Fields{
+				"task_id": task.ID,
+				"attempt": task.Attempt,
+				"reason":  err.Error(),
+			}).Error("Task failed")
+
+			w.fail(ctx, task, err)
+			continue
+		}
+
+		done++
+
+		log.WithFields(log.Fields{
+			"task_id": task.ID,
+			"attempt": task.Attempt,
+		}).Info("Task done")
+	}
+}
+
+// claim gets the next task of the coordinator, no task is returned once the run
+// ended or stop is closed. The coordinator is retried till RetryTimeout passes
+// without an answer
+func (w *TaskWorker) claim(ctx context.Context, stop <-chan struct{}) (*Task, error) {
+	lastAnswer := time.Now()
+
+	for {
+		task, status, err := w.requestTask(ctx)
+
+		if err == nil {
+			lastAnswer = time.Now()
+
+			switch status {
+			case http.StatusOK:
+				return task, nil
+			case http.StatusGone:
+				return nil, nil
+			}
+		} else if ctx.Err() != nil {
+			return nil, ctx.Err()
+		} else if time.Since(lastAnswer) > w.RetryTimeout {
+			return nil, err
+		} else {
+			log.WithField("reason", err.Error()).Warn("Unable to reach the coordinator, retrying")
+		}
+
+		select {
+		case <-time.After(w.PollInterval):
+		case <-stop:
+			return nil, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// requestTask asks the coordinator for a task
+func (w *TaskWorker) requestTask(ctx context.Context) (*Task, int, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/tasks/claim?worker=%s", w.Coordinator, w.ID), nil)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	response, err := w.Client.Do(request)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		task := &Task{}
+
+		if err := json.NewDecoder(response.Body).Decode(task); err != nil {
+			return nil, 0, err
+		}
+
+		return task, response.StatusCode, nil
+	case http.StatusNoContent, http.StatusGone:
+		return nil, response.StatusCode, nil
+	}
+
+	return nil, 0, responseError(response)
+}
+
+// runTask downloads the task input, calculates its rides and uploads the results
+func (w *TaskWorker) runTask(ctx context.Context, task *Task) error {
+	inputFile := filepath.Join(w.WorkDir, fmt.Sprintf("task_%d_%d_%d.input", os.Getpid(), task.ID, task.Attempt))
+	defer os.Remove(inputFile)
+
+	if err := os.MkdirAll(w.WorkDir, 0755); err != nil {
+		return err
+	}
+
+	if err := w.download(ctx, task, inputFile); err != nil {
+		return fmt.Errorf("Unable to download the task input: %s", err.Error())
+	}
+
+	loader, err := NewRideLoader(task.Format)
+
+	if err != nil {
+		return err
+	}
+
+	dataset, err := openDatasets([]string{inputFile}, task.Compression, loader, Position{}, nil)
+
+	if err != nil {
+		return err
+	}
+
+	group, ctx := errgroup.WithContext(ctx)
+	batches := make(chan RideBatch, viper.GetInt("workers.buffers.rides"))
+
+	group.Go(func() error {
+		return readDatasets(ctx, []string{inputFile}, task.Compression, loader, dataset, nil, nil, batches)
+	})
+
+	pool := NewWorkerPoolFromConfig()
+
+	// The coordinator merges the tasks results in the order they are uploaded
+	var order *rideOrder
+	var input <-chan RideBatch = batches
+
+	if task.Ordered {
+		order = newRideOrder(1, reorderBufferSize(pool, 1))
+		input = acquireTokens(ctx, batches, order)
+	}
+
+	results := processData(ctx, input, w.Processor, pool, order, nil)
+
+	group.Go(func() error {
+		return w.upload(ctx, task, results)
+	})
+
+	return group.Wait()
+}
+
+// download writes the task input to a file
+func (w *TaskWorker) download(ctx context.Context, task *Task, filePath string) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, w.taskURL(task, "input"), nil)
+
+	if err != nil {
+		return err
+	}
+
+	response, err := w.Client.Do(request)
+
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return responseError(response)
+	}
+
+	file, err := createAtomicFile(filePath)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(file, response.Body); err != nil {
+		file.Abort()
+		return err
+	}
+
+	return file.Close()
+}
+
+// upload sends the results to the coordinator as gzip compressed JSON Lines
+// while they are calculated
+func (w *TaskWorker) upload(ctx context.Context, task *Task, results <-chan RideResult) error {
+	reader, writer := io.Pipe()
+
+	go func() {
+		writer.CloseWithError(writeTaskResults(writer, results))
+	}()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPut, w.taskURL(task, "results"), reader)
+
+	if err != nil {
+		reader.CloseWithError(err)
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/gzip")
+
+	response, err := w.Client.Do(request)
+
+	if err != nil {
+		reader.CloseWithError(err)
+		return fmt.Errorf("Unable to upload the task results: %s", err.Error())
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		reader.CloseWithError(io.ErrClosedPipe)
+		return fmt.Errorf("Unable to upload the task results: %s", responseError(response).Error())
+	}
+
+	return nil
+}
+
+// fail reports a failed task to the coordinator
+func (w *TaskWorker) fail(ctx context.Context, task *Task, reason error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, w.taskURL(task, "fail"), strings.NewReader(reason.Error()))
+
+	if err != nil {
+		return
+	}
+
+	response, err := w.Client.Do(request)
+
+	if err != nil {
+		log.WithFields(log.Fields{
+			"task_id": task.ID,
+			"reason":  err.Error(),
+		}).Warn("Unable to report the failed task")
+		return
+	}
+
+	response.Body.Close()
+}
+
+// taskURL gets the URL of a task action
+func (w *TaskWorker) taskURL(task *Task, action string) string {
+	return fmt.Sprintf("%s/tasks/%d/%s?attempt=%d", w.Coordinator, task.ID, action, task.Attempt)
+}
+
+// writeTaskResults writes the results as gzip compressed JSON Lines
+func writeTaskResults(writer io.Writer, results <-chan RideResult) error {
+	compressor := gzip.NewWriter(writer)
+	encoder := json.NewEncoder(compressor)
+
+	for result := range results {
+		if err := encoder.Encode(result); err != nil {
+			return err
+		}
+	}
+
+	return compressor.Close()
+}
+
+// responseError gets the error of an unexpected response
+func responseError(response *http.Response) error {
+	message, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1<<10))
+
+	return fmt.Errorf("Unexpected status %s: %s", response.Status, strings.TrimSpace(string(message)))
+}
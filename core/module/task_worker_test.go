@@ -0,0 +1,248 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package module
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"bitbucket.org/clivern/beat/core/util"
+	"bitbucket.org/clivern/beat/pkg"
+
+	"github.com/franela/goblin"
+	"github.com/spf13/viper"
+)
+
+// TestTaskWorker test cases
+func TestTaskWorker(t *testing.T) {
+	baseDir := pkg.GetBaseDir("cache")
+	testDataDir := fmt.Sprintf("%s/%s", baseDir, "testdata")
+	cacheDir := fmt.Sprintf("%s/%s", baseDir, "cache")
+	pkg.LoadConfigs(fmt.Sprintf("%s/config.dist.yml", baseDir))
+
+	g := goblin.Goblin(t)
+
+	datasetFiles := []string{
+		fmt.Sprintf("%s/test_paths_01.csv", testDataDir),
+		fmt.Sprintf("%s/test_paths_02.csv.gz", testDataDir),
+	}
+
+	newWorker := func(server *httptest.Server, id string) *TaskWorker {
+		worker := NewTaskWorker(server.URL, &RideProcessor{})
+		worker.ID = id
+		worker.WorkDir = cacheDir
+		worker.PollInterval = 10 * time.Millisecond
+
+		return worker
+	}
+
+	// runWorkers runs the workers till the coordinator ends the run and gets their done tasks
+	runWorkers := func(workers ...*TaskWorker) []int {
+		done := make([]int, len(workers))
+		errs := make(chan error, len(workers))
+
+		for index, worker := range workers {
+			index, worker := index, worker
+
+			go func() {
+				var err error
+
+				done[index], err = worker.Run(context.Background(), make(chan struct{}))
+				errs <- err
+			}()
+		}
+
+		for range workers {
+			select {
+			case err := <-errs:
+				g.Assert(err).Equal(nil)
+			case <-time.After(10 * time.Second):
+				g.Fail("The workers are blocked")
+			}
+		}
+
+		return done
+	}
+
+	g.Describe("TaskWorker", func() {
+		g.It("It should give the output of a sequential run", func() {
+			defer func() {
+				viper.Set("distributed.task_size", 64)
+				viper.Set("output.ordered", false)
+			}()
+
+			viper.Set("distributed.task_size", 0.0005)
+			viper.Set("output.ordered", true)
+
+			expectedFile := fmt.Sprintf("%s/task_worker_test01.csv", cacheDir)
+
+			err := (&Pipeline{
+				DatasetFiles: datasetFiles,
+				OutputFile:   expectedFile,
+				Format:       OutputCSV,
+				Compression:  CompressionAuto,
+				Loader:       CSVLoader{},
+				Processor:    &RideProcessor{},
+			}).Run(context.Background(), make(chan struct{}))
+			g.Assert(err).Equal(nil)
+
+			coordinator, err := NewCoordinator(datasetFiles, OutputCSV, CompressionAuto, CSVLoader{}, cacheDir)
+			g.Assert(err).Equal(nil)
+			defer coordinator.Close()
+
+			server := httptest.NewServer(coordinator.Handler())
+			defer server.Close()
+
+			done := runWorkers(newWorker(server, "worker-1"), newWorker(server, "worker-2"))
+			g.Assert(done[0] + done[1]).Equal(len(coordinator.Tasks()))
+			g.Assert(coordinator.Wait(context.Background())).Equal(nil)
+
+			outputFile := fmt.Sprintf("%s/task_worker_test02.csv", cacheDir)
+			stats := NewRunStats()
+
+			err = coordinator.Merge(context.Background(), outputFile, OutputCSV, CompressionAuto, stats)
+			g.Assert(err).Equal(nil)
+
+			expected, err := util.ReadFile(expectedFile)
+			g.Assert(err).Equal(nil)
+
+			output, err := util.ReadFile(outputFile)
+			g.Assert(err).Equal(nil)
+			g.Assert(output).Equal(expected)
+			g.Assert(stats.Report().Rides).Equal(int64(11))
+		})
+
+		g.It("It should join the rides spanning dataset files like a sequential run", func() {
+			defer func() {
+				viper.Set("distributed.task_size", 64)
+				viper.Set("output.ordered", false)
+			}()
+
+			viper.Set("distributed.task_size", 0.0001)
+			viper.Set("output.ordered", true)
+
+			// Ride 3 starts in the first file, goes through the second one and ends in the third one
+			chainFile := fmt.Sprintf("%s/task_worker_test03.csv", cacheDir)
+			g.Assert(ioutil.WriteFile(chainFile, []byte("3,37.946300,23.754800,1405591098\n"), 0644)).Equal(nil)
+
+			content, err := ioutil.ReadFile(fmt.Sprintf("%s/test_paths_05_02.csv", testDataDir))
+			g.Assert(err).Equal(nil)
+
+			var compressed bytes.Buffer
+
+			compressor := gzip.NewWriter(&compressed)
+			compressor.Write(content)
+			g.Assert(compressor.Close()).Equal(nil)
+
+			compressedFile := fmt.Sprintf("%s/task_worker_test04.csv.gz", cacheDir)
+			g.Assert(ioutil.WriteFile(compressedFile, compressed.Bytes(), 0644)).Equal(nil)
+
+			for _, files := range [][]string{
+				{fmt.Sprintf("%s/test_paths_05_01.csv", testDataDir), fmt.Sprintf("%s/test_paths_05_02.csv", testDataDir)},
+				{fmt.Sprintf("%s/test_paths_05_01.csv", testDataDir), chainFile, compressedFile},
+			} {
+				expectedFile := fmt.Sprintf("%s/task_worker_test05.csv", cacheDir)
+
+				err := (&Pipeline{
+					DatasetFiles: files,
+					OutputFile:   expectedFile,
+					Format:       OutputCSV,
+					Compression:  CompressionAuto,
+					Loader:       CSVLoader{},
+					Processor:    &RideProcessor{},
+				}).Run(context.Background(), make(chan struct{}))
+				g.Assert(err).Equal(nil)
+
+				coordinator, err := NewCoordinator(files, OutputCSV, CompressionAuto, CSVLoader{}, cacheDir)
+				g.Assert(err).Equal(nil)
+
+				server := httptest.NewServer(coordinator.Handler())
+
+				runWorkers(newWorker(server, "worker-1"), newWorker(server, "worker-2"))
+				g.Assert(coordinator.Wait(context.Background())).Equal(nil)
+
+				outputFile := fmt.Sprintf("%s/task_worker_test06.csv", cacheDir)
+
+				err = coordinator.Merge(context.Background(), outputFile, OutputCSV, CompressionAuto, nil)
+				g.Assert(err).Equal(nil)
+
+				server.Close()
+				coordinator.Close()
+
+				expected, err := util.ReadFile(expectedFile)
+				g.Assert(err).Equal(nil)
+
+				output, err := util.ReadFile(outputFile)
+				g.Assert(err).Equal(nil)
+				g.Assert(output).Equal(expected)
+				g.Assert(strings.Count(output, "\n3,")).Equal(1)
+			}
+		})
+
+		g.It("It should report the failed tasks", func() {
+			coordinator, err := NewCoordinator(datasetFiles[:1], "xml", CompressionAuto, CSVLoader{}, cacheDir)
+			g.Assert(err).Equal(nil)
+			defer coordinator.Close()
+
+			server := httptest.NewServer(coordinator.Handler())
+			defer server.Close()
+
+			// The workers end once the task failed max_attempts times
+			done := runWorkers(newWorker(server, "worker-1"), newWorker(server, "worker-2"))
+			g.Assert(done).Equal([]int{0, 0})
+			g.Assert(coordinator.Wait(context.Background()) != nil).Equal(true)
+			g.Assert(coordinator.Status().Failures).Equal(viper.GetInt("distributed.max_attempts"))
+		})
+
+		g.It("It should stop retrying an unreachable coordinator", func() {
+			server := httptest.NewServer(nil)
+			server.Close()
+
+			worker := newWorker(server, "worker-1")
+			worker.RetryTimeout = 30 * time.Millisecond
+
+			done, err := worker.Run(context.Background(), make(chan struct{}))
+			g.Assert(done).Equal(0)
+			g.Assert(err != nil).Equal(true)
+		})
+
+		g.It("It should stop claiming tasks once stopped", func() {
+			coordinator, err := NewCoordinator(datasetFiles[:1], OutputCSV, CompressionAuto, CSVLoader{}, cacheDir)
+			g.Assert(err).Equal(nil)
+			defer coordinator.Close()
+
+			server := httptest.NewServer(coordinator.Handler())
+			defer server.Close()
+
+			stop := make(chan struct{})
+			close(stop)
+
+			done, err := newWorker(server, "worker-1").Run(context.Background(), stop)
+			g.Assert(done).Equal(0)
+			g.Assert(err).Equal(nil)
+			g.Assert(coordinator.Status().Pending).Equal(1)
+		})
+
+		g.It("It should only upload the segments of the rides for the SQLite segments output", func() {
+			var buffer bytes.Buffer
+
+			g.Assert(writeTaskResults(&buffer, sendResults([]RideResult{{RideID: 1, Fare: 58.3}}))).Equal(nil)
+
+			reader, err := gzip.NewReader(&buffer)
+			g.Assert(err).Equal(nil)
+
+			content, err := ioutil.ReadAll(reader)
+			g.Assert(err).Equal(nil)
+			g.Assert(strings.Contains(string(content), "Segments")).Equal(false)
+		})
+	})
+}
@@ -0,0 +1,247 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package module
+
+import (
+	"context"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// tuneTolerance is the throughput change between two measurements
+// considered as noise by the auto tuning
+const tuneTolerance = 0.05
+
+// WorkerPool struct type
+// It runs a task on many goroutines till the task has no more work. With auto
+// tuning, the throughput is measured every interval and the workers grow or
+// shrink between Min and Max to find the size with the best throughput
+type WorkerPool struct {
+	Size     int
+	Min      int
+	Max      int
+	AutoTune bool
+	Interval time.Duration
+
+	workers   int64
+	completed int64
+}
+
+// NewWorkerPool creates a new instance of WorkerPool with a fixed size
+// A size of 0 or less uses GOMAXPROCS
+func NewWorkerPool(size int) *WorkerPool {
+	if size <= 0 {
+		size = runtime.GOMAXPROCS(0)
+	}
+
+	return &WorkerPool{
+		Size:     size,
+		Min:      size,
+		Max:      size,
+		Interval: 2 * time.Second,
+	}
+}
+
+// NewWorkerPoolFromConfig creates a new instance of WorkerPool from the workers config
+func NewWorkerPoolFromConfig() *WorkerPool {
+	size := viper.GetInt("workers.size")
+
+	// Older config files set the size with app.max_goroutines
+	if size <= 0 && viper.GetInt("app.max_goroutines") > 0 {
+		log.WithField("stage", StageProcess).Warn("app.max_goroutines is deprecated, use workers.size")
+		size = viper.GetInt("app.max_goroutines")
+	}
+
+	pool := NewWorkerPool(size)
+
+	if !viper.GetBool("workers.auto_tune.enabled") {
+		return pool
+	}
+
+	pool.AutoTune = true
+	pool.Min = viper.GetInt("workers.auto_tune.min")
+	pool.Max = viper.GetInt("workers.auto_tune.max")
+
+	if pool.Min <= 0 {
+		pool.Min = 1
+	}
+
+	if pool.Max <= 0 {
+		pool.Max = 4 * runtime.GOMAXPROCS(0)
+	}
+
+	if pool.Max < pool.Min {
+		pool.Max = pool.Min
+	}
+
+	pool.Size = clampInt(pool.Size, pool.Min, pool.Max)
+
+	if interval := viper.GetFloat64("workers.auto_tune.interval"); interval > 0 {
+		pool.Interval = time.Duration(interval * float64(time.Second))
+	}
+
+	return pool
+}
+
+// MaxWorkers gets the max number of workers the pool may run
+func (p *WorkerPool) MaxWorkers() int {
+	if p.AutoTune {
+		return p.Max
+	}
+
+	return p.Size
+}
+
+// Workers gets the number of running workers
+func (p *WorkerPool) Workers() int {
+	return int(atomic.LoadInt64(&p.workers))
+}
+
+// Run runs the task on the pool workers and returns once all workers are done. A worker
+// runs the task till it returns false to tell there is no more work, a worker stopped by
+// the auto tuning finishes its current task first. Auto tuning stops once ctx is done
+func (p *WorkerPool) Run(ctx context.Context, task func() bool) {
+	maxWorkers := p.MaxWorkers()
+	exits := make(chan bool)
+	running := 0
+	finished := false
+
+	// The stop channels of the workers not asked to stop yet, a stopped worker
+	// leaves it once asked so the retiring workers are never counted
+	var active []chan struct{}
+
+	start := func(count int) {
+		for i := 0; i < count; i++ {
+			stop := make(chan struct{})
+			active = append(active, stop)
+			running++
+			atomic.AddInt64(&p.workers, 1)
+
+			go func() {
+				for {
+					select {
+					case <-stop:
+						atomic.AddInt64(&p.workers, -1)
+						exits <- false
+						return
+					default:
+					}
+
+					if !task() {
+						atomic.AddInt64(&p.workers, -1)
+						exits <- true
+						return
+					}
+
+					atomic.AddInt64(&p.completed, 1)
+				}
+			}()
+		}
+	}
+
+	start(clampInt(p.Size, 1, maxWorkers))
+
+	var ticks <-chan time.Time
+
+	if p.AutoTune {
+		ticker := time.NewTicker(p.Interval)
+		defer ticker.Stop()
+
+		ticks = ticker.C
+	}
+
+	var lastCompleted int64
+	var lastThroughput float64
+	direction := 1
+
+	for running > 0 {
+		select {
+		case done := <-exits:
+			running--
+			finished = finished || done
+
+		case <-ticks:
+			completed := atomic.LoadInt64(&p.completed)
+			throughput := float64(completed-lastCompleted) / p.Interval.Seconds()
+			lastCompleted = completed
+
+			if finished || ctx.Err() != nil {
+				continue
+			}
+
+			current := len(active)
+
+			var target int
+			target, direction = tuneWorkers(current, direction, throughput, lastThroughput, p.Min, p.Max)
+			lastThroughput = throughput
+
+			if target == current {
+				continue
+			}
+
+			log.WithFields(log.Fields{
+				"stage":      StageProcess,
+				"workers":    target,
+				"throughput": throughput,
+			}).Debug("Worker pool resized")
+
+			if current < target {
+				start(target - current)
+			}
+
+			for ; current > target; current-- {
+				close(active[current-1])
+				active = active[:current-1]
+			}
+		}
+	}
+}
+
+// tuneWorkers gets the next number of workers and the direction of the change by hill
+// climbing on the throughput. The direction is reversed once the throughput drops and a
+// steady throughput shrinks the pool so the fewest workers reaching it are kept
+func tuneWorkers(current, direction int, throughput, lastThroughput float64, min, max int) (int, int) {
+	if lastThroughput > 0 {
+		change := (throughput - lastThroughput) / lastThroughput
+
+		if change < -tuneTolerance {
+			direction = -direction
+		} else if change <= tuneTolerance {
+			direction = -1
+		}
+	}
+
+	step := current / 4
+
+	if step < 1 {
+		step = 1
+	}
+
+	target := clampInt(current+direction*step, min, max)
+
+	// Turn back at the bounds
+	if target == current {
+		direction = -direction
+	}
+
+	return target, direction
+}
+
+// clampInt limits a value between min and max
+func clampInt(value, min, max int) int {
+	if value < min {
+		return min
+	}
+
+	if value > max {
+		return max
+	}
+
+	return value
+}
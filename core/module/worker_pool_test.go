@@ -0,0 +1,165 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package module
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"bitbucket.org/clivern/beat/pkg"
+
+	"github.com/franela/goblin"
+	"github.com/spf13/viper"
+)
+
+// TestWorkerPool test cases
+func TestWorkerPool(t *testing.T) {
+	baseDir := pkg.GetBaseDir("cache")
+	pkg.LoadConfigs(fmt.Sprintf("%s/config.dist.yml", baseDir))
+
+	g := goblin.Goblin(t)
+
+	// runTasks runs count tasks on the pool and gets the done tasks and the peak of running tasks
+	runTasks := func(pool *WorkerPool, count int, duration time.Duration) (int64, int64) {
+		tasks := make(chan int, count)
+
+		for i := 0; i < count; i++ {
+			tasks <- i
+		}
+
+		close(tasks)
+
+		var done, running, peak int64
+
+		pool.Run(context.Background(), func() bool {
+			if _, ok := <-tasks; !ok {
+				return false
+			}
+
+			current := atomic.AddInt64(&running, 1)
+
+			for {
+				last := atomic.LoadInt64(&peak)
+
+				if current <= last || atomic.CompareAndSwapInt64(&peak, last, current) {
+					break
+				}
+			}
+
+			time.Sleep(duration)
+			atomic.AddInt64(&running, -1)
+			atomic.AddInt64(&done, 1)
+
+			return true
+		})
+
+		return done, peak
+	}
+
+	g.Describe("WorkerPool", func() {
+		g.It("It should default to GOMAXPROCS workers", func() {
+			pool := NewWorkerPoolFromConfig()
+			g.Assert(pool.Size).Equal(runtime.GOMAXPROCS(0))
+			g.Assert(pool.MaxWorkers()).Equal(runtime.GOMAXPROCS(0))
+			g.Assert(pool.AutoTune).Equal(false)
+		})
+
+		g.It("It should read the workers config", func() {
+			defer func() {
+				viper.Set("workers.size", 0)
+				viper.Set("workers.auto_tune.enabled", false)
+				viper.Set("workers.auto_tune.max", 0)
+				viper.Set("app.max_goroutines", 0)
+			}()
+
+			// The size of older config files
+			viper.Set("app.max_goroutines", 7)
+			g.Assert(NewWorkerPoolFromConfig().Size).Equal(7)
+
+			viper.Set("workers.size", 3)
+			g.Assert(NewWorkerPoolFromConfig().Size).Equal(3)
+
+			viper.Set("workers.size", 30)
+			viper.Set("workers.auto_tune.enabled", true)
+			viper.Set("workers.auto_tune.max", 10)
+
+			pool := NewWorkerPoolFromConfig()
+			g.Assert(pool.AutoTune).Equal(true)
+			g.Assert(pool.Min).Equal(1)
+			g.Assert(pool.MaxWorkers()).Equal(10)
+			g.Assert(pool.Size).Equal(10)
+			g.Assert(pool.Interval).Equal(2 * time.Second)
+		})
+
+		g.It("It should run all tasks on a fixed number of workers", func() {
+			pool := NewWorkerPool(4)
+
+			done, peak := runTasks(pool, 40, time.Millisecond)
+			g.Assert(done).Equal(int64(40))
+			g.Assert(peak <= 4).Equal(true)
+			g.Assert(pool.Workers()).Equal(0)
+		})
+
+		g.It("It should run all tasks within the bounds while auto tuning", func() {
+			pool := &WorkerPool{Size: 1, Min: 1, Max: 6, AutoTune: true, Interval: 5 * time.Millisecond}
+
+			done, peak := runTasks(pool, 300, time.Millisecond)
+			g.Assert(done).Equal(int64(300))
+			g.Assert(peak > 1).Equal(true)
+			g.Assert(peak <= 6).Equal(true)
+			g.Assert(pool.Workers()).Equal(0)
+		})
+
+		g.It("It should keep the min workers till all tasks are done while shrinking", func() {
+			for i := 0; i < 5; i++ {
+				pool := &WorkerPool{Size: 4, Min: 1, Max: 4, AutoTune: true, Interval: time.Millisecond}
+				result := make(chan int64, 1)
+
+				go func() {
+					done, _ := runTasks(pool, 200, time.Microsecond)
+					result <- done
+				}()
+
+				select {
+				case done := <-result:
+					g.Assert(done).Equal(int64(200))
+				case <-time.After(10 * time.Second):
+					g.Fail("The worker pool is blocked")
+				}
+			}
+		})
+
+		g.It("It should climb towards the best throughput", func() {
+			// The first measurement grows the pool
+			workers, direction := tuneWorkers(4, 1, 100, 0, 1, 16)
+			g.Assert(workers).Equal(5)
+			g.Assert(direction).Equal(1)
+
+			// A better throughput keeps the direction
+			workers, direction = tuneWorkers(8, 1, 200, 100, 1, 16)
+			g.Assert(workers).Equal(10)
+			g.Assert(direction).Equal(1)
+
+			// A worse throughput reverses the direction
+			workers, direction = tuneWorkers(10, 1, 150, 200, 1, 16)
+			g.Assert(workers).Equal(8)
+			g.Assert(direction).Equal(-1)
+
+			// A steady throughput shrinks the pool
+			workers, direction = tuneWorkers(8, 1, 201, 200, 1, 16)
+			g.Assert(workers).Equal(6)
+			g.Assert(direction).Equal(-1)
+
+			// The bounds turn the direction back
+			workers, direction = tuneWorkers(16, 1, 300, 200, 1, 16)
+			g.Assert(workers).Equal(16)
+			g.Assert(direction).Equal(-1)
+		})
+	})
+}
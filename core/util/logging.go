@@ -0,0 +1,79 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package util
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// LogSampler struct type
+// It lets one of every rate calls log so very chatty logs stay readable
+// A rate of 1 or less logs every call
+type LogSampler struct {
+	rate  uint64
+	count uint64
+}
+
+// SegmentLogSampler samples the per-segment debug logs
+var SegmentLogSampler = NewLogSampler(1)
+
+// NewLogSampler creates a new instance of LogSampler
+func NewLogSampler(rate int) *LogSampler {
+	sampler := &LogSampler{}
+	sampler.SetRate(rate)
+
+	return sampler
+}
+
+// SetRate sets the sampling rate
+func (s *LogSampler) SetRate(rate int) {
+	if rate < 1 {
+		rate = 1
+	}
+
+	atomic.StoreUint64(&s.rate, uint64(rate))
+}
+
+// Rate gets the sampling rate
+func (s *LogSampler) Rate() int {
+	return int(atomic.LoadUint64(&s.rate))
+}
+
+// Sample checks if the current call should log
+func (s *LogSampler) Sample() bool {
+	count := atomic.AddUint64(&s.count, 1)
+
+	return (count-1)%atomic.LoadUint64(&s.rate) == 0
+}
+
+// ConfigureLogger sets the log level (debug, info, warn or error) and the log
+// format (json or text). An empty value keeps the current setting
+func ConfigureLogger(level, format string) error {
+	if level != "" {
+		logLevel, err := log.ParseLevel(level)
+
+		if err != nil {
+			return fmt.Errorf("Invalid log level %s", level)
+		}
+
+		log.SetLevel(logLevel)
+	}
+
+	switch strings.ToLower(format) {
+	case "":
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	case "text":
+		log.SetFormatter(&log.TextFormatter{FullTimestamp: true})
+	default:
+		return fmt.Errorf("Invalid log format %s, use json or text", format)
+	}
+
+	return nil
+}
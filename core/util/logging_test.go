@@ -0,0 +1,81 @@
+// Copyright 2020 Clivern. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/franela/goblin"
+	log "github.com/sirupsen/logrus"
+)
+
+// TestLogSampler test cases
+func TestLogSampler(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("LogSampler", func() {
+		g.It("It should let one of every rate calls log", func() {
+			sampler := NewLogSampler(3)
+
+			var sampled []bool
+
+			for i := 0; i < 7; i++ {
+				sampled = append(sampled, sampler.Sample())
+			}
+
+			g.Assert(sampled).Equal([]bool{true, false, false, true, false, false, true})
+		})
+
+		g.It("It should log every call with a rate of 1 or less", func() {
+			sampler := NewLogSampler(0)
+			g.Assert(sampler.Rate()).Equal(1)
+
+			for i := 0; i < 3; i++ {
+				g.Assert(sampler.Sample()).Equal(true)
+			}
+		})
+	})
+}
+
+// TestConfigureLogger test cases
+func TestConfigureLogger(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("ConfigureLogger", func() {
+		g.It("It should set the log level and format", func() {
+			logger := log.StandardLogger()
+			level, formatter, output := logger.Level, logger.Formatter, logger.Out
+
+			defer func() {
+				log.SetLevel(level)
+				log.SetFormatter(formatter)
+				log.SetOutput(output)
+			}()
+
+			var buffer bytes.Buffer
+			log.SetOutput(&buffer)
+
+			g.Assert(ConfigureLogger("debug", "json")).Equal(nil)
+			g.Assert(log.GetLevel()).Equal(log.DebugLevel)
+
+			log.WithFields(log.Fields{"ride_id": 1, "stage": "fare"}).Debug("Ride fare calculated")
+
+			entry := make(map[string]interface{})
+			g.Assert(json.Unmarshal(buffer.Bytes(), &entry)).Equal(nil)
+			g.Assert(entry["ride_id"]).Equal(float64(1))
+			g.Assert(entry["stage"]).Equal("fare")
+			g.Assert(entry["msg"]).Equal("Ride fare calculated")
+
+			// Empty values keep the current settings
+			g.Assert(ConfigureLogger("", "")).Equal(nil)
+			g.Assert(log.GetLevel()).Equal(log.DebugLevel)
+
+			g.Assert(ConfigureLogger("verbose", "json") != nil).Equal(true)
+			g.Assert(ConfigureLogger("info", "xml") != nil).Equal(true)
+		})
+	})
+}
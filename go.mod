@@ -3,10 +3,16 @@ module bitbucket.org/clivern/beat
 go 1.15
 
 require (
-	github.com/briandowns/spinner v1.12.0
 	github.com/franela/goblin v0.0.0-20201006155558-6240afcb2eb7
+	github.com/klauspost/compress v1.11.4
 	github.com/logrusorgru/aurora/v3 v3.0.0
+	github.com/paulmach/osm v0.8.0
+	github.com/prometheus/client_golang v1.9.0
 	github.com/sirupsen/logrus v1.7.0
 	github.com/spf13/cobra v1.1.1
 	github.com/spf13/viper v1.7.1
+	github.com/xitongsys/parquet-go v1.5.4
+	github.com/xitongsys/parquet-go-source v0.0.0-20200817004010-026bad9b25d0
+	golang.org/x/sync v0.0.0-20201020160332-67f06af15bc9
+	modernc.org/sqlite v1.10.6
 )